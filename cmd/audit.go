@@ -0,0 +1,79 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/audit"
+)
+
+const outputJSONL = "jsonl"
+
+func (c *cli) auditExport(ctx context.Context, args []string) error {
+	fs := newFlagSet("audit export")
+	appId := fs.String("app", "", "application id")
+	profileId := fs.String("profile", "", "configuration profile id")
+	since := fs.String("since", "", "only records since a duration ago, e.g. 24h, or a date, e.g. 2025-06-01")
+	file := fs.String("file", "", "write to this file instead of stdout")
+	format := fs.String("format", outputJSONL, "jsonl or csv")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *format != outputJSONL && *format != outputCSV {
+		return usageErrorf("audit export: --format must be jsonl or csv")
+	}
+	filter := audit.Filter{App: *appId, Profile: *profileId}
+	if *since != "" {
+		t, err := parseSince(*since, time.Now())
+		if err != nil {
+			return usageErrorf("audit export: --since: %v", err)
+		}
+		filter.Since = t
+	}
+
+	records, warnings, err := c.audit.Read()
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(c.errOut, "warning: %s\n", warning)
+	}
+	records = audit.Select(records, filter)
+
+	var buf bytes.Buffer
+	if *format == outputCSV {
+		err = audit.WriteCSV(&buf, records)
+	} else {
+		err = audit.WriteJSONL(&buf, records)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *file == "" {
+		_, err = c.out.Write(buf.Bytes())
+		return err
+	}
+	// records hold ARNs and flag values, like the log itself
+	if err := os.WriteFile(*file, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Exported %d records of %s to %s\n", len(records), c.audit.Path(), *file)
+	return nil
+}
+
+// parseSince reads a duration before now or a date
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a duration nor a date", s)
+}
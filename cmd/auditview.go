@@ -0,0 +1,142 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/audit"
+)
+
+// AuditItem is a change recorded in the audit log
+type AuditItem struct {
+	record  audit.Record
+	envName string
+}
+
+func (i AuditItem) FilterValue() string {
+	return fmt.Sprintf("%s  %-12s %s", i.record.Time.Local().Format("2006-01-02 15:04"), shortActor(i.record.Actor), i.record.Summary(i.envName))
+}
+
+// shortActor drops the account and role of an ARN, the session name is
+// who made the change
+func shortActor(actor string) string {
+	if i := strings.LastIndex(actor, "/"); i >= 0 {
+		return actor[i+1:]
+	}
+	return actor
+}
+
+// AuditView lists the changes made to a configuration profile through
+// lazyflags, most recent first, and shows one at a time.
+//
+// CLI output of a change looks like this:
+//
+// ┌─ Deployment 2 ───────────────────────────────────────────────────────────┐
+// │                                                                          │
+// │  2025-06-01 12:00 by arn:aws:sts::123456789012:assumed-role/dev/ann      │
+// │  deployed version 4 to production                                        │
+// │                                                                          │
+// │  ~ dark_mode                                                             │
+// │      enabled: off -> on                                                  │
+// │                                                                          │
+// └──────────────────────────────────────────────────────────────────────────┘
+type AuditView struct {
+	list  *ListPanel
+	width int
+
+	// record shown, the list shows while showing is false
+	showing bool
+	item    AuditItem
+	// why the log couldn't be read, or how many lines of it were skipped
+	status string
+}
+
+func NewAuditView(height int, width int) *AuditView {
+	return &AuditView{
+		list:  NewListPanel(height, width, "Audit log", []list.Item{}),
+		width: width,
+	}
+}
+
+// SetData replaces the records listed, they're shown most recent first
+func (v *AuditView) SetData(records []audit.Record, envNames map[string]string) tea.Cmd {
+	items := make([]list.Item, 0, len(records))
+	for i := len(records) - 1; i >= 0; i-- {
+		items = append(items, AuditItem{record: records[i], envName: envNames[records[i].Env]})
+	}
+	v.showing = false
+	return v.list.SetItems(items)
+}
+
+func (v *AuditView) SetStatus(status string) {
+	v.status = status
+}
+
+// Show opens the record highlighted in the list
+func (v *AuditView) Show() {
+	item, ok := v.list.SelectedItem()
+	if !ok {
+		return
+	}
+	if auditItem, ok := item.(AuditItem); ok {
+		v.showing = true
+		v.item = auditItem
+	}
+}
+
+func (v *AuditView) IsShowing() bool {
+	return v.showing
+}
+
+func (v *AuditView) Close() {
+	v.showing = false
+}
+
+func (v *AuditView) HandleMsg(msg tea.Msg) tea.Cmd {
+	if v.showing {
+		return nil
+	}
+	return v.list.HandleMsg(msg)
+}
+
+func (v *AuditView) Render() string {
+	if v.showing {
+		return v.renderRecord()
+	}
+	if len(v.list.model.Items()) == 0 {
+		msg := "No changes made through lazyflags yet"
+		if v.status != "" {
+			msg = v.status
+		}
+		return v.list.RenderError(msg)
+	}
+	if v.status != "" {
+		return RenderPanel(v.list.model.View()+"\n"+v.status, v.list.title, v.width)
+	}
+	return v.list.Render()
+}
+
+func (v *AuditView) renderRecord() string {
+	r := v.item.record
+
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("%s by %s\n", r.Time.Local().Format("2006-01-02 15:04"), r.Actor))
+	content.WriteString(r.Summary(v.item.envName) + "\n")
+	if r.Description != "" {
+		content.WriteString(r.Description + "\n")
+	}
+	content.WriteString("\n")
+	if len(r.Changes) == 0 {
+		content.WriteString("No changes\n")
+	} else {
+		content.WriteString(r.Changes.String())
+	}
+
+	title := fmt.Sprintf("Version %d", r.Version)
+	if r.Deployment > 0 {
+		title = fmt.Sprintf("Deployment %d", r.Deployment)
+	}
+	return RenderPanel(content.String(), title, v.width)
+}
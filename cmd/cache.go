@@ -0,0 +1,286 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/filecache"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+	"github.com/zalando/go-keyring"
+)
+
+// cacheFilter selects cache entries, empty fields match every entry
+type cacheFilter struct {
+	account *string
+	region  *string
+	kind    *string
+	app     *string
+	profile *string
+}
+
+func addCacheFilterFlags(fs *flag.FlagSet) cacheFilter {
+	return cacheFilter{
+		account: fs.String("account", "", "AWS account id"),
+		region:  fs.String("region", "", "AWS region"),
+		kind:    fs.String("kind", "", "flags, apps, profiles or environments"),
+		app:     fs.String("app", "", "application id"),
+		profile: fs.String("profile", "", "configuration profile id"),
+	}
+}
+
+func (f cacheFilter) isEmpty() bool {
+	return *f.account == "" && *f.region == "" && *f.kind == "" && *f.app == "" && *f.profile == ""
+}
+
+func (f cacheFilter) match(entry filecache.Entry[json.RawMessage]) bool {
+	appId, configId := splitCacheKey(entry)
+	return (*f.account == "" || *f.account == entry.Identity.Account) &&
+		(*f.region == "" || *f.region == entry.Identity.Region) &&
+		(*f.kind == "" || *f.kind == entry.Kind) &&
+		(*f.app == "" || *f.app == appId) &&
+		(*f.profile == "" || *f.profile == configId)
+}
+
+// splitCacheKey returns the app and config an entry belongs to, the apps
+// belong to neither and lists of an app to no config
+func splitCacheKey(entry filecache.Entry[json.RawMessage]) (appId string, configId string) {
+	switch entry.Kind {
+	case filecache.Flags.String():
+		appId, configId, _ = strings.Cut(entry.Key, ":")
+		return appId, configId
+	case filecache.Apps.String():
+		return "", ""
+	default:
+		return entry.Key, ""
+	}
+}
+
+// countItems returns how many environments, apps, profiles... an entry
+// holds
+func countItems(entry filecache.Entry[json.RawMessage]) int {
+	var items []json.RawMessage
+	if err := json.Unmarshal(entry.Value, &items); err != nil {
+		return 0
+	}
+	return len(items)
+}
+
+// openCache opens the file cache encrypted as the settings ask. Without an
+// identity, as the cache commands open it, it lists entries of every
+// identity and needs no AWS access.
+func openCache(cacheConfig settings.Cache, opts ...filecache.Option) (*filecache.Cache, error) {
+	switch cacheConfig.Encryption {
+	case settings.EncryptionKeyring:
+		key, err := cacheKey()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, filecache.WithKey(key))
+	case settings.EncryptionPassphrase:
+		passphrase := os.Getenv(passphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("cache.encryption is %s but %s is not set", settings.EncryptionPassphrase, passphraseEnv)
+		}
+		opts = append(opts, filecache.WithPassphrase(passphrase))
+	}
+	cache, err := filecache.New(opts...)
+	if errors.Is(err, filecache.ErrSealed) {
+		return nil, fmt.Errorf("%w; check cache.encryption and the key, or remove the cache with `lazyflags cache clear`", err)
+	}
+	return cache, err
+}
+
+const (
+	passphraseEnv = "LAZYFLAGS_CACHE_PASSPHRASE"
+
+	// where the key of the cache file is kept in the OS keyring
+	keyringService = "lazyflags"
+	keyringUser    = "cache-key"
+)
+
+// cacheKey returns the key of the cache file from the OS keyring, the
+// first call creates it
+func cacheKey() ([]byte, error) {
+	secret, err := keyring.Get(keyringService, keyringUser)
+	if errors.Is(err, keyring.ErrNotFound) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := keyring.Set(keyringService, keyringUser, base64.StdEncoding.EncodeToString(key)); err != nil {
+			return nil, fmt.Errorf("keyring: %w", err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("keyring: %w", err)
+	}
+	return base64.StdEncoding.DecodeString(secret)
+}
+
+func (c *cli) cacheList(ctx context.Context, args []string) error {
+	fs := newFlagSet("cache list")
+	filter := addCacheFilterFlags(fs)
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	cache, err := openCache(c.cacheConfig)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tREGION\tKIND\tAPP\tPROFILE\tITEMS\tCACHED\tSTATUS")
+	for _, entry := range cache.Entries() {
+		if !filter.match(entry) {
+			continue
+		}
+		appId, configId := splitCacheKey(entry)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			orDash(entry.Identity.Account), orDash(entry.Identity.Region), entry.Kind, orDash(appId), orDash(configId),
+			countItems(entry), formatCacheTime(entry.Cached), cacheStatus(entry))
+	}
+	return w.Flush()
+}
+
+func (c *cli) cacheShow(ctx context.Context, args []string) error {
+	fs := newFlagSet("cache show")
+	filter := addCacheFilterFlags(fs)
+	output := fs.String("output", outputText, "output format")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "app", "profile"); err != nil {
+		return err
+	}
+	if err := validateOutputFormat(*output); err != nil {
+		return err
+	}
+	// the lists are shown by the list commands
+	if *filter.kind != "" && *filter.kind != filecache.Flags.String() {
+		return usageErrorf("cache show: only cached flags can be shown, not %s", *filter.kind)
+	}
+	*filter.kind = filecache.Flags.String()
+
+	cache, err := openCache(c.cacheConfig)
+	if err != nil {
+		return err
+	}
+
+	var matches []filecache.Entry[json.RawMessage]
+	for _, entry := range cache.Entries() {
+		if filter.match(entry) {
+			matches = append(matches, entry)
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return fmt.Errorf("no cached flags for %s:%s", *filter.app, *filter.profile)
+	case len(matches) > 1:
+		return usageErrorf("cache show: %d accounts or regions have cached these flags, pick one with --account and --region", len(matches))
+	}
+
+	entry := matches[0]
+	if *output == outputText {
+		w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Account:\t%s\n", orDash(entry.Identity.Account))
+		fmt.Fprintf(w, "Region:\t%s\n", orDash(entry.Identity.Region))
+		if entry.Identity.Endpoint != "" {
+			fmt.Fprintf(w, "Endpoint:\t%s\n", entry.Identity.Endpoint)
+		}
+		if entry.Identity.Profile != "" {
+			fmt.Fprintf(w, "AWS profile:\t%s\n", entry.Identity.Profile)
+		}
+		fmt.Fprintf(w, "Cached:\t%s\n", formatCacheTime(entry.Cached))
+		fmt.Fprintf(w, "Expires:\t%s (%s)\n", formatCacheTime(entry.Expires), cacheStatus(entry))
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out)
+	}
+
+	var results []appconfig.Result
+	if err := json.Unmarshal(entry.Value, &results); err != nil {
+		return fmt.Errorf("cached flags of %s:%s can't be read: %w", *filter.app, *filter.profile, err)
+	}
+	envOrder := make([]string, 0, len(results))
+	for _, result := range results {
+		envOrder = append(envOrder, result.EnvName)
+	}
+	return WriteFlagsTable(c.out, pivotResults(results, envOrder), *output)
+}
+
+func (c *cli) cachePurge(ctx context.Context, args []string) error {
+	fs := newFlagSet("cache purge")
+	filter := addCacheFilterFlags(fs)
+	all := fs.Bool("all", false, "purge every entry")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if filter.isEmpty() && !*all {
+		return usageErrorf("cache purge: pass --all or at least one of --account, --region, --kind, --app and --profile")
+	}
+
+	cache, err := openCache(c.cacheConfig)
+	if err != nil {
+		return err
+	}
+
+	purged, err := cache.Purge(filter.match)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Purged %d cached entries\n", purged)
+	return nil
+}
+
+// cacheClear removes the cache file, also when it can't be decrypted and
+// cache purge can't read it
+func (c *cli) cacheClear(ctx context.Context, args []string) error {
+	fs := newFlagSet("cache clear")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := filecache.Clear(); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, "Removed the cache file")
+	return nil
+}
+
+// entries of cache files of older versions have no identity or time
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func formatCacheTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+func cacheStatus(entry filecache.Entry[json.RawMessage]) string {
+	switch {
+	case entry.Identity.IsZero():
+		return "unused"
+	case entry.Stale:
+		return "expired"
+	default:
+		return "fresh"
+	}
+}
@@ -0,0 +1,43 @@
+package app_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/filecache"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+)
+
+func TestCacheClear(t *testing.T) {
+	file := settings.Default()
+	file.Cache.Encryption = settings.EncryptionPassphrase
+	c := newCLI(t, file)
+	t.Setenv("LAZYFLAGS_CACHE_PASSPHRASE", "battery staple")
+
+	// encrypted with a key lazyflags doesn't have, e.g. a lost keyring entry
+	sealed, err := filecache.New(filecache.WithIdentity(filecache.Identity{Account: "local", Region: "us-east-1"}), filecache.WithKey(bytes.Repeat([]byte{1}, 32)))
+	if err != nil {
+		t.Fatalf("filecache: %v", err)
+	}
+	if err := filecache.Add(sealed, filecache.Flags, "wordle1:webflg1", []appconfig.Result{}, time.Minute); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	code, _, stderr := c.run("apps", "list")
+	if code != 1 || !strings.Contains(stderr, "lazyflags cache clear") {
+		t.Fatalf("expected the cache to be refused, got exit code %d: %s", code, stderr)
+	}
+
+	code, stdout, stderr := c.run("cache", "clear")
+	if code != 0 || stdout != "Removed the cache file\n" {
+		t.Fatalf("cache clear: exit code %d: %s%s", code, stdout, stderr)
+	}
+
+	code, stdout, stderr = c.run("apps", "list")
+	if code != 0 || !strings.Contains(stdout, "Wordle") {
+		t.Errorf("expected apps list to run on a new cache, got exit code %d: %s%s", code, stdout, stderr)
+	}
+}
@@ -0,0 +1,625 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/audit"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/filecache"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/policy"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+)
+
+// Exit codes of the non-interactive commands, so CI jobs and runbooks can
+// tell bad invocations and expired sessions apart from failed calls.
+const (
+	exitOK          = 0
+	exitError       = 1
+	exitUsage       = 2
+	exitCredentials = 3
+)
+
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...any) error {
+	return usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// cli holds everything a non-interactive command needs to run
+type cli struct {
+	client      *appconfig.Client
+	cache       *filecache.Cache
+	cacheConfig settings.Cache
+	protected   settings.Protected
+	rules       *policy.Engine
+	approval    settings.Approval
+	// default of --strategy
+	strategy string
+	audit    *audit.Log
+	out      io.Writer
+	// warnings, kept out of the output scripts read
+	errOut io.Writer
+	// commands only parse their flags, for -h before there is a client
+	helpOnly bool
+}
+
+type command struct {
+	name  string
+	usage string
+	run   func(c *cli, ctx context.Context, args []string) error
+	// standalone commands don't talk to AppConfig, cli has no client or cache
+	standalone bool
+	// offline commands only read what the cache holds and run with --offline
+	offline bool
+}
+
+var commands = []command{
+	{
+		name:    "apps list",
+		usage:   "list applications",
+		run:     (*cli).appsList,
+		offline: true,
+	},
+	{
+		name:    "profiles list",
+		usage:   "--app APP\n\tlist feature flag configuration profiles of an application",
+		run:     (*cli).profilesList,
+		offline: true,
+	},
+	{
+		name:    "envs list",
+		usage:   "--app APP\n\tlist environments of an application",
+		run:     (*cli).envsList,
+		offline: true,
+	},
+	{
+		name:    "flags get",
+		usage:   "--app APP --profile PROFILE [--env ENV] [--output text|json|yaml|csv|markdown]\n\tshow flag states per environment",
+		run:     (*cli).flagsGet,
+		offline: true,
+	},
+	{
+		name:  "flags set",
+		usage: "--app APP --profile PROFILE --env ENV --flag FLAG --enabled=true|false [--strategy ID] [--confirm ENV]\n\tcreate a new version with one flag changed and deploy it to an environment, or propose it where approval is required",
+		run:   (*cli).flagsSet,
+	},
+	{
+		name:  "proposals list",
+		usage: "--app APP --profile PROFILE [--all]\n\tlist the changes waiting for approval",
+		run:   (*cli).proposalsList,
+	},
+	{
+		name:  "proposals show",
+		usage: "--app APP --profile PROFILE --version N\n\tshow what a proposal changes",
+		run:   (*cli).proposalsShow,
+	},
+	{
+		name:  "proposals approve",
+		usage: "--app APP --profile PROFILE --version N [--strategy ID] [--confirm ENV]\n\tdeploy a change proposed by another user",
+		run:   (*cli).proposalsApprove,
+	},
+	{
+		name:  "profiles export",
+		usage: "--app APP --profile PROFILE [--file PATH] [--format json|yaml]\n\texport the latest flag document and the version deployed to each environment",
+		run:   (*cli).profilesExport,
+	},
+	{
+		name:  "profiles import",
+		usage: "--file PATH [--app APP] [--profile PROFILE] [--dry-run] [--description TEXT]\n\tvalidate an exported file and create a new hosted version from it",
+		run:   (*cli).profilesImport,
+	},
+	{
+		name:  "plan",
+		usage: "--file PATH\n\tcompare a desired state file with the flags deployed to each environment",
+		run:   (*cli).plan,
+	},
+	{
+		name:  "apply",
+		usage: "--file PATH [--strategy ID] [--confirm ENV,...]\n\tcreate versions and start deployments so environments match a desired state file",
+		run:   (*cli).apply,
+	},
+	{
+		name:       "serve",
+		usage:      "[--addr HOST:PORT] [--data PATH]\n\trun a local AppConfig stand-in, use it with --endpoint http://HOST:PORT",
+		run:        (*cli).serve,
+		standalone: true,
+	},
+	{
+		name:  "deploy",
+		usage: "--app APP --profile PROFILE --env ENV --version N [--strategy ID] [--confirm ENV]\n\tdeploy an existing hosted configuration version to an environment",
+		run:   (*cli).deploy,
+	},
+	{
+		name:  "undo",
+		usage: "--app APP --profile PROFILE --env ENV [--strategy ID] [--dry-run] [--confirm ENV]\n\tredeploy the version live before the last deployment to an environment, stopping it if it's still rolling out",
+		run:   (*cli).undo,
+	},
+	{
+		name:       "audit export",
+		usage:      "[--app APP_ID] [--profile PROFILE_ID] [--since 24h|DATE] [--file PATH] [--format jsonl|csv]\n\texport the log of every version created and deployment started",
+		run:        (*cli).auditExport,
+		standalone: true,
+	},
+	{
+		name:       "cache list",
+		usage:      "[--account ID] [--region REGION] [--kind KIND] [--app APP_ID] [--profile PROFILE_ID]\n\tlist the cached flags and lists of every AWS account and region",
+		run:        (*cli).cacheList,
+		standalone: true,
+	},
+	{
+		name:       "cache show",
+		usage:      "--app APP_ID --profile PROFILE_ID [--account ID] [--region REGION] [--output text|json|yaml|csv|markdown]\n\tshow cached flags and when they were fetched",
+		run:        (*cli).cacheShow,
+		standalone: true,
+	},
+	{
+		name:       "cache purge",
+		usage:      "--all | [--account ID] [--region REGION] [--kind KIND] [--app APP_ID] [--profile PROFILE_ID]\n\tremove cached flags and lists",
+		run:        (*cli).cachePurge,
+		standalone: true,
+	},
+	{
+		name:       "cache clear",
+		usage:      "remove the cache file, also one that can't be decrypted",
+		run:        (*cli).cacheClear,
+		standalone: true,
+	},
+}
+
+// findCommand matches the leading words of args against the known commands
+// and returns the command with the remaining (flag) arguments.
+func findCommand(args []string) (command, []string, bool) {
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) < len(words) {
+			continue
+		}
+		if strings.Join(args[:len(words)], " ") == cmd.name {
+			return cmd, args[len(words):], true
+		}
+	}
+	return command{}, nil, false
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "  lazyflags                       start the interactive UI")
+	fmt.Fprintln(w, "  lazyflags <command> [flags]     run a single command and exit")
+	fmt.Fprintln(w, "  lazyflags <command> -h          show the flags of a command")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Global flags, before the command:")
+	fmt.Fprintln(w, "  --endpoint URL   AppConfig endpoint, e.g. http://localhost:4566 for `lazyflags serve` (env LAZYFLAGS_ENDPOINT)")
+	fmt.Fprintf(w, "  --timeout D      timeout of each AWS call including retries (default %s)\n", appconfig.DefaultCallPolicy.Timeout)
+	fmt.Fprintf(w, "  --max-attempts N attempts per AWS call, throttled calls back off (default %d)\n", appconfig.DefaultCallPolicy.MaxAttempts)
+	fmt.Fprintf(w, "  --concurrency N  environments whose flags are fetched at once (default %d)\n", appconfig.DefaultMaxConcurrency)
+	fmt.Fprintf(w, "  --cache-ttl D    how long fetched flags are cached, profiles with a TTL in the settings file keep it (default %s)\n", settings.Default().Cache.TTL)
+	fmt.Fprintln(w, "  --stale-while-revalidate")
+	fmt.Fprintln(w, "                   show expired flags in the TUI at once while they're refetched")
+	fmt.Fprintln(w, "  --offline        serve apps, profiles, environments and flags from the cache whatever")
+	fmt.Fprintln(w, "                   their age, never call AWS; changes are disabled")
+	fmt.Fprintln(w, "  --elevated       allow changes to protected environments the settings file blocks")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "APP, PROFILE and ENV accept either a name or an id. The cache commands work offline and take ids,\napps list, profiles list, envs list and flags get run with --offline.")
+	if path, err := settings.Path(); err == nil {
+		fmt.Fprintf(w, "Settings are read from %s.\n", path)
+	}
+	if path, err := audit.DefaultPath(); err == nil {
+		fmt.Fprintf(w, "Changes are logged to %s.\n", path)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Exit codes:")
+	fmt.Fprintf(w, "  %d  success\n", exitOK)
+	fmt.Fprintf(w, "  %d  the command failed\n", exitError)
+	fmt.Fprintf(w, "  %d  invalid usage\n", exitUsage)
+	fmt.Fprintf(w, "  %d  AWS rejected the credentials (expired session or access denied)\n", exitCredentials)
+}
+
+// exitCode maps the error returned by a command to the process exit code
+func exitCode(err error) int {
+	var usageErr usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case isCredentialError(err):
+		return exitCredentials
+	default:
+		return exitError
+	}
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// helpRequest is returned by parseFlags for -h and --help, the command's
+// usage is printed instead of running it
+type helpRequest struct {
+	fs *flag.FlagSet
+}
+
+func (h helpRequest) Error() string {
+	return h.fs.Name() + ": help requested"
+}
+
+// errNotHelp stops a command run for its usage whose -h turned out to be
+// the value of another flag
+var errNotHelp = errors.New("help not requested")
+
+func (c *cli) parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return helpRequest{fs: fs}
+		}
+		return usageErrorf("%s: %v", fs.Name(), err)
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("%s: unexpected argument %q", fs.Name(), fs.Arg(0))
+	}
+	if c.helpOnly {
+		return errNotHelp
+	}
+	return nil
+}
+
+// wantsHelp reports whether args may hold -h or --help, it can't tell
+// them apart from the value of a flag
+func wantsHelp(args []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		switch strings.TrimLeft(arg, "-") {
+		case "h", "help":
+			return true
+		}
+	}
+	return false
+}
+
+// printCommandUsage prints the usage of a command and the defaults of its
+// flags
+func printCommandUsage(w io.Writer, cmd command, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: lazyflags %s %s\n", cmd.name, cmd.usage)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Flags:")
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
+
+func requireFlags(fs *flag.FlagSet, names ...string) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, name := range names {
+		if !set[name] {
+			return usageErrorf("%s: --%s is required", fs.Name(), name)
+		}
+	}
+	return nil
+}
+
+// the resolve funcs look names up in the cached lists and list again
+// before they give up, the cached list may predate what they look for
+var resolveFresh = []bool{false, true}
+
+func (c *cli) resolveApp(ctx context.Context, ref string) (appconfig.App, error) {
+	for _, fresh := range resolveFresh {
+		apps, err := listApps(ctx, c.client, c.cache, c.cacheConfig, fresh)
+		if err != nil {
+			return appconfig.App{}, err
+		}
+		for _, app := range apps {
+			if *app.Id == ref || *app.Name == ref {
+				return app, nil
+			}
+		}
+	}
+	return appconfig.App{}, fmt.Errorf("application %q not found", ref)
+}
+
+func (c *cli) resolveProfile(ctx context.Context, appId string, ref string) (appconfig.AppFlagConfig, error) {
+	for _, fresh := range resolveFresh {
+		configs, err := listProfiles(ctx, c.client, c.cache, c.cacheConfig, appId, fresh)
+		if err != nil {
+			return appconfig.AppFlagConfig{}, err
+		}
+		for _, config := range configs {
+			if *config.Id == ref || *config.Name == ref {
+				return config, nil
+			}
+		}
+	}
+	return appconfig.AppFlagConfig{}, fmt.Errorf("feature flag configuration profile %q not found", ref)
+}
+
+func (c *cli) resolveEnv(ctx context.Context, appId string, ref string) (appconfig.AppEnvironments, error) {
+	for _, fresh := range resolveFresh {
+		envs, err := listEnvironments(ctx, c.client, c.cache, c.cacheConfig, appId, fresh)
+		if err != nil {
+			return appconfig.AppEnvironments{}, err
+		}
+		for _, env := range envs {
+			if *env.Id == ref || *env.Name == ref {
+				return env, nil
+			}
+		}
+	}
+	return appconfig.AppEnvironments{}, fmt.Errorf("environment %q not found", ref)
+}
+
+func (c *cli) appsList(ctx context.Context, args []string) error {
+	fs := newFlagSet("apps list")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	apps, err := listApps(ctx, c.client, c.cache, c.cacheConfig, true)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME")
+	for _, app := range apps {
+		fmt.Fprintf(w, "%s\t%s\n", *app.Id, *app.Name)
+	}
+	return w.Flush()
+}
+
+func (c *cli) profilesList(ctx context.Context, args []string) error {
+	fs := newFlagSet("profiles list")
+	appRef := fs.String("app", "", "application name or id")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "app"); err != nil {
+		return err
+	}
+
+	app, err := c.resolveApp(ctx, *appRef)
+	if err != nil {
+		return err
+	}
+	configs, err := listProfiles(ctx, c.client, c.cache, c.cacheConfig, *app.Id, true)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME")
+	for _, config := range configs {
+		fmt.Fprintf(w, "%s\t%s\n", *config.Id, *config.Name)
+	}
+	return w.Flush()
+}
+
+func (c *cli) envsList(ctx context.Context, args []string) error {
+	fs := newFlagSet("envs list")
+	appRef := fs.String("app", "", "application name or id")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "app"); err != nil {
+		return err
+	}
+
+	app, err := c.resolveApp(ctx, *appRef)
+	if err != nil {
+		return err
+	}
+	envs, err := listEnvironments(ctx, c.client, c.cache, c.cacheConfig, *app.Id, true)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSTATE")
+	for _, env := range envs {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", *env.Id, *env.Name, env.State)
+	}
+	return w.Flush()
+}
+
+func (c *cli) flagsGet(ctx context.Context, args []string) error {
+	fs := newFlagSet("flags get")
+	appRef := fs.String("app", "", "application name or id")
+	profileRef := fs.String("profile", "", "configuration profile name or id")
+	envRef := fs.String("env", "", "only show this environment")
+	output := fs.String("output", outputText, "output format")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "app", "profile"); err != nil {
+		return err
+	}
+	if err := validateOutputFormat(*output); err != nil {
+		return err
+	}
+
+	app, err := c.resolveApp(ctx, *appRef)
+	if err != nil {
+		return err
+	}
+	profile, err := c.resolveProfile(ctx, *app.Id, *profileRef)
+	if err != nil {
+		return err
+	}
+
+	var results []appconfig.Result
+	if *envRef != "" {
+		results, err = c.envFlags(ctx, *app.Id, *profile.Id, *envRef)
+	} else {
+		ttl := c.cacheConfig.ProfileTTL(*app.Id, *app.Name, *profile.Id, *profile.Name)
+		results, err = getFlags(ctx, c.client, c.cache, c.cacheConfig, *app.Id, *profile.Id, ttl)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if result.Err != nil {
+			return fmt.Errorf("%s: %w", result.EnvName, result.Err)
+		}
+	}
+
+	envOrder := make([]string, 0, len(results))
+	for _, result := range results {
+		envOrder = append(envOrder, result.EnvName)
+	}
+	return WriteFlagsTable(c.out, pivotResults(results, envOrder), *output)
+}
+
+// envFlags returns the flags of one environment. Cached flags serve it,
+// otherwise only that environment is fetched. The cache holds every
+// environment of a profile, so what's fetched isn't cached.
+func (c *cli) envFlags(ctx context.Context, appId string, configId string, envRef string) ([]appconfig.Result, error) {
+	key := flagsCacheKey(appId, configId)
+	var cached []appconfig.Result
+	if c.cacheConfig.Offline {
+		var err error
+		if cached, err = offlineValue(c.cache, filecache.Flags, key); err != nil {
+			return nil, err
+		}
+	} else {
+		cached, _ = filecache.Get(c.cache, filecache.Flags, key)
+	}
+	for _, result := range cached {
+		if result.EnvId == envRef || result.EnvName == envRef {
+			return []appconfig.Result{result}, nil
+		}
+	}
+	// online, the environment may be newer than the cached flags
+	if c.cacheConfig.Offline {
+		return nil, fmt.Errorf("environment %q not found", envRef)
+	}
+
+	env, err := c.resolveEnv(ctx, appId, envRef)
+	if err != nil {
+		return nil, err
+	}
+	var results []appconfig.Result
+	for result := range c.client.StreamFlags(ctx, appId, configId, []appconfig.AppEnvironments{env}) {
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (c *cli) flagsSet(ctx context.Context, args []string) error {
+	fs := newFlagSet("flags set")
+	appRef := fs.String("app", "", "application name or id")
+	profileRef := fs.String("profile", "", "configuration profile name or id")
+	envRef := fs.String("env", "", "environment name or id")
+	flagName := fs.String("flag", "", "flag key")
+	enabled := fs.Bool("enabled", false, "new flag state")
+	strategy := fs.String("strategy", c.strategy, "deployment strategy id")
+	confirm := fs.String("confirm", "", "name of the environment, required when it is protected")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "app", "profile", "env", "flag", "enabled"); err != nil {
+		return err
+	}
+
+	app, err := c.resolveApp(ctx, *appRef)
+	if err != nil {
+		return err
+	}
+	profile, err := c.resolveProfile(ctx, *app.Id, *profileRef)
+	if err != nil {
+		return err
+	}
+	env, err := c.resolveEnv(ctx, *app.Id, *envRef)
+	if err != nil {
+		return err
+	}
+	if err := c.checkProtected(ctx, *app.Id, *env.Id, *env.Name, *confirm); err != nil {
+		return err
+	}
+
+	if c.approval.Required(*env.Name) {
+		proposed, err := c.client.ProposeFlag(ctx, *app.Id, *profile.Id, *env.Id, *flagName, *enabled)
+		if err != nil {
+			return err
+		}
+		c.writeProposed(app, profile, env, proposed)
+		return nil
+	}
+
+	deployment, err := c.client.SetFlag(ctx, *app.Id, *profile.Id, *env.Id, *flagName, *enabled, *strategy)
+	if err != nil {
+		return err
+	}
+	filecache.Delete(c.cache, filecache.Flags, flagsCacheKey(*app.Id, *profile.Id))
+
+	fmt.Fprintf(c.out, "Created version %d and started deployment %d to %s (%s)\n",
+		deployment.Version, deployment.Number, *env.Name, deployment.State)
+	return nil
+}
+
+func (c *cli) deploy(ctx context.Context, args []string) error {
+	fs := newFlagSet("deploy")
+	appRef := fs.String("app", "", "application name or id")
+	profileRef := fs.String("profile", "", "configuration profile name or id")
+	envRef := fs.String("env", "", "environment name or id")
+	version := fs.Int("version", 0, "hosted configuration version number")
+	strategy := fs.String("strategy", c.strategy, "deployment strategy id")
+	confirm := fs.String("confirm", "", "name of the environment, required when it is protected")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "app", "profile", "env", "version"); err != nil {
+		return err
+	}
+	if *version < 1 {
+		return usageErrorf("deploy: --version must be a positive number")
+	}
+
+	app, err := c.resolveApp(ctx, *appRef)
+	if err != nil {
+		return err
+	}
+	profile, err := c.resolveProfile(ctx, *app.Id, *profileRef)
+	if err != nil {
+		return err
+	}
+	env, err := c.resolveEnv(ctx, *app.Id, *envRef)
+	if err != nil {
+		return err
+	}
+	if err := c.checkProtected(ctx, *app.Id, *env.Id, *env.Name, *confirm); err != nil {
+		return err
+	}
+	if c.approval.Required(*env.Name) {
+		return fmt.Errorf("%s %w", *env.Name, errNeedsApproval)
+	}
+
+	deployment, err := c.client.StartDeployment(ctx, *app.Id, *profile.Id, *env.Id, int32(*version), *strategy)
+	if err != nil {
+		return err
+	}
+	filecache.Delete(c.cache, filecache.Flags, flagsCacheKey(*app.Id, *profile.Id))
+
+	fmt.Fprintf(c.out, "Started deployment %d of version %d to %s (%s)\n",
+		deployment.Number, deployment.Version, *env.Name, deployment.State)
+	return nil
+}
@@ -0,0 +1,289 @@
+package app_test
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsappconfig "github.com/aws/aws-sdk-go-v2/service/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/cmd"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig/fake"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/audit"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/localserver"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+)
+
+// cliUser is who runs the commands, the caller ARN is local/cliUser
+const cliUser = "tester"
+
+// cli runs non-interactive commands the way the lazyflags binary does,
+// against the fake backend served by the local server
+type cli struct {
+	t        *testing.T
+	backend  *fake.Backend
+	endpoint string
+	file     settings.File
+	audit    *audit.Log
+	dir      string
+}
+
+func newCLI(t *testing.T, file settings.File, faults ...fake.Fault) *cli {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	// the local endpoint needs no AWS configuration, don't read the user's
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "aws-config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "aws-credentials"))
+	t.Setenv("LAZYFLAGS_USER", cliUser)
+
+	backend := fake.NewBackend(fake.DemoData())
+	// undo still waits for the rollback, just not for long
+	backend.RollbackDuration = 200 * time.Millisecond
+	for _, f := range faults {
+		backend.AddFault(f)
+	}
+	server := httptest.NewServer(localserver.NewHandler(backend))
+	t.Cleanup(server.Close)
+
+	return &cli{
+		t:        t,
+		backend:  backend,
+		endpoint: server.URL,
+		file:     file,
+		audit:    audit.Open(filepath.Join(dir, "audit.jsonl")),
+		dir:      dir,
+	}
+}
+
+// run runs a command and returns its exit code and output
+func (c *cli) run(args ...string) (code int, stdout string, stderr string) {
+	c.t.Helper()
+	var out, errOut bytes.Buffer
+	code = app.RunCommand(c.endpoint, c.file, c.audit, &out, &errOut, args...)
+	return code, out.String(), errOut.String()
+}
+
+// runAs runs a command as another user
+func (c *cli) runAs(user string, args ...string) (code int, stdout string, stderr string) {
+	c.t.Helper()
+	c.t.Setenv("LAZYFLAGS_USER", user)
+	defer c.t.Setenv("LAZYFLAGS_USER", cliUser)
+	return c.run(args...)
+}
+
+// writeFile writes a file to the test's directory and returns its path
+func (c *cli) writeFile(name string, content string) string {
+	c.t.Helper()
+	path := filepath.Join(c.dir, name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		c.t.Fatal(err)
+	}
+	return path
+}
+
+// deployedDocument returns the document of WebFeatureFlags deployed to an
+// environment
+func (c *cli) deployedDocument(envId string) *appconfig.FlagDocument {
+	c.t.Helper()
+	ctx := context.Background()
+	client := appconfig.NewWithClients(c.backend, c.backend)
+	deployed, err := client.DeployedVersion(ctx, "wordle1", "webflg1", envId)
+	if err != nil {
+		c.t.Fatalf("DeployedVersion: %v", err)
+	}
+	doc, err := client.GetFlagDocument(ctx, "wordle1", "webflg1", deployed.Version)
+	if err != nil {
+		c.t.Fatalf("GetFlagDocument: %v", err)
+	}
+	return doc
+}
+
+// enabled returns whether a flag of WebFeatureFlags is on in the version
+// deployed to an environment
+func (c *cli) enabled(envId string, flagName string) bool {
+	c.t.Helper()
+	return c.deployedDocument(envId).Values[flagName].Enabled()
+}
+
+// deploymentStrategy returns the strategy a deployment of WebFeatureFlags
+// was started with
+func deploymentStrategy(t *testing.T, backend *fake.Backend, envId string, number int32) string {
+	t.Helper()
+	out, err := backend.GetDeployment(context.Background(), &awsappconfig.GetDeploymentInput{
+		ApplicationId:    aws.String("wordle1"),
+		EnvironmentId:    aws.String(envId),
+		DeploymentNumber: aws.Int32(number),
+	})
+	if err != nil {
+		t.Fatalf("GetDeployment: %v", err)
+	}
+	return aws.ToString(out.DeploymentStrategyId)
+}
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		faults []fake.Fault
+		// exit code, and what stdout and stderr contain
+		expectedCode int
+		expectedOut  string
+		expectedErr  string
+	}{
+		{
+			name:        "should run a command",
+			args:        []string{"apps", "list"},
+			expectedOut: "wordle1  Wordle",
+		},
+		{
+			name:         "should refuse an unknown command",
+			args:         []string{"apps", "delete"},
+			expectedCode: 2,
+			expectedErr:  `unknown command "apps delete"`,
+		},
+		{
+			name:         "should refuse a missing required flag",
+			args:         []string{"flags", "get", "--app", "Wordle"},
+			expectedCode: 2,
+			expectedErr:  "flags get: --profile is required",
+		},
+		{
+			name:         "should refuse an unknown flag",
+			args:         []string{"apps", "list", "--app", "Wordle"},
+			expectedCode: 2,
+			expectedErr:  "apps list: flag provided but not defined: -app",
+		},
+		{
+			name:         "should refuse an unexpected argument",
+			args:         []string{"envs", "list", "--app", "Wordle", "staging"},
+			expectedCode: 2,
+			expectedErr:  `envs list: unexpected argument "staging"`,
+		},
+		{
+			name:         "should fail on what doesn't exist",
+			args:         []string{"envs", "list", "--app", "Scrabble"},
+			expectedCode: 1,
+			expectedErr:  `application "Scrabble" not found`,
+		},
+		{
+			name:         "should tell rejected credentials apart",
+			args:         []string{"apps", "list"},
+			faults:       []fake.Fault{{Operation: "ListApplications", Err: fake.ErrAccessDenied}},
+			expectedCode: 3,
+			expectedErr:  "AccessDeniedException",
+		},
+		{
+			name:        "should print the usage of a command",
+			args:        []string{"flags", "get", "-h"},
+			faults:      []fake.Fault{{Err: fake.ErrAccessDenied}},
+			expectedOut: "Usage: lazyflags flags get --app APP --profile PROFILE [--env ENV]",
+		},
+		{
+			name:        "should print the flags of a command",
+			args:        []string{"flags", "set", "--app", "Wordle", "--help"},
+			faults:      []fake.Fault{{Err: fake.ErrAccessDenied}},
+			expectedOut: "  -strategy string\n    \tdeployment strategy id (default \"AppConfig.AllAtOnce\")",
+		},
+		{
+			name:         "should run a command whose flag value is -h",
+			args:         []string{"envs", "list", "--app", "-h"},
+			expectedCode: 1,
+			expectedErr:  `application "-h" not found`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCLI(t, settings.Default(), tt.faults...)
+
+			code, stdout, stderr := c.run(tt.args...)
+			if code != tt.expectedCode {
+				t.Fatalf("expected exit code %d, got %d:\n%s%s", tt.expectedCode, code, stdout, stderr)
+			}
+			if !strings.Contains(stdout, tt.expectedOut) {
+				t.Errorf("expected stdout to contain %q:\n%s", tt.expectedOut, stdout)
+			}
+			if !strings.Contains(stderr, tt.expectedErr) {
+				t.Errorf("expected stderr to contain %q:\n%s", tt.expectedErr, stderr)
+			}
+		})
+	}
+}
+
+func TestFlagsGetEnv(t *testing.T) {
+	c := newCLI(t, settings.Default())
+
+	code, stdout, stderr := c.run("flags", "get", "--app", "Wordle", "--profile", "WebFeatureFlags", "--env", "staging", "--output", "csv")
+	if code != 0 {
+		t.Fatalf("flags get: exit code %d: %s", code, stderr)
+	}
+	expected := "flag,staging\nbeta_feature,off\ndark_mode,on\nnew_checkout,on\n"
+	if stdout != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, stdout)
+	}
+	if sessions := c.backend.Calls("StartConfigurationSession"); sessions != 1 {
+		t.Errorf("expected only staging to be fetched, got %d sessions", sessions)
+	}
+
+	code, _, stderr = c.run("flags", "get", "--app", "Wordle", "--profile", "WebFeatureFlags", "--env", "qa")
+	if code != 1 || !strings.Contains(stderr, `environment "qa" not found`) {
+		t.Errorf("expected an unknown environment to fail, got exit code %d: %s", code, stderr)
+	}
+}
+
+func TestAuditLogFails(t *testing.T) {
+	c := newCLI(t, settings.Default())
+	// the log can't be opened for writing
+	if err := os.Mkdir(c.audit.Path(), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	code, _, stderr := c.run("flags", "set", "--app", "Wordle", "--profile", "WebFeatureFlags", "--env", "staging", "--flag", "beta_feature", "--enabled=true")
+	if code != 0 {
+		t.Fatalf("expected the change to succeed, got exit code %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "warning: not written to the audit log:") {
+		t.Errorf("expected a warning about the audit log, got %q", stderr)
+	}
+	if !c.enabled("sta0001", "beta_feature") {
+		t.Errorf("expected beta_feature to be on in staging")
+	}
+}
+
+func TestDeployStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		args     []string
+		expected string
+	}{
+		{name: "should deploy all at once by default", expected: "AppConfig.AllAtOnce"},
+		{name: "should deploy with the strategy of the settings", strategy: "AppConfig.Linear50PercentEvery30Seconds", expected: "AppConfig.Linear50PercentEvery30Seconds"},
+		{name: "should prefer --strategy", strategy: "AppConfig.Linear50PercentEvery30Seconds", args: []string{"--strategy", "AppConfig.Canary10Percent20Minutes"}, expected: "AppConfig.Canary10Percent20Minutes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := settings.Default()
+			file.Deploy.Strategy = tt.strategy
+			c := newCLI(t, file)
+
+			args := append([]string{"flags", "set", "--app", "Wordle", "--profile", "WebFeatureFlags", "--env", "development", "--flag", "new_checkout", "--enabled=true"}, tt.args...)
+			code, stdout, stderr := c.run(args...)
+			if code != 0 {
+				t.Fatalf("flags set: exit code %d: %s%s", code, stdout, stderr)
+			}
+			if strategy := deploymentStrategy(t, c.backend, "dev0001", 3); strategy != tt.expected {
+				t.Errorf("expected the deployment to use %s, got %s", tt.expected, strategy)
+			}
+		})
+	}
+}
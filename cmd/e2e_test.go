@@ -0,0 +1,788 @@
+package app_test
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/simonschwartz/app-config-lazy-flags/cmd"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig/fake"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/audit"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/filecache"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// tui drives a Model the way the bubbletea runtime does: commands run
+// concurrently and their messages are fed back to the model in the order
+// they arrive. After each key the driver waits until every command has
+// finished, so screens can be compared after each step.
+type tui struct {
+	t       *testing.T
+	model   tea.Model
+	backend *fake.Backend
+	audit   *audit.Log
+	// decides when the client's data sessions may poll again
+	clock *testClock
+
+	msgs    chan tea.Msg
+	running int
+}
+
+// how long the driver waits for the model to settle before failing the
+// test, only ever reached when a command hangs
+const settleTimeout = 10 * time.Second
+
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTUI(t *testing.T, faults ...fake.Fault) *tui {
+	t.Helper()
+	return newTUIWithSettings(t, settings.Default(), nil, faults...)
+}
+
+// newTUIWithSettings starts the TUI with the settings of a settings file,
+// seed fills the file cache before the first load
+func newTUIWithSettings(t *testing.T, file settings.File, seed func(*filecache.Cache), faults ...fake.Fault) *tui {
+	t.Helper()
+	d := startTUI(t, file, seed, faults...)
+	d.settle()
+	return d
+}
+
+// startTUI is newTUIWithSettings without waiting for the commands of the
+// start, for tests that hold them up
+func startTUI(t *testing.T, file settings.File, seed func(*filecache.Cache), faults ...fake.Fault) *tui {
+	t.Helper()
+	clock := &testClock{now: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)}
+	m, backend, auditLog := newFakeModel(t, clock, file, seed, faults...)
+	d := &tui{t: t, model: m, backend: backend, audit: auditLog, clock: clock, msgs: make(chan tea.Msg, 100)}
+	d.start(m.Init())
+	return d
+}
+
+func (d *tui) start(cmd tea.Cmd) {
+	if cmd == nil {
+		return
+	}
+	d.running++
+	go func() { d.msgs <- cmd() }()
+}
+
+// settle handles messages until every command has finished
+func (d *tui) settle() {
+	d.t.Helper()
+	d.settleUntil("every command to finish", func() bool { return d.running == 0 })
+}
+
+// settleUntil handles messages until done holds, for tests that hold up a
+// command and look at the screen in the meantime. done is checked after
+// each message and every few milliseconds, for state outside the model.
+func (d *tui) settleUntil(what string, done func() bool) {
+	d.t.Helper()
+	timeout := time.After(settleTimeout)
+	for !done() {
+		select {
+		case msg := <-d.msgs:
+			d.running--
+			d.handle(msg)
+		case <-time.After(5 * time.Millisecond):
+		case <-timeout:
+			d.t.Fatalf("waited %v for %s, %d commands still running", settleTimeout, what, d.running)
+		}
+	}
+}
+
+func (d *tui) handle(msg tea.Msg) {
+	switch msg := msg.(type) {
+	// spinners would tick forever, a static frame keeps screens stable
+	case nil, tea.QuitMsg, spinner.TickMsg:
+	case tea.BatchMsg:
+		for _, cmd := range msg {
+			d.start(cmd)
+		}
+	default:
+		var cmd tea.Cmd
+		d.model, cmd = d.model.Update(msg)
+		d.start(cmd)
+	}
+}
+
+func (d *tui) send(msg tea.Msg) {
+	d.t.Helper()
+	d.handle(msg)
+	d.settle()
+}
+
+// press sends keys by name, e.g. "enter", "esc", "down" or "j"
+func (d *tui) press(keys ...string) {
+	d.t.Helper()
+	for _, key := range keys {
+		d.send(keyMsg(key))
+	}
+}
+
+// pressUntil sends a key and waits until done holds instead of for every
+// command to finish, see settleUntil
+func (d *tui) pressUntil(key string, what string, done func() bool) {
+	d.t.Helper()
+	d.handle(keyMsg(key))
+	d.settleUntil(what, done)
+}
+
+func keyMsg(key string) tea.KeyMsg {
+	named := map[string]tea.KeyType{
+		"enter": tea.KeyEnter,
+		"esc":   tea.KeyEsc,
+		"up":    tea.KeyUp,
+		"down":  tea.KeyDown,
+		"left":  tea.KeyLeft,
+		"right": tea.KeyRight,
+	}
+	if keyType, ok := named[key]; ok {
+		return tea.KeyMsg{Type: keyType}
+	}
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+}
+
+// snapshot compares the screen to testdata/<name>.golden, go test -update
+// rewrites the golden files
+func (d *tui) snapshot(name string) {
+	d.t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	view := d.model.View()
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			d.t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(view), 0644); err != nil {
+			d.t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		d.t.Fatalf("%v, run go test -update to create it", err)
+	}
+	if view != string(expected) {
+		d.t.Errorf("screen %s does not match %s\nexpected:\n%s\ngot:\n%s", name, path, expected, view)
+	}
+}
+
+// deployedFlags reads an environment's flags like an application would
+func (d *tui) deployedFlags(envId string) appconfig.Flags {
+	d.t.Helper()
+	client := appconfig.NewWithClients(d.backend, d.backend)
+	flags, err := client.GetLatestFlagConfig(context.Background(), "wordle1", "webflg1", envId, 60)
+	if err != nil {
+		d.t.Fatalf("GetLatestFlagConfig: %v", err)
+	}
+	return flags
+}
+
+func TestTUIToggleFlag(t *testing.T) {
+	d := newTUI(t)
+	d.snapshot("toggle/01_apps")
+
+	d.press("enter")
+	d.snapshot("toggle/02_configs")
+
+	d.press("enter")
+	d.snapshot("toggle/03_flags")
+
+	d.press("down", "enter")
+	d.snapshot("toggle/04_detail")
+
+	d.press("down", "down", "enter")
+	d.snapshot("toggle/05_confirm")
+
+	d.press("left", "enter")
+	d.snapshot("toggle/06_deployed")
+
+	if !d.deployedFlags("pro0001")["dark_mode"].Enabled {
+		t.Errorf("expected dark_mode to be deployed on in production")
+	}
+	if d.deployedFlags("sta0001")["dark_mode"].Enabled != true || d.deployedFlags("dev0001")["beta_feature"].Enabled != true {
+		t.Errorf("expected other environments to be unchanged")
+	}
+	if calls := d.backend.Calls("StartDeployment"); calls != 1 {
+		t.Errorf("expected 1 deployment, got %d", calls)
+	}
+
+	d.press("esc")
+	d.snapshot("toggle/07_flags")
+
+	d.press("esc")
+	d.snapshot("toggle/08_configs")
+
+	d.press("esc")
+	d.snapshot("toggle/09_apps")
+}
+
+func TestTUICancelToggle(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+	}{
+		{
+			name: "should not deploy when cancel is selected",
+			keys: []string{"enter"},
+		},
+		{
+			name: "should not deploy when the confirmation is dismissed",
+			keys: []string{"left", "esc"},
+		},
+		{
+			name: "should not deploy when navigating back to cancel",
+			keys: []string{"left", "right", "enter"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTUI(t)
+			d.press("enter", "enter", "down", "enter", "down", "down", "enter")
+			d.press(tt.keys...)
+			d.snapshot("cancel/detail")
+
+			if calls := d.backend.Calls("CreateHostedConfigurationVersion"); calls != 0 {
+				t.Errorf("expected no new version, got %d calls", calls)
+			}
+			if d.deployedFlags("pro0001")["dark_mode"].Enabled {
+				t.Errorf("expected dark_mode to stay off in production")
+			}
+		})
+	}
+}
+
+func TestTUIProtectedEnvironment(t *testing.T) {
+	file := settings.Default()
+	// the demo tags production with Protected=true
+	file.Protected.Tags = map[string]string{"Protected": "true"}
+	d := newTUIWithSettings(t, file, nil)
+
+	d.press("enter", "enter", "down", "enter", "down", "down", "enter")
+	d.snapshot("protected/01_typing")
+
+	// "q" is typed, not quit
+	d.press("q", "enter")
+	d.snapshot("protected/02_mismatch")
+	if calls := d.backend.Calls("StartDeployment"); calls != 0 {
+		t.Fatalf("expected no deployment before the name is typed, got %d", calls)
+	}
+
+	d.press("esc", "enter", "production", "enter")
+	d.snapshot("protected/03_deployed")
+	if !d.deployedFlags("pro0001")["dark_mode"].Enabled {
+		t.Errorf("expected dark_mode to be deployed on in production")
+	}
+
+	// staging isn't protected and keeps the Yes/Cancel dialog
+	d.press("up", "enter")
+	d.snapshot("protected/04_unprotected")
+}
+
+func TestTUIProtectedEnvironmentBlocked(t *testing.T) {
+	tests := []struct {
+		name     string
+		elevated bool
+		golden   string
+	}{
+		{name: "should refuse changes", elevated: false, golden: "protected/05_blocked"},
+		{name: "should ask to type the name when elevated", elevated: true, golden: "protected/06_elevated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := settings.Default()
+			file.Protected = settings.Protected{Environments: []string{"prod*"}, Block: true, Elevated: tt.elevated}
+			d := newTUIWithSettings(t, file, nil)
+
+			d.press("enter", "enter", "down", "enter", "down", "down", "enter")
+			d.snapshot(tt.golden)
+		})
+	}
+}
+
+func TestTUIPolicy(t *testing.T) {
+	file := settings.Default()
+	// the test clock starts on a Sunday at noon
+	file.Policy.Rules = []settings.PolicyRule{
+		{Type: "promotion", From: "staging", To: "production"},
+		{Type: "freeze", Environments: []string{"prod*"}, Days: []string{"sunday"}, After: "11:00"},
+	}
+	// a refused toggle of a protected environment still shows the banner
+	file.Protected.Tags = map[string]string{"Protected": "true"}
+	d := newTUIWithSettings(t, file, nil)
+
+	// beta_feature is off in staging
+	d.press("enter", "enter", "enter", "down", "down", "enter")
+	d.snapshot("policy/01_refused")
+
+	d.press("left", "enter")
+	if calls := d.backend.Calls("CreateHostedConfigurationVersion"); calls != 0 {
+		t.Fatalf("expected no version to be created, got %d", calls)
+	}
+	d.snapshot("policy/02_cancelled")
+
+	d.press("up", "enter")
+	d.snapshot("policy/03_allowed")
+}
+
+func TestTUIPolicyLiveState(t *testing.T) {
+	file := settings.Default()
+	file.Policy.Rules = []settings.PolicyRule{{Type: "promotion", From: "development", To: "staging"}}
+	d := newTUIWithSettings(t, file, nil)
+	d.press("enter", "enter")
+
+	// beta_feature is turned off in development after the flags are shown
+	other := appconfig.NewWithClients(d.backend, d.backend)
+	if _, err := other.SetFlag(context.Background(), "wordle1", "webflg1", "dev0001", "beta_feature", false, ""); err != nil {
+		t.Fatalf("SetFlag: %v", err)
+	}
+	versions := d.backend.Calls("CreateHostedConfigurationVersion")
+
+	// the flags shown still have it on, the write is checked again
+	d.press("enter", "down", "enter", "left", "enter")
+	d.snapshot("policy/04_refused_live")
+
+	if calls := d.backend.Calls("CreateHostedConfigurationVersion"); calls != versions {
+		t.Errorf("expected no version to be created, got %d", calls-versions)
+	}
+	if d.deployedFlags("sta0001")["beta_feature"].Enabled {
+		t.Errorf("expected beta_feature to stay off in staging")
+	}
+}
+
+func TestTUIApproval(t *testing.T) {
+	file := settings.Default()
+	file.Approval.Environments = []string{"prod*"}
+	d := newTUIWithSettings(t, file, nil)
+
+	d.press("enter", "enter", "down", "enter", "down", "down", "enter")
+	d.snapshot("approval/01_confirm")
+
+	d.press("left", "enter")
+	d.snapshot("approval/02_proposed")
+	if calls := d.backend.Calls("StartDeployment"); calls != 0 {
+		t.Fatalf("expected the change to be proposed, not deployed, got %d deployments", calls)
+	}
+
+	d.press("esc", "p")
+	d.snapshot("approval/03_proposals")
+
+	// the tester can't approve their own proposal
+	d.press("enter")
+	d.snapshot("approval/04_review")
+	d.press("left", "enter")
+	d.snapshot("approval/05_self_approval")
+	if calls := d.backend.Calls("StartDeployment"); calls != 0 {
+		t.Fatalf("expected self-approval to be refused, got %d deployments", calls)
+	}
+
+	// a proposal by someone else is approved and deployed
+	other := appconfig.NewWithClients(d.backend, d.backend, appconfig.WithCaller("local/alice"))
+	if _, err := other.ProposeFlag(context.Background(), "wordle1", "webflg1", "pro0001", "beta_feature", true); err != nil {
+		t.Fatalf("ProposeFlag: %v", err)
+	}
+	d.press("esc", "esc", "p", "enter", "left", "enter")
+	d.snapshot("approval/06_approved")
+	if !d.deployedFlags("pro0001")["beta_feature"].Enabled {
+		t.Errorf("expected beta_feature to be deployed on in production")
+	}
+	if d.deployedFlags("pro0001")["dark_mode"].Enabled {
+		t.Errorf("expected the stale dark_mode proposal to stay undeployed")
+	}
+}
+
+func TestTUIAuditLog(t *testing.T) {
+	d := newTUI(t)
+
+	d.press("enter", "enter", "down", "enter", "down", "down", "enter", "left", "enter")
+	d.press("esc", "a")
+	d.snapshot("audit/01_list")
+
+	d.press("enter")
+	d.snapshot("audit/02_deployment")
+
+	d.press("esc", "down", "enter")
+	d.snapshot("audit/03_version")
+
+	d.press("esc", "esc")
+	if view := d.model.View(); !strings.Contains(view, "dark_mode") {
+		t.Errorf("expected esc to go back to the flags table:\n%s", view)
+	}
+
+	// a write cut short leaves half a record at the end of the log
+	f, err := os.OpenFile(d.audit.Path(), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"time":"2025-06-01T12:05:00Z","act`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	d.press("a")
+	d.snapshot("audit/04_skipped_line")
+}
+
+func TestTUIAuditLogFails(t *testing.T) {
+	d := newTUI(t)
+	// the log can't be opened for writing
+	if err := os.Mkdir(d.audit.Path(), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	d.press("enter", "enter", "down", "enter", "down", "down", "enter", "left", "enter")
+	if view := d.model.View(); !strings.Contains(view, "Warning: not written to the audit") {
+		t.Errorf("expected the detail view to warn about the audit log:\n%s", view)
+	}
+	if !d.deployedFlags("pro0001")["dark_mode"].Enabled {
+		t.Errorf("expected dark_mode to be deployed on in production")
+	}
+}
+
+func TestTUIUndo(t *testing.T) {
+	d := newTUI(t)
+
+	d.press("enter", "enter", "down", "enter", "down", "down", "enter", "left", "enter")
+	d.press("esc", "u")
+	d.snapshot("undo/01_list")
+
+	// development has nothing to undo
+	d.press("enter")
+	d.snapshot("undo/01_list")
+
+	d.press("down", "down", "enter")
+	d.snapshot("undo/02_review")
+
+	d.press("left", "enter")
+	d.snapshot("undo/03_undone")
+	if d.deployedFlags("pro0001")["dark_mode"].Enabled {
+		t.Errorf("expected dark_mode to be off again in production")
+	}
+
+	d.press("esc")
+	d.snapshot("undo/04_flags")
+}
+
+func TestTUIUndoInProgress(t *testing.T) {
+	file := settings.Default()
+	file.Protected.Environments = []string{"production"}
+	d := newTUIWithSettings(t, file, nil)
+
+	// a slow rollout is still in progress
+	client := appconfig.NewWithClients(d.backend, d.backend)
+	if _, err := client.SetFlag(context.Background(), "wordle1", "webflg1", "pro0001", "dark_mode", true, "AppConfig.Linear20PercentEvery6Minutes"); err != nil {
+		t.Fatalf("SetFlag: %v", err)
+	}
+
+	d.press("enter", "enter", "u", "down", "down", "enter")
+	d.snapshot("undo/05_in_progress")
+
+	d.press("left", "enter")
+	d.snapshot("undo/06_typing")
+
+	d.press("production", "enter")
+	d.snapshot("undo/07_stopped")
+	if calls := d.backend.Calls("StopDeployment"); calls != 1 {
+		t.Errorf("expected the rollout to be stopped, got %d calls", calls)
+	}
+	if d.deployedFlags("pro0001")["dark_mode"].Enabled {
+		t.Errorf("expected dark_mode to stay off in production")
+	}
+}
+
+func TestTUIToggleFails(t *testing.T) {
+	d := newTUI(t, fake.Fault{Operation: "StartDeployment", Err: fake.ErrAccessDenied})
+
+	d.press("enter", "enter", "down", "enter", "down", "down", "enter", "left", "enter")
+	d.snapshot("fails/detail")
+
+	if d.deployedFlags("pro0001")["dark_mode"].Enabled {
+		t.Errorf("expected dark_mode to stay off in production")
+	}
+
+	d.press("esc")
+	d.snapshot("fails/flags")
+}
+
+func TestTUIStreamsResults(t *testing.T) {
+	production := make(chan struct{})
+	d := newTUI(t,
+		fake.Fault{Operation: "GetLatestConfiguration", Environment: "production", Wait: production},
+		fake.Fault{Operation: "GetLatestConfiguration", Environment: "staging", Err: fake.ErrThrottling},
+	)
+
+	// production hangs, the other environments show up without waiting for it
+	d.press("enter")
+	d.pressUntil("enter", "only production to be loading", func() bool {
+		return slices.Equal(app.LoadingEnvs(d.model), []string{"production"})
+	})
+	d.snapshot("stream/01_loading")
+
+	close(production)
+	d.settle()
+	d.snapshot("stream/02_loaded")
+}
+
+func TestTUIDeployStrategy(t *testing.T) {
+	file := settings.Default()
+	file.Deploy.Strategy = "AppConfig.Linear50PercentEvery30Seconds"
+	d := newTUIWithSettings(t, file, nil)
+
+	// new_checkout in development
+	d.press("enter", "enter", "down", "down", "enter", "enter", "left", "enter")
+	if calls := d.backend.Calls("StartDeployment"); calls != 1 {
+		t.Fatalf("expected the toggle to be deployed, got %d deployments", calls)
+	}
+	if strategy := deploymentStrategy(t, d.backend, "dev0001", 3); strategy != file.Deploy.Strategy {
+		t.Errorf("expected the deployment to use %s, got %s", file.Deploy.Strategy, strategy)
+	}
+}
+
+func TestTUIRetryFailedEnvs(t *testing.T) {
+	d := newTUI(t, fake.Fault{Operation: "GetLatestConfiguration", Environment: "staging", Err: fake.ErrThrottling, Times: 1})
+
+	d.press("enter", "enter")
+	d.snapshot("retry/01_failed")
+
+	d.press("R")
+	d.snapshot("retry/02_retried")
+
+	// only staging is fetched again
+	if calls := d.backend.Calls("GetLatestConfiguration"); calls != 4 {
+		t.Errorf("expected 4 GetLatestConfiguration calls, got %d", calls)
+	}
+
+	// nothing left to retry
+	d.press("R")
+	if calls := d.backend.Calls("GetLatestConfiguration"); calls != 4 {
+		t.Errorf("expected no more calls, got %d", calls)
+	}
+}
+
+func TestTUIEscCancelsLoad(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+	d := newTUI(t, fake.Fault{Operation: "GetLatestConfiguration", Environment: "production", Wait: hang})
+
+	d.press("enter")
+	d.pressUntil("enter", "the production fetch to hang", func() bool {
+		return slices.Equal(app.LoadingEnvs(d.model), []string{"production"}) && d.backend.Waiting("GetLatestConfiguration") == 1
+	})
+
+	d.press("esc")
+	d.snapshot("cancel/configs")
+
+	// the hanging call gives up once the load is cancelled, the shared
+	// fetch only notices after the table has stopped waiting for it
+	d.settleUntil("esc to cancel the production fetch", func() bool {
+		return d.backend.Cancelled("GetLatestConfiguration") > 0
+	})
+
+	// loading again starts from scratch
+	d.backend.ClearFaults()
+	d.press("enter")
+	d.snapshot("toggle/03_flags")
+}
+
+func TestTUIWatch(t *testing.T) {
+	d := newTUI(t)
+	d.press("enter", "enter", "w")
+	d.snapshot("watch/01_watching")
+
+	// polling before the poll interval has passed doesn't call AWS
+	d.send(app.PollNow(d.model))
+	if calls := d.backend.Calls("GetLatestConfiguration"); calls != 3 {
+		t.Errorf("expected no polls within the poll interval, got %d calls", calls)
+	}
+
+	// a rollout by someone else shows up on the next poll
+	other := appconfig.NewWithClients(d.backend, d.backend)
+	if _, err := other.SetFlag(context.Background(), "wordle1", "webflg1", "pro0001", "dark_mode", true, ""); err != nil {
+		t.Fatalf("SetFlag: %v", err)
+	}
+	d.clock.Advance(time.Minute)
+	d.send(app.PollNow(d.model))
+	d.snapshot("watch/02_changed")
+	if calls := d.backend.Calls("GetLatestConfiguration"); calls != 6 {
+		t.Errorf("expected one poll per environment, got %d calls", calls)
+	}
+	if calls := d.backend.Calls("StartConfigurationSession"); calls != 3 {
+		t.Errorf("expected polls to reuse the sessions, got %d sessions", calls)
+	}
+
+	// the highlight lasts until the next poll
+	d.clock.Advance(time.Minute)
+	d.send(app.PollNow(d.model))
+	d.snapshot("watch/03_unchanged")
+
+	d.press("w")
+	d.snapshot("toggle/07_flags")
+}
+
+func TestTUIRefresh(t *testing.T) {
+	d := newTUI(t)
+	d.press("enter", "enter")
+	d.snapshot("refresh/01_fetched")
+
+	// still within the poll interval of the data sessions
+	d.clock.Advance(20 * time.Second)
+	d.snapshot("refresh/02_aged")
+
+	// a rollout by someone else only shows up once refreshed
+	other := appconfig.NewWithClients(d.backend, d.backend)
+	if _, err := other.SetFlag(context.Background(), "wordle1", "webflg1", "pro0001", "dark_mode", true, ""); err != nil {
+		t.Fatalf("SetFlag: %v", err)
+	}
+	d.press("r")
+	d.snapshot("refresh/03_refreshed")
+	if calls := d.backend.Calls("GetLatestConfiguration"); calls != 6 {
+		t.Errorf("expected every environment to be fetched again, got %d calls", calls)
+	}
+	if calls := d.backend.Calls("StartConfigurationSession"); calls != 6 {
+		t.Errorf("expected new sessions instead of the flags of the last poll, got %d sessions", calls)
+	}
+
+	// leaving and coming back shows the refreshed copy from the cache
+	d.clock.Advance(30 * time.Second)
+	d.press("esc", "enter")
+	d.snapshot("refresh/04_cached")
+	if calls := d.backend.Calls("GetLatestConfiguration"); calls != 6 {
+		t.Errorf("expected the flags to come from the cache, got %d calls", calls)
+	}
+
+	// the lists show their age too, a refresh resets it
+	d.press("esc")
+	d.snapshot("refresh/05_configs_aged")
+	d.press("r")
+	if calls := d.backend.Calls("ListConfigurationProfiles"); calls != 2 {
+		t.Errorf("expected the configs to be listed again, got %d calls", calls)
+	}
+	d.snapshot("refresh/06_configs_refreshed")
+	d.press("esc", "r")
+	if calls := d.backend.Calls("ListApplications"); calls != 2 {
+		t.Errorf("expected the apps to be listed again, got %d calls", calls)
+	}
+	d.snapshot("toggle/01_apps")
+}
+
+func TestTUIStaleWhileRevalidate(t *testing.T) {
+	file := settings.Default()
+	file.Cache.StaleWhileRevalidate = true
+	d := newTUIWithSettings(t, file, nil)
+	d.press("enter", "enter", "esc")
+
+	other := appconfig.NewWithClients(d.backend, d.backend)
+	if _, err := other.SetFlag(context.Background(), "wordle1", "webflg1", "pro0001", "dark_mode", true, ""); err != nil {
+		t.Fatalf("SetFlag: %v", err)
+	}
+	d.clock.Advance(90 * time.Second)
+
+	// the expired flags show while the refetch is held up
+	release := make(chan struct{})
+	d.backend.AddFault(fake.Fault{Operation: "GetLatestConfiguration", Wait: release})
+	d.pressUntil("enter", "the refetch to be held up", func() bool {
+		return d.backend.Waiting("GetLatestConfiguration") == 3
+	})
+	d.snapshot("stale/01_stale")
+
+	close(release)
+	d.settle()
+	d.snapshot("stale/02_revalidated")
+	if calls := d.backend.Calls("GetLatestConfiguration"); calls != 6 {
+		t.Errorf("expected every environment to be refetched, got %d calls", calls)
+	}
+}
+
+func TestTUICachedApps(t *testing.T) {
+	seed := func(fc *filecache.Cache) {
+		apps := []appconfig.App{{Id: aws.String("wordle1"), Name: aws.String("Old Wordle")}}
+		// already expired, startup shows them and lists the apps again
+		filecache.Add(fc, filecache.Apps, "", apps, -time.Minute)
+	}
+	release := make(chan struct{})
+	d := startTUI(t, settings.Default(), seed, fake.Fault{Operation: "ListApplications", Wait: release})
+	d.settleUntil("the apps to be listed again", func() bool {
+		return d.backend.Waiting("ListApplications") == 1
+	})
+	d.snapshot("cachedapps/01_cached")
+
+	close(release)
+	d.settle()
+	d.snapshot("cachedapps/02_revalidated")
+
+	// the configs are cached like the apps, entering the app again doesn't
+	// list them
+	d.press("enter", "esc", "enter")
+	if calls := d.backend.Calls("ListConfigurationProfiles"); calls != 1 {
+		t.Errorf("expected the configs to come from the cache, got %d calls", calls)
+	}
+	if calls := d.backend.Calls("ListApplications"); calls != 1 {
+		t.Errorf("expected the apps to be listed once, got %d calls", calls)
+	}
+}
+
+func TestTUIOffline(t *testing.T) {
+	// what an earlier, online session cached, long expired since
+	seed := func(fc *filecache.Cache) {
+		ctx := context.Background()
+		backend := fake.NewBackend(fake.DemoData())
+		online := appconfig.NewWithClients(backend, backend)
+		apps, _ := online.ListApps(ctx)
+		configs, _ := online.ListAppFlagConfigs(ctx, "wordle1")
+		flags, _ := online.GetFlags(ctx, "wordle1", "webflg1")
+		filecache.Add(fc, filecache.Apps, "", apps, -time.Hour)
+		filecache.Add(fc, filecache.Profiles, "wordle1", configs, -time.Hour)
+		filecache.Add(fc, filecache.Flags, "wordle1:webflg1", flags, -time.Hour)
+	}
+	file := settings.Default()
+	file.Cache.Offline = true
+	d := newTUIWithSettings(t, file, seed)
+	d.clock.Advance(2 * time.Hour)
+	d.snapshot("offline/01_apps")
+
+	d.press("enter", "enter", "r", "w")
+	d.snapshot("offline/02_flags")
+
+	d.press("enter", "enter")
+	d.snapshot("offline/03_read_only")
+
+	// the flags of the other profile were never cached
+	d.press("esc", "esc", "down", "enter")
+	d.snapshot("offline/04_not_cached")
+
+	for _, op := range []string{"ListApplications", "ListConfigurationProfiles", "ListEnvironments", "StartConfigurationSession", "GetLatestConfiguration"} {
+		if calls := d.backend.Calls(op); calls != 0 {
+			t.Errorf("expected no %s calls offline, got %d", op, calls)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/audit"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/policy"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+)
+
+func init() {
+	// the driver of the TUI tests waits for every command to finish, the
+	// tests read the age off their own clock
+	ageInterval = 0
+	// and poll a watched table with PollNow
+	watchInterval = 0
+	// goldens show cached times the same wherever they're run
+	time.Local = time.UTC
+}
+
+// PollNow returns the message a watched table gets when it's time to poll
+func PollNow(m tea.Model) tea.Msg {
+	return watchTick{gen: m.(Model).watchGen}
+}
+
+// LoadingEnvs returns the environments of the flags table still loading
+func LoadingEnvs(m tea.Model) []string {
+	var envs []string
+	for env := range m.(Model).flagsTable.pending {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	return envs
+}
+
+// WithClock replaces time.Now for stamping flags and showing their age
+func WithClock(m tea.Model, now func() time.Time) tea.Model {
+	model := m.(Model)
+	model.now = now
+	model.flagsTable.now = now
+	model.appsPanel.now = now
+	model.configsPanel.now = now
+	return model
+}
+
+// PolicyCheck refuses the changes of a client that break the rules, see
+// appconfig.WithCheck
+func PolicyCheck(rules *policy.Engine, now func() time.Time) func(context.Context, *appconfig.Client, appconfig.Change) error {
+	return policyCheck(rules, now)
+}
+
+// RunCommand runs a non-interactive command the way lazyflags does, with
+// the settings of a settings file, against an AppConfig compatible endpoint
+func RunCommand(endpoint string, file settings.File, auditLog *audit.Log, stdout, stderr io.Writer, args ...string) int {
+	rules, err := policy.New(file.Policy)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return exitUsage
+	}
+	opts := options{
+		endpoint:    endpoint,
+		policy:      appconfig.DefaultCallPolicy,
+		concurrency: appconfig.DefaultMaxConcurrency,
+		cache:       file.Cache,
+		protected:   file.Protected,
+		rules:       rules,
+		approval:    file.Approval,
+		deploy:      file.Deploy,
+		audit:       auditLog,
+	}
+	return runCommand(opts, args, stdout, stderr)
+}
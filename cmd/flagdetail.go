@@ -5,7 +5,9 @@ import (
 	"io"
 	"strings"
 
+	"github.com/charmbracelet/bubbles/cursor"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	overlay "github.com/rmhubbert/bubbletea-overlay"
@@ -60,6 +62,45 @@ type FlagDetail struct {
 	confirmEnvName  string
 	confirmNewState bool // what state we're confirming to change to
 	confirmBtnIdx   int  // 0 = Yes, 1 = Cancel
+
+	// Deployment of a confirmed toggle in flight
+	deploying bool
+	deployErr string
+
+	// why flags can't be toggled, e.g. offline, empty allows toggles
+	readOnly string
+
+	// environments whose toggles need the environment or flag name typed,
+	// blocked refuses them instead
+	protected map[string]bool
+	blocked   bool
+	// the confirmation of a protected environment is being typed
+	typing   bool
+	input    textinput.Model
+	typedErr string
+
+	// checks a toggle against the policy rules, nil without rules
+	check func(envName string, enabled bool) []string
+	// why the toggle being confirmed is refused, only Cancel is offered
+	violations []string
+
+	// whether toggles of an environment are proposed for approval instead
+	// of deployed, nil deploys everywhere
+	needsApproval func(envName string) bool
+}
+
+var alertStyle = lipgloss.NewStyle().
+	Bold(true).
+	Foreground(lipgloss.Color("#fff")).
+	Background(nordfoxRed).
+	Padding(0, 1)
+
+// flagToggleRequest is sent when the user confirms a toggle. The Model
+// deploys the change and reports back through FinishToggle.
+type flagToggleRequest struct {
+	flagName string
+	envName  string
+	enabled  bool
 }
 
 // Manages the rendering of the flag detail modal.
@@ -83,9 +124,16 @@ func NewFlagDetail(renderBackground func() string) *FlagDetail {
 	l.SetShowHelp(false)
 	l.SetShowPagination(false)
 
+	input := textinput.New()
+	input.Prompt = "> "
+	input.Width = 30
+	// a blinking cursor would re-render the modal twice a second
+	input.Cursor.SetMode(cursor.CursorStatic)
+
 	return &FlagDetail{
 		model:            l,
 		renderBackground: renderBackground,
+		input:            input,
 	}
 }
 
@@ -93,6 +141,9 @@ func (f *FlagDetail) SetData(data FlagRowData, envOrder []string) tea.Cmd {
 	f.flagData = data
 	f.envOrder = envOrder
 	f.confirming = false
+	f.typing = false
+	f.deploying = false
+	f.deployErr = ""
 
 	// Build list items from env states
 	items := make([]list.Item, 0, len(envOrder))
@@ -108,21 +159,38 @@ func (f *FlagDetail) SetData(data FlagRowData, envOrder []string) tea.Cmd {
 }
 
 func (f *FlagDetail) HandleMsg(msg tea.Msg) tea.Cmd {
+	if f.deploying {
+		return nil
+	}
+
+	if f.typing {
+		return f.handleTyping(msg)
+	}
+
 	if f.confirming {
 		// Handle confirmation dialog navigation
 		if keyMsg, ok := msg.(tea.KeyMsg); ok {
 			switch keyMsg.String() {
 			case "left", "h":
-				f.confirmBtnIdx = 0
+				if len(f.violations) == 0 {
+					f.confirmBtnIdx = 0
+				}
 			case "right", "l":
 				f.confirmBtnIdx = 1
 			case "enter":
-				if f.confirmBtnIdx == 0 {
-					// Yes - apply the toggle
-					f.applyToggle()
-				}
 				// Either way, close confirmation
 				f.confirming = false
+				if f.confirmBtnIdx == 0 {
+					// Yes - deploy the toggle
+					f.deploying = true
+					f.deployErr = ""
+					request := flagToggleRequest{
+						flagName: f.flagData.FlagName,
+						envName:  f.confirmEnvName,
+						enabled:  f.confirmNewState,
+					}
+					return func() tea.Msg { return request }
+				}
 			case "esc":
 				f.confirming = false
 			}
@@ -135,36 +203,155 @@ func (f *FlagDetail) HandleMsg(msg tea.Msg) tea.Cmd {
 	return cmd
 }
 
+// handleTyping deploys once the environment or flag name is typed
+func (f *FlagDetail) handleTyping(msg tea.Msg) tea.Cmd {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return nil
+	}
+
+	switch keyMsg.String() {
+	case "enter":
+		typed := strings.TrimSpace(f.input.Value())
+		if typed != f.confirmEnvName && typed != f.flagData.FlagName {
+			f.typedErr = fmt.Sprintf("%q matches neither", typed)
+			return nil
+		}
+		f.confirming = false
+		f.typing = false
+		f.deploying = true
+		f.deployErr = ""
+		request := flagToggleRequest{
+			flagName: f.flagData.FlagName,
+			envName:  f.confirmEnvName,
+			enabled:  f.confirmNewState,
+		}
+		return func() tea.Msg { return request }
+	case "esc":
+		f.CancelConfirm()
+		return nil
+	}
+
+	f.typedErr = ""
+	var cmd tea.Cmd
+	f.input, cmd = f.input.Update(msg)
+	return cmd
+}
+
+// SetProtected marks the environments, keyed by name, whose toggles need a
+// typed confirmation, or are refused when blocked
+func (f *FlagDetail) SetProtected(envs map[string]bool, blocked bool) {
+	f.protected = envs
+	f.blocked = blocked
+}
+
+// SetPolicy sets the check of toggles against the policy rules
+func (f *FlagDetail) SetPolicy(check func(envName string, enabled bool) []string) {
+	f.check = check
+}
+
+// SetApproval sets which environments' toggles are proposed for another
+// user to approve
+func (f *FlagDetail) SetApproval(needsApproval func(envName string) bool) {
+	f.needsApproval = needsApproval
+}
+
+func (f *FlagDetail) proposing() bool {
+	return f.needsApproval != nil && f.needsApproval(f.confirmEnvName)
+}
+
+// SetReadOnly disables toggles, reason is shown instead of the confirmation
+func (f *FlagDetail) SetReadOnly(reason string) {
+	f.readOnly = reason
+}
+
 func (f *FlagDetail) ShowConfirm() {
+	if f.readOnly != "" {
+		f.deployErr = f.readOnly
+		return
+	}
 	idx := f.model.Index()
 	items := f.model.Items()
 	if idx >= 0 && idx < len(items) {
 		if item, ok := items[idx].(EnvItem); ok {
+			if f.protected[item.envName] && f.blocked {
+				f.deployErr = "Protected, start with --elevated"
+				return
+			}
 			f.confirming = true
 			f.confirmEnvName = item.envName
 			f.confirmNewState = !item.enabled
 			f.confirmBtnIdx = 1 // Default to Cancel for safety
+			f.violations = nil
+			if f.check != nil {
+				f.violations = f.check(item.envName, !item.enabled)
+			}
+			// nothing to type for a toggle that is refused anyway
+			if f.protected[item.envName] && len(f.violations) == 0 {
+				f.typing = true
+				f.typedErr = ""
+				f.input.Reset()
+				f.input.Focus()
+			}
 		}
 	}
 }
 
-func (f *FlagDetail) applyToggle() {
-	idx := f.model.Index()
-	items := f.model.Items()
-	if idx >= 0 && idx < len(items) {
-		if item, ok := items[idx].(EnvItem); ok {
-			item.enabled = f.confirmNewState
+// FinishToggle ends a deployment started by a confirmed toggle. The
+// checkbox only changes once the deployment has started.
+func (f *FlagDetail) FinishToggle(envName string, enabled bool, err error) {
+	f.deploying = false
+	if err != nil {
+		f.deployErr = fmt.Sprintf("Error: %v", err)
+		return
+	}
+
+	for idx, listItem := range f.model.Items() {
+		if item, ok := listItem.(EnvItem); ok && item.envName == envName {
+			item.enabled = enabled
 			f.model.SetItem(idx, item)
 		}
 	}
 }
 
+// FinishProposal ends a toggle that was proposed instead of deployed, the
+// checkbox stays until the proposal is approved
+func (f *FlagDetail) FinishProposal(version int32, err error) {
+	f.deploying = false
+	if err != nil {
+		f.deployErr = fmt.Sprintf("Error: %v", err)
+		return
+	}
+	f.deployErr = fmt.Sprintf("Proposed version %d for approval", version)
+}
+
+// Warn adds a warning under the environments, e.g. a change the audit log
+// couldn't record
+func (f *FlagDetail) Warn(warning string) {
+	if f.deployErr != "" {
+		f.deployErr += "\n"
+	}
+	f.deployErr += lipgloss.NewStyle().Width(34).Render(warning)
+}
+
+func (f *FlagDetail) IsDeploying() bool {
+	return f.deploying
+}
+
 func (f *FlagDetail) IsConfirming() bool {
 	return f.confirming
 }
 
+// IsTyping reports whether the confirmation of a protected environment is
+// being typed, every key but esc belongs to the input then
+func (f *FlagDetail) IsTyping() bool {
+	return f.typing
+}
+
 func (f *FlagDetail) CancelConfirm() {
 	f.confirming = false
+	f.typing = false
+	f.input.Blur()
 }
 
 func (f *FlagDetail) SelectedEnv() (string, bool) {
@@ -181,7 +368,9 @@ func (f *FlagDetail) SelectedEnv() (string, bool) {
 func (f *FlagDetail) Render() string {
 	var modal string
 
-	if f.confirming {
+	if f.deploying {
+		modal = f.renderDeployingView()
+	} else if f.confirming {
 		modal = f.renderConfirmView()
 	} else {
 		modal = f.renderListView()
@@ -191,7 +380,25 @@ func (f *FlagDetail) Render() string {
 }
 
 func (f *FlagDetail) renderListView() string {
-	return RenderPanel(f.model.View(), f.flagData.FlagName, 40)
+	content := f.model.View()
+	if f.deployErr != "" {
+		content += "\n" + f.deployErr
+	}
+	return RenderPanel(content, f.flagData.FlagName, 40)
+}
+
+func (f *FlagDetail) renderDeployingView() string {
+	action := "Enabling"
+	if !f.confirmNewState {
+		action = "Disabling"
+	}
+
+	title := "Deploying"
+	if f.proposing() {
+		title = "Proposing"
+	}
+	content := fmt.Sprintf("%s %s in\n%s...", action, f.flagData.FlagName, f.confirmEnvName)
+	return RenderPanel(content, title, 40)
 }
 
 func (f *FlagDetail) renderConfirmView() string {
@@ -211,6 +418,9 @@ func (f *FlagDetail) renderConfirmView() string {
 		Padding(0, 1)
 
 	yesBtn := fmt.Sprintf("Yes, %s", strings.ToLower(action))
+	if f.proposing() {
+		yesBtn = "Yes, propose"
+	}
 	cancelBtn := "Cancel"
 
 	if f.confirmBtnIdx == 0 {
@@ -222,8 +432,31 @@ func (f *FlagDetail) renderConfirmView() string {
 	}
 
 	var content strings.Builder
+	if f.protected[f.confirmEnvName] {
+		content.WriteString(alertStyle.Render("PROTECTED ENVIRONMENT") + "\n\n")
+	}
 	content.WriteString(fmt.Sprintf("%s %s in\n", action, f.flagData.FlagName))
 	content.WriteString(fmt.Sprintf("%s?\n\n", f.confirmEnvName))
+	if f.proposing() && len(f.violations) == 0 {
+		content.WriteString("Another user must approve it\n\n")
+	}
+	if len(f.violations) > 0 {
+		content.WriteString(alertStyle.Render("REFUSED BY POLICY") + "\n")
+		wrap := lipgloss.NewStyle().Width(34)
+		for _, violation := range f.violations {
+			content.WriteString(wrap.Render("- "+violation) + "\n")
+		}
+		content.WriteString("\n" + cancelBtn)
+		return RenderPanel(content.String(), "Confirm", 40)
+	}
+	if f.typing {
+		content.WriteString(fmt.Sprintf("Type %s or %s\nto confirm:\n", f.confirmEnvName, f.flagData.FlagName))
+		content.WriteString(f.input.View())
+		if f.typedErr != "" {
+			content.WriteString("\n" + f.typedErr)
+		}
+		return RenderPanel(content.String(), "Confirm", 40)
+	}
 	content.WriteString(yesBtn + "     " + cancelBtn)
 
 	return RenderPanel(content.String(), "Confirm", 40)
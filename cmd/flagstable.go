@@ -1,19 +1,36 @@
 package app
 
 import (
+	"fmt"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/table"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
 )
 
-const nordfoxBlue = lipgloss.Color("#8cafd2")
+const (
+	nordfoxBlue   = lipgloss.Color("#8cafd2")
+	nordfoxYellow = lipgloss.Color("#dbc074")
+	nordfoxRed    = lipgloss.Color("#c94f6d")
+)
 
 const (
 	FeatureFlagsTitle = "Feature Flags"
+
+	// shown in the cells of an environment that failed to load
+	errorState = "error"
+
+	// rows of the table including its header, footnotes take their space
+	tableHeight = 21
+
+	// prefixes cells whose state changed since the previous fetch
+	changedMarker = "» "
 )
 
 type FlagsTable struct {
@@ -22,6 +39,22 @@ type FlagsTable struct {
 	tableWidth int
 	model      table.Model
 	data       FlagsTableData
+
+	// results as they arrive, environments still loading show a spinner
+	results []appconfig.Result
+	pending map[string]bool
+	spinner spinner.Model
+
+	// watching re-polls the flags, changed holds the cells that changed in
+	// the latest poll keyed by flag and environment name
+	watching bool
+	changed  map[[2]string]bool
+
+	// when the flags shown were fetched, zero while they're unknown, stale
+	// flags expired and are being refetched
+	updated time.Time
+	stale   bool
+	now     func() time.Time
 }
 
 // Manages the rendering of the flags table panel.
@@ -43,24 +76,23 @@ func NewFlagsTable(height int, minWidth int, flags []appconfig.Result) *FlagsTab
 	ft := &FlagsTable{
 		height:   height,
 		minWidth: minWidth,
+		pending:  make(map[string]bool),
+		spinner:  spinner.New(spinner.WithSpinner(spinner.MiniDot)),
+		changed:  make(map[[2]string]bool),
+		now:      time.Now,
 	}
 	ft.buildTable(flags)
 	return ft
 }
 
 func (t *FlagsTable) buildTable(flags []appconfig.Result) {
-	columns := []table.Column{
-		{Title: "Flag Name", Width: 20},
-	}
+	t.results = flags
 
 	envOrder := make([]string, 0, len(flags))
 	for _, flag := range flags {
 		envOrder = append(envOrder, flag.EnvName)
-		columns = append(columns, table.Column{
-			Title: flag.EnvName,
-			Width: 15,
-		})
 	}
+	columns := t.columns()
 
 	// Calculate total width from columns (+ padding between columns)
 	t.tableWidth = 0
@@ -85,22 +117,268 @@ func (t *FlagsTable) buildTable(flags []appconfig.Result) {
 
 	t.model = table.New(
 		table.WithColumns(columns),
-		table.WithRows(t.data.ToTableRows()),
+		table.WithRows(t.rows()),
 		table.WithFocused(true),
 		table.WithStyles(s),
 	)
+	t.fitFootnotes()
+}
+
+// columns has one column per environment, environments that failed to
+// load are marked with the number of their footnote
+func (t *FlagsTable) columns() []table.Column {
+	columns := []table.Column{
+		{Title: "Flag Name", Width: 20},
+	}
+
+	footnote := 0
+	for _, result := range t.results {
+		title := result.EnvName
+		if result.Err != nil {
+			footnote++
+			title = fmt.Sprintf("%s [%d]", result.EnvName, footnote)
+		}
+		columns = append(columns, table.Column{
+			Title: title,
+			Width: 15,
+		})
+	}
+	return columns
 }
 
 func (t *FlagsTable) SetData(flags []appconfig.Result) tea.Cmd {
+	t.pending = make(map[string]bool)
+	t.changed = make(map[[2]string]bool)
+	t.updated = time.Time{}
+	t.stale = false
 	t.buildTable(flags)
 	return nil
 }
 
+// SetPending lays out a column per environment before any flags are known.
+// Results are filled in with SetResult as they arrive.
+func (t *FlagsTable) SetPending(envs []appconfig.AppEnvironments) tea.Cmd {
+	t.pending = make(map[string]bool)
+	t.changed = make(map[[2]string]bool)
+	t.updated = time.Time{}
+	t.stale = false
+	results := make([]appconfig.Result, 0, len(envs))
+	for _, env := range envs {
+		t.pending[*env.Name] = true
+		results = append(results, appconfig.Result{
+			EnvId:    *env.Id,
+			EnvName:  *env.Name,
+			EnvState: env.State,
+		})
+	}
+	t.buildTable(results)
+	if len(envs) == 0 {
+		return nil
+	}
+	return t.spinner.Tick
+}
+
+// SetResult fills in the column of one environment. Flags that differ from
+// the environment's previous result are marked as changed.
+func (t *FlagsTable) SetResult(result appconfig.Result) {
+	for i, existing := range t.results {
+		if existing.EnvId != result.EnvId {
+			continue
+		}
+		if existing.Flags != nil && result.Err == nil {
+			for name, flag := range result.Flags {
+				if previous, ok := existing.Flags[name]; !ok || previous != flag {
+					t.changed[[2]string{name, result.EnvName}] = true
+				}
+			}
+		}
+		t.results[i] = result
+	}
+	delete(t.pending, result.EnvName)
+
+	t.data = pivotResults(t.results, t.data.EnvOrder)
+	t.model.SetColumns(t.columns())
+	t.model.SetRows(t.rows())
+	t.fitFootnotes()
+}
+
+// SetRetrying marks environments as loading again, their results are filled
+// in with SetResult
+func (t *FlagsTable) SetRetrying(envs []appconfig.AppEnvironments) tea.Cmd {
+	for _, env := range envs {
+		for i, result := range t.results {
+			if result.EnvId == *env.Id {
+				t.results[i].Err = nil
+				t.pending[result.EnvName] = true
+			}
+		}
+	}
+
+	t.data = pivotResults(t.results, t.data.EnvOrder)
+	t.model.SetColumns(t.columns())
+	t.model.SetRows(t.rows())
+	t.fitFootnotes()
+	return t.spinner.Tick
+}
+
+// SetWatching shows whether the table is being re-polled
+func (t *FlagsTable) SetWatching(watching bool) {
+	t.watching = watching
+	if !watching {
+		t.ClearChanges()
+	}
+}
+
+// SetUpdated records when the flags shown were fetched, their age is shown
+// in the title
+func (t *FlagsTable) SetUpdated(updated time.Time) {
+	t.updated = updated
+}
+
+func (t *FlagsTable) Updated() time.Time {
+	return t.updated
+}
+
+// SetStale marks the flags shown as expired
+func (t *FlagsTable) SetStale(stale bool) {
+	t.stale = stale
+}
+
+// ClearChanges removes the changed markers, before the next poll
+func (t *FlagsTable) ClearChanges() {
+	t.changed = make(map[[2]string]bool)
+	t.model.SetRows(t.rows())
+}
+
+// Envs returns the environments of the table's columns
+func (t *FlagsTable) Envs() []appconfig.AppEnvironments {
+	return t.envs(func(appconfig.Result) bool { return true })
+}
+
+// FailedEnvs returns the environments whose flags failed to load
+func (t *FlagsTable) FailedEnvs() []appconfig.AppEnvironments {
+	return t.envs(func(result appconfig.Result) bool { return result.Err != nil })
+}
+
+func (t *FlagsTable) envs(include func(appconfig.Result) bool) []appconfig.AppEnvironments {
+	var envs []appconfig.AppEnvironments
+	for _, result := range t.results {
+		if include(result) {
+			envs = append(envs, appconfig.AppEnvironments{
+				Id:    aws.String(result.EnvId),
+				Name:  aws.String(result.EnvName),
+				State: result.EnvState,
+			})
+		}
+	}
+	return envs
+}
+
+// IsLoading reports whether any environment is still pending
+func (t *FlagsTable) IsLoading() bool {
+	return len(t.pending) > 0
+}
+
+// Results returns the results received so far, in column order
+func (t *FlagsTable) Results() []appconfig.Result {
+	return t.results
+}
+
+// rows renders the table rows, marking environments that are still loading
+// and cells that changed
+func (t *FlagsTable) rows() []table.Row {
+	rows := t.data.ToTableRows()
+	for _, row := range rows {
+		for i, envName := range t.data.EnvOrder {
+			if t.pending[envName] {
+				row[i+1] = t.spinner.View()
+			} else if t.changed[[2]string{row[0], envName}] {
+				row[i+1] = changedMarker + row[i+1]
+			}
+		}
+	}
+	return rows
+}
+
+func (t *FlagsTable) title() string {
+	var notes []string
+	if t.watching {
+		notes = append(notes, "watching")
+	}
+	if t.stale {
+		notes = append(notes, "stale")
+	}
+	if !t.updated.IsZero() && !t.IsLoading() {
+		notes = append(notes, "cached "+formatAge(t.now().Sub(t.updated))+" ago")
+	}
+	if len(notes) == 0 {
+		return FeatureFlagsTitle
+	}
+	return FeatureFlagsTitle + " (" + strings.Join(notes, ", ") + ")"
+}
+
+// formatAge rounds down to the largest unit, e.g. 42s, 3m or 2h
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return fmt.Sprintf("%ds", int(max(age, 0).Seconds()))
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	default:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	}
+}
+
+// fitFootnotes shrinks the table so the footnotes fit below it
+func (t *FlagsTable) fitFootnotes() {
+	height := tableHeight
+	if footnotes := t.footnotes(); len(footnotes) > 0 {
+		height -= len(footnotes) + 1
+	}
+	t.model.SetHeight(height)
+}
+
+// footnotes explain the errors of environments that failed to load
+func (t *FlagsTable) footnotes() []string {
+	var lines []string
+	for _, result := range t.results {
+		if result.Err != nil {
+			lines = append(lines, fmt.Sprintf("[%d] %s: %v", len(lines)+1, result.EnvName, result.Err))
+		}
+	}
+	if len(lines) > 0 {
+		lines = append(lines, "Press R to retry failed environments")
+	}
+	return lines
+}
+
+// SetFlagState updates a single cell in place, keeping the cursor where it is
+func (t *FlagsTable) SetFlagState(flagName string, envName string, enabled bool) {
+	for i, result := range t.results {
+		if result.EnvName == envName && result.Flags != nil {
+			flags := make(appconfig.Flags, len(result.Flags))
+			for name, flag := range result.Flags {
+				flags[name] = flag
+			}
+			flags[flagName] = appconfig.Flag{Enabled: enabled}
+			t.results[i].Flags = flags
+		}
+	}
+
+	t.data = pivotResults(t.results, t.data.EnvOrder)
+	t.model.SetRows(t.rows())
+}
+
 func (t *FlagsTable) Render() string {
 	if len(t.data.Flags) == 0 {
 		msg := "You have no flags"
-		paddedMsg := msg + strings.Repeat("\n", t.height-1)
-		return RenderPanel(paddedMsg, FeatureFlagsTitle, t.minWidth)
+		if t.IsLoading() {
+			msg = t.spinner.View() + " Loading flags"
+		} else if footnotes := t.footnotes(); len(footnotes) > 0 {
+			msg = "Could not load flags\n\n" + strings.Join(footnotes, "\n")
+		}
+		paddedMsg := msg + strings.Repeat("\n", t.height-strings.Count(msg, "\n")-1)
+		return RenderPanel(paddedMsg, t.title(), t.minWidth)
 	}
 
 	// Shift table content 1 space left to align with panel title
@@ -118,7 +396,11 @@ func (t *FlagsTable) Render() string {
 			}
 		}
 	}
-	return RenderPanel(strings.Join(lines, "\n"), FeatureFlagsTitle, t.tableWidth+7)
+	if footnotes := t.footnotes(); len(footnotes) > 0 {
+		lines = append(lines, "")
+		lines = append(lines, footnotes...)
+	}
+	return RenderPanel(strings.Join(lines, "\n"), t.title(), t.tableWidth+7)
 }
 
 func (t *FlagsTable) RenderError(errMsg string) string {
@@ -127,6 +409,17 @@ func (t *FlagsTable) RenderError(errMsg string) string {
 }
 
 func (t *FlagsTable) HandleMsg(msg tea.Msg) tea.Cmd {
+	if tick, ok := msg.(spinner.TickMsg); ok {
+		// stop ticking once every environment has loaded
+		if !t.IsLoading() {
+			return nil
+		}
+		var cmd tea.Cmd
+		t.spinner, cmd = t.spinner.Update(tick)
+		t.model.SetRows(t.rows())
+		return cmd
+	}
+
 	var cmd tea.Cmd
 	t.model, cmd = t.model.Update(msg)
 	return cmd
@@ -155,8 +448,12 @@ func (t *FlagsTable) EnvOrder() []string {
 func pivotResults(results []appconfig.Result, envOrder []string) FlagsTableData {
 	// Build lookup map: flagName -> envName -> enabled
 	flagStates := make(map[string]map[string]bool)
+	failed := make(map[string]bool)
 
 	for _, result := range results {
+		if result.Err != nil {
+			failed[result.EnvName] = true
+		}
 		for flagName, flag := range result.Flags {
 			if flagStates[flagName] == nil {
 				flagStates[flagName] = make(map[string]bool)
@@ -174,7 +471,9 @@ func pivotResults(results []appconfig.Result, envOrder []string) FlagsTableData
 
 		for _, envName := range envOrder {
 			enabled, exists := envMap[envName]
-			if !exists {
+			if failed[envName] {
+				flagRow.EnvStates[envName] = errorState
+			} else if !exists {
 				flagRow.EnvStates[envName] = "-"
 			} else if enabled {
 				flagRow.EnvStates[envName] = "on"
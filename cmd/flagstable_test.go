@@ -1,6 +1,7 @@
 package app_test
 
 import (
+	"errors"
 	"strings"
 	"testing"
 
@@ -100,6 +101,83 @@ func TestFlagsTableRender(t *testing.T) {
 				"└────────────────────────────────────────────────┘",
 			}, "\n"),
 		},
+		{
+			name: "should render environments that failed to load with a footnote",
+			flags: []appconfig.Result{
+				{
+					EnvName: "development",
+					Flags: appconfig.Flags{
+						"dark_mode": {Enabled: true},
+					},
+				},
+				{
+					EnvName: "production",
+					Err:     errors.New("AccessDeniedException: denied"),
+				},
+			},
+			expected: strings.Join([]string{
+				"┌─ Feature Flags ─────────────────────────────────────────────┐",
+				"│                                                             │",
+				"│  Flag Name             development      production [1]      │",
+				"│  dark_mode             on               error               │",
+				"│                                                             │",
+				"│                                                             │",
+				"│                                                             │",
+				"│                                                             │",
+				"│                                                             │",
+				"│                                                             │",
+				"│                                                             │",
+				"│                                                             │",
+				"│                                                             │",
+				"│                                                             │",
+				"│                                                             │",
+				"│                                                             │",
+				"│                                                             │",
+				"│                                                             │",
+				"│                                                             │",
+				"│                                                             │",
+				"│                                                             │",
+				"│  [1] production: AccessDeniedException: denied              │",
+				"│  Press R to retry failed environments                       │",
+				"│                                                             │",
+				"└─────────────────────────────────────────────────────────────┘",
+			}, "\n"),
+		},
+		{
+			name: "should render the errors when no environment loaded",
+			flags: []appconfig.Result{
+				{
+					EnvName: "production",
+					Err:     errors.New("AccessDeniedException: denied"),
+				},
+			},
+			expected: strings.Join([]string{
+				"┌─ Feature Flags ────────────────────────────────┐",
+				"│                                                │",
+				"│  Could not load flags                          │",
+				"│                                                │",
+				"│  [1] production: AccessDeniedException: denied │",
+				"│  Press R to retry failed environments          │",
+				"│                                                │",
+				"│                                                │",
+				"│                                                │",
+				"│                                                │",
+				"│                                                │",
+				"│                                                │",
+				"│                                                │",
+				"│                                                │",
+				"│                                                │",
+				"│                                                │",
+				"│                                                │",
+				"│                                                │",
+				"│                                                │",
+				"│                                                │",
+				"│                                                │",
+				"│                                                │",
+				"│                                                │",
+				"└────────────────────────────────────────────────┘",
+			}, "\n"),
+		},
 	}
 
 	for _, tt := range tests {
@@ -3,6 +3,7 @@ package app
 import (
 	"io"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
@@ -14,6 +15,9 @@ type ListPanel struct {
 	width  int
 	title  string
 	model  list.Model
+	// when the items were fetched, the title shows their age unless zero
+	updated time.Time
+	now     func() time.Time
 }
 
 // controls the display of active list item, and satisfies some
@@ -67,11 +71,24 @@ func NewListPanel(height int, width int, title string, items []list.Item) *ListP
 		width:  width,
 		title:  title,
 		model:  l,
+		now:    time.Now,
 	}
 }
 
 func (p *ListPanel) Render() string {
-	return RenderPanel(p.model.View(), p.title, p.width)
+	return RenderPanel(p.model.View(), p.panelTitle(), p.width)
+}
+
+// SetUpdated sets when the items were fetched or cached
+func (p *ListPanel) SetUpdated(t time.Time) {
+	p.updated = t
+}
+
+func (p *ListPanel) panelTitle() string {
+	if p.updated.IsZero() {
+		return p.title
+	}
+	return p.title + " (cached " + formatAge(p.now().Sub(p.updated)) + " ago)"
 }
 
 func (p *ListPanel) RenderError(errMsg string) string {
@@ -3,18 +3,112 @@ package app
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"os/user"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/smithy-go"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/audit"
 	"github.com/simonschwartz/app-config-lazy-flags/internal/filecache"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/policy"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
 )
 
+// options shared by the TUI and every command
+type options struct {
+	// base URL of an AppConfig compatible API, e.g. the local server
+	endpoint string
+	// timeout and retries of every AWS call
+	policy appconfig.CallPolicy
+	// environments fetched at once
+	concurrency int
+	// file cache policy from the settings file and flags
+	cache settings.Cache
+	// environments whose changes need a typed confirmation
+	protected settings.Protected
+	// rules every change must pass before a version is created
+	rules *policy.Engine
+	// environments whose changes another user approves
+	approval settings.Approval
+	// strategy of deployments started without --strategy
+	deploy settings.Deploy
+	// where every change made is logged
+	audit *audit.Log
+}
+
 func Run() {
+	opts := options{policy: appconfig.DefaultCallPolicy, concurrency: appconfig.DefaultMaxConcurrency}
+	// flags are parsed before the settings file is read so -h works with a
+	// broken settings file, the cache flags given override it below
+	defaults := settings.Default().Cache
+	var cacheFlags settings.Cache
+	var elevated bool
+	global := flag.NewFlagSet("lazyflags", flag.ExitOnError)
+	global.StringVar(&opts.endpoint, "endpoint", os.Getenv("LAZYFLAGS_ENDPOINT"), "AppConfig endpoint URL, e.g. http://localhost:4566 for `lazyflags serve`")
+	global.DurationVar(&opts.policy.Timeout, "timeout", opts.policy.Timeout, "timeout of each AWS call including retries, 0 disables it")
+	global.IntVar(&opts.policy.MaxAttempts, "max-attempts", opts.policy.MaxAttempts, "attempts per AWS call, throttled calls back off between attempts")
+	global.IntVar(&opts.concurrency, "concurrency", opts.concurrency, "environments whose flags are fetched at once")
+	global.DurationVar(&cacheFlags.TTL, "cache-ttl", defaults.TTL, "how long fetched flags are cached")
+	global.BoolVar(&cacheFlags.StaleWhileRevalidate, "stale-while-revalidate", defaults.StaleWhileRevalidate, "show expired flags at once while they're refetched")
+	global.BoolVar(&cacheFlags.Offline, "offline", false, "serve everything from the cache and never call AWS")
+	global.BoolVar(&elevated, "elevated", false, "allow changes to protected environments the settings file blocks")
+	global.Usage = func() { printUsage(global.Output()) }
+	global.Parse(os.Args[1:])
+
+	file, err := settings.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(exitUsage)
+	}
+
+	rules, err := policy.New(file.Policy)
+	if err != nil {
+		path, _ := settings.Path()
+		fmt.Fprintf(os.Stderr, "error: %s: %v\n", path, err)
+		os.Exit(exitUsage)
+	}
+
+	auditPath, err := audit.DefaultPath()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(exitUsage)
+	}
+
+	opts.cache, opts.protected, opts.rules, opts.approval, opts.deploy, opts.audit = file.Cache, file.Protected, rules, file.Approval, file.Deploy, audit.Open(auditPath)
+	opts.cache.Offline, opts.protected.Elevated = cacheFlags.Offline, elevated
+	global.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		// a TTL on the command line replaces the default TTL of the settings
+		// file, profiles with a TTL of their own keep it
+		case "cache-ttl":
+			opts.cache.TTL = cacheFlags.TTL
+		case "stale-while-revalidate":
+			opts.cache.StaleWhileRevalidate = cacheFlags.StaleWhileRevalidate
+		}
+	})
+
+	if global.NArg() == 0 {
+		runTUI(opts)
+		return
+	}
+
+	os.Exit(runCommand(opts, global.Args(), os.Stdout, os.Stderr))
+}
+
+func runTUI(opts options) {
 	if len(os.Getenv("DEBUG")) > 0 {
 		f, err := tea.LogToFile("debug.log", "debug")
 		if err != nil {
@@ -24,21 +118,20 @@ func Run() {
 		defer f.Close()
 	}
 
-	ctx := context.TODO()
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	cacheClient, err := filecache.New()
+	client, cacheClient, err := newClients(context.Background(), opts)
 	if err != nil {
 		log.Fatal(err)
 	}
-	client := appconfig.New(cfg)
 
 	p := tea.NewProgram(
-		NewModel(client, cacheClient),
+		NewModel(client, cacheClient, ModelOptions{
+			Cache:     opts.cache,
+			Protected: opts.protected,
+			Rules:     opts.rules,
+			Approval:  opts.approval,
+			Deploy:    opts.deploy,
+			Audit:     opts.audit,
+		}),
 		tea.WithAltScreen(),       // Use alternate screen buffer (full screen)
 		// tea.WithMouseCellMotion(), // Enable mouse support
 	)
@@ -49,6 +142,187 @@ func Run() {
 	}
 }
 
+// runCommand runs a non-interactive command and returns its exit code,
+// output goes to stdout and errors to stderr
+func runCommand(opts options, args []string, stdout io.Writer, stderr io.Writer) int {
+	cmd, cmdArgs, ok := findCommand(args)
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", strings.Join(args, " "))
+		printUsage(stderr)
+		return exitUsage
+	}
+
+	// flag configs are logged for TUI debugging, keep them out of script output
+	if len(os.Getenv("DEBUG")) == 0 {
+		log.SetOutput(io.Discard)
+	}
+
+	// ctrl+c cancels the calls in flight instead of killing the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if opts.cache.Offline && !cmd.standalone && !cmd.offline {
+		fmt.Fprintf(stderr, "error: %s needs AWS and can't run with --offline\n", cmd.name)
+		return exitUsage
+	}
+
+	exit := func(err error) int {
+		var help helpRequest
+		switch {
+		case err == nil:
+			return exitOK
+		case errors.As(err, &help):
+			printCommandUsage(stdout, cmd, help.fs)
+			return exitOK
+		}
+		fmt.Fprintln(stderr, "error:", err)
+		return exitCode(err)
+	}
+
+	c := &cli{out: stdout, errOut: stderr, cacheConfig: opts.cache, protected: opts.protected, rules: opts.rules, approval: opts.approval, strategy: deployStrategy(opts.deploy), audit: opts.audit}
+
+	// -h needs no AWS session, the command stops once its flags are parsed
+	if wantsHelp(cmdArgs) {
+		help := *c
+		help.helpOnly = true
+		if err := cmd.run(&help, ctx, cmdArgs); !errors.Is(err, errNotHelp) {
+			return exit(err)
+		}
+	}
+
+	if !cmd.standalone {
+		client, cacheClient, err := newClients(ctx, opts)
+		if err != nil {
+			return exit(err)
+		}
+		c.client, c.cache = client, cacheClient
+	}
+
+	err := cmd.run(c, ctx, cmdArgs)
+	// what was changed stays changed, the exit code is the command's
+	if failure := opts.audit.Failure(); failure != nil {
+		fmt.Fprintln(stderr, "warning: not written to the audit log:", failure)
+	}
+	return exit(err)
+}
+
+// deployStrategy returns the strategy of deployments started without
+// --strategy
+func deployStrategy(deploy settings.Deploy) string {
+	if deploy.Strategy == "" {
+		return appconfig.DefaultDeploymentStrategy
+	}
+	return deploy.Strategy
+}
+
+func newClients(ctx context.Context, opts options) (*appconfig.Client, *filecache.Cache, error) {
+	var loadOpts []func(*config.LoadOptions) error
+	if opts.endpoint != "" {
+		// the local server accepts any credentials, don't require an AWS session
+		loadOpts = append(loadOpts,
+			config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("local", "local", "")),
+			config.WithRegion("us-east-1"),
+		)
+	}
+
+	// Load the Shared AWS Configuration (~/.aws/config)
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.endpoint != "" {
+		cfg.BaseEndpoint = aws.String(opts.endpoint)
+	}
+	opts.policy.Apply(&cfg)
+
+	var identity filecache.Identity
+	var caller string
+	if opts.cache.Offline {
+		identity, err = offlineIdentity(opts.cache, cfg, opts.endpoint)
+	} else {
+		identity, caller, err = resolveIdentity(ctx, cfg, opts.endpoint)
+	}
+	if err != nil {
+		// the calls that follow report the error, until then nothing is cached
+		log.Printf("cache: could not resolve the AWS account, nothing is cached: %v", err)
+	}
+	cacheClient, err := openCache(opts.cache, filecache.WithIdentity(identity))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client := appconfig.New(cfg,
+		appconfig.WithMaxConcurrency(opts.concurrency),
+		// environment tags are looked up by ARN
+		appconfig.WithAccount(identity.Account),
+		// proposals record who made them and can't be approved by them
+		appconfig.WithCaller(caller),
+		appconfig.WithAudit(opts.audit.Record),
+		// every change is checked against the policy rules before it's made
+		appconfig.WithCheck(policyCheck(opts.rules, time.Now)),
+	)
+	return client, cacheClient, nil
+}
+
+// resolveIdentity returns the account and region of the credentials, cached
+// flags are namespaced by them, and the ARN of the caller
+func resolveIdentity(ctx context.Context, cfg aws.Config, endpoint string) (filecache.Identity, string, error) {
+	if endpoint != "" {
+		return filecache.Identity{Account: "local", Region: cfg.Region, Endpoint: endpoint}, localCaller(), nil
+	}
+
+	out, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return filecache.Identity{}, "", err
+	}
+	return filecache.Identity{
+		Account: aws.ToString(out.Account),
+		Region:  cfg.Region,
+		Profile: os.Getenv("AWS_PROFILE"),
+	}, aws.ToString(out.Arn), nil
+}
+
+// localCaller stands in for the caller ARN against a local endpoint, which
+// accepts any credentials. LAZYFLAGS_USER tries the approval flow as
+// someone else.
+func localCaller() string {
+	if name := os.Getenv("LAZYFLAGS_USER"); name != "" {
+		return "local/" + name
+	}
+	if u, err := user.Current(); err == nil {
+		return "local/" + u.Username
+	}
+	return ""
+}
+
+// offlineIdentity picks the identity that last cached anything in the
+// region with the same AWS profile, STS can't be asked offline
+func offlineIdentity(cacheConfig settings.Cache, cfg aws.Config, endpoint string) (filecache.Identity, error) {
+	if endpoint != "" {
+		return filecache.Identity{Account: "local", Region: cfg.Region, Endpoint: endpoint}, nil
+	}
+
+	cache, err := openCache(cacheConfig)
+	if err != nil {
+		return filecache.Identity{}, err
+	}
+	var identity filecache.Identity
+	var newest time.Time
+	for _, entry := range cache.Entries() {
+		id := entry.Identity
+		if id.IsZero() || id.Endpoint != "" || id.Region != cfg.Region || id.Profile != os.Getenv("AWS_PROFILE") {
+			continue
+		}
+		if entry.Cached.After(newest) {
+			identity, newest = id, entry.Cached
+		}
+	}
+	if identity.IsZero() {
+		return identity, fmt.Errorf("nothing cached for region %q", cfg.Region)
+	}
+	return identity, nil
+}
+
 func isCredentialError(err error) bool {
 	// Check for HTTP 403 Forbidden
 	var apiErr smithy.APIError
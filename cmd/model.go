@@ -14,12 +14,20 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"time"
 
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/audit"
 	"github.com/simonschwartz/app-config-lazy-flags/internal/filecache"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/policy"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
 )
 
 type view int
@@ -36,40 +44,114 @@ const (
 
 	// detail view for modifying a single flag's state across environments
 	flagDetail
+
+	// changes proposed for the flags shown, reviewed and approved by
+	// another user
+	proposalList
+
+	// changes made to the flags shown through lazyflags, from the audit log
+	auditList
+
+	// the last deployment to each environment of the flags shown, undone by
+	// redeploying the version live before it
+	undoList
 )
 
 type Model struct {
 	appconfigClient appconfig.Client
 	filecache       filecache.Cache
+	cacheConfig     settings.Cache
+	protected       settings.Protected
+	rules           *policy.Engine
+	approval        settings.Approval
+	strategy        string
+	audit           *audit.Log
+
+	// cancelled on quit, every load derives from it
+	ctx    context.Context
+	cancel context.CancelFunc
+	// cancel the load of a view when navigating away from it
+	configsCancel context.CancelFunc
+	flagsCancel   context.CancelFunc
 
 	activeView view
 
 	appsPanel      *ListPanel
 	appsPanelError string
+	// when the apps and configs shown were fetched or cached, their panels
+	// show the age
+	appsCachedAt time.Time
 
 	configsPanel      *ListPanel
-	configsCache      map[string]configsLoader
 	configsPanelError string
+	configsCachedAt   time.Time
 
 	flagsTable      *FlagsTable
 	flagsTableError string
+	// app and config the flags table shows, with the ids of its environments
+	flagsAppId    string
+	flagsConfigId string
+	flagsEnvIds   map[string]string
+	// results of the load in progress, messages from older loads are dropped
+	flagsResults <-chan appconfig.Result
+	// re-poll the flags every watchInterval, watchGen tells apart the
+	// ticks of earlier watches
+	watching bool
+	watchGen int
+
+	// re-renders the age of the flags every ageInterval while they're shown
+	ageTicking bool
+	// stamps fetched flags, replaced in tests
+	now func() time.Time
 
 	flagDetail *FlagDetail
 	// Flag detail view state
 	selectedFlagIdx int // which flag in flagsData.Flags is selected (-1 = none)
 	selectedEnvIdx  int // which environment is highlighted in detail view
+
+	proposalsView *ProposalsView
+	auditView     *AuditView
+	undoView      *UndoView
+}
+
+// ModelOptions configures the TUI, usually from the settings file and the
+// command line
+type ModelOptions struct {
+	Cache     settings.Cache
+	Protected settings.Protected
+	// checked before every change, nil checks nothing
+	Rules    *policy.Engine
+	Approval settings.Approval
+	// strategy of the deployments the TUI starts
+	Deploy settings.Deploy
+	// the log the client records changes in, see appconfig.WithAudit
+	Audit *audit.Log
 }
 
-func NewModel(appconfigClient *appconfig.Client, filecache *filecache.Cache) Model {
+func NewModel(appconfigClient *appconfig.Client, filecache *filecache.Cache, opts ModelOptions) Model {
 	appsPanel := NewAppsPanel(20, 50, []appconfig.App{})
 	configsPanel := NewConfigsPanel(20, 50, []appconfig.AppFlagConfig{})
 	flagsTable := NewFlagsTable(20, 50, []appconfig.Result{})
 	flagDetail := NewFlagDetail(flagsTable.Render)
+	ctx, cancel := context.WithCancel(context.Background())
+	if opts.Cache.Offline {
+		flagDetail.SetReadOnly("Offline, flags can't be changed")
+	}
+	flagDetail.SetApproval(opts.Approval.Required)
+	undoView := NewUndoView(20, 80)
+	undoView.SetApproval(opts.Approval.Required)
 
 	return Model{
 		appconfigClient: *appconfigClient,
-		configsCache:    make(map[string]configsLoader),
+		ctx:             ctx,
+		cancel:          cancel,
 		filecache:       *filecache,
+		cacheConfig:     opts.Cache,
+		protected:       opts.Protected,
+		rules:           opts.Rules,
+		approval:        opts.Approval,
+		strategy:        deployStrategy(opts.Deploy),
+		audit:           opts.Audit,
 		activeView:      appList,
 		selectedFlagIdx: -1,
 		selectedEnvIdx:  0,
@@ -77,6 +159,10 @@ func NewModel(appconfigClient *appconfig.Client, filecache *filecache.Cache) Mod
 		configsPanel:    configsPanel,
 		flagsTable:      flagsTable,
 		flagDetail:      flagDetail,
+		proposalsView:   NewProposalsView(20, 80),
+		auditView:       NewAuditView(20, 100),
+		undoView:        undoView,
+		now:             time.Now,
 	}
 }
 
@@ -88,48 +174,271 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case appsLoader:
 		if msg.err != nil {
+			// the last known apps stay up when they can't be refetched
+			if msg.revalidate {
+				return m, nil
+			}
 			m.appsPanelError = fmt.Sprintf("Error: %v", msg.err)
 			return m, nil
 		}
 
 		m.appsPanelError = ""
+		m.appsCachedAt = m.loadedAt(msg.cachedAt)
+		m.appsPanel.SetUpdated(m.appsCachedAt)
 		var appItems []list.Item
 		for _, app := range msg.apps {
 			appItems = append(appItems, AppItem(app))
 		}
 		cmd := m.appsPanel.SetItems(appItems)
-		return m, cmd
+		if msg.stale {
+			return m, tea.Batch(cmd, m.startAgeTicks(), m.fetchAppsCmd(true))
+		}
+		return m, tea.Batch(cmd, m.startAgeTicks())
 	case configsLoader:
+		// the user navigated away before the load finished
+		if msg.ctx.Err() != nil {
+			return m, nil
+		}
+		if msg.revalidate {
+			// the configs shown stay up when they can't be refetched
+			if msg.err != nil {
+				return m, nil
+			}
+			m.configsCachedAt = m.loadedAt(msg.cachedAt)
+			m.configsPanel.SetUpdated(m.configsCachedAt)
+			return m, m.configsPanel.SetItems(configItems(msg.configs))
+		}
 		m.activeView = configList
 		if msg.err != nil {
 			m.configsPanelError = fmt.Sprintf("Error: %v", msg.err)
 			return m, nil
 		}
 		m.configsPanelError = ""
-		var configItems []list.Item
-		for _, config := range msg.configs {
-			configItems = append(configItems, ConfigItem(config))
+		m.configsCachedAt = m.loadedAt(msg.cachedAt)
+		m.configsPanel.SetUpdated(m.configsCachedAt)
+		cmd := m.configsPanel.SetItems(configItems(msg.configs))
+		if msg.stale {
+			return m, tea.Batch(cmd, m.startAgeTicks(), m.fetchConfigsCmd(msg.ctx, msg.appId, true))
 		}
-		cmd := m.configsPanel.SetItems(configItems)
-		m.configsCache[msg.appId] = msg
-		return m, cmd
+		return m, tea.Batch(cmd, m.startAgeTicks())
 	case flagsLoader:
+		if msg.ctx.Err() != nil {
+			return m, nil
+		}
 		m.activeView = flagsTable
 		if msg.err != nil {
 			m.flagsTableError = fmt.Sprintf("Error: %v", msg.err)
 			return m, nil
 		}
+		m.flagsTableError = ""
+		m.flagsResults = nil
+		m.flagsAppId = msg.appId
+		m.flagsConfigId = msg.configId
+		m.flagsEnvIds = make(map[string]string)
+		for _, result := range msg.flags {
+			m.flagsEnvIds[result.EnvName] = result.EnvId
+		}
 		cmd := m.flagsTable.SetData(msg.flags)
-		return m, cmd
+		m.flagsTable.SetUpdated(msg.cachedAt)
+		if msg.stale {
+			// show the expired flags right away, the poll corrects them
+			m.flagsTable.SetStale(true)
+			ctx := m.startFlagsLoad()
+			return m, tea.Batch(cmd, m.startAgeTicks(), m.pollFlagsCmd(ctx))
+		}
+		return m, tea.Batch(cmd, m.startAgeTicks())
+	case flagsStream:
+		if msg.ctx.Err() != nil {
+			return m, nil
+		}
+		if msg.mode != streamLoad {
+			// the user may have moved on to other flags in the meantime
+			if msg.appId != m.flagsAppId || msg.configId != m.flagsConfigId {
+				return m, nil
+			}
+			m.flagsResults = msg.results
+			var cmd tea.Cmd
+			if msg.mode == streamRetry {
+				cmd = m.flagsTable.SetRetrying(msg.envs)
+			}
+			return m, tea.Batch(cmd, waitForFlagsResult(msg.results))
+		}
+
+		m.activeView = flagsTable
+		m.flagsTableError = ""
+		m.flagsAppId = msg.appId
+		m.flagsConfigId = msg.configId
+		m.flagsEnvIds = make(map[string]string)
+		for _, env := range msg.envs {
+			m.flagsEnvIds[*env.Name] = *env.Id
+		}
+		m.flagsResults = msg.results
+		cmd := m.flagsTable.SetPending(msg.envs)
+		return m, tea.Batch(cmd, waitForFlagsResult(msg.results))
+	case flagsResult:
+		if msg.results != m.flagsResults {
+			return m, nil
+		}
+		m.flagsTable.SetResult(msg.result)
+		return m, waitForFlagsResult(msg.results)
+	case flagsStreamDone:
+		if msg.results != m.flagsResults {
+			return m, nil
+		}
+		m.flagsResults = nil
+		m.cancelFlagsLoad()
+		// failures are not cached so the next load tries again
+		if len(m.flagsTable.FailedEnvs()) == 0 {
+			filecache.Add(&m.filecache, filecache.Flags, flagsCacheKey(m.flagsAppId, m.flagsConfigId), m.flagsTable.Results(), m.flagsTTL())
+			m.flagsTable.SetUpdated(m.now())
+			m.flagsTable.SetStale(false)
+		}
+		return m, m.startAgeTicks()
+	case ageTick:
+		switch m.activeView {
+		case appList, configList, flagsTable, flagDetail:
+			return m, ageTickCmd()
+		}
+		m.ageTicking = false
+		return m, nil
+	case watchTick:
+		if !m.watching || msg.gen != m.watchGen {
+			return m, nil
+		}
+		// skip a poll while the previous fetch is still running
+		if m.flagsResults != nil {
+			return m, watchTickCmd(msg.gen)
+		}
+		m.flagsTable.ClearChanges()
+		ctx := m.startFlagsLoad()
+		return m, tea.Batch(m.pollFlagsCmd(ctx), watchTickCmd(msg.gen))
+	case spinner.TickMsg:
+		return m, m.flagsTable.HandleMsg(msg)
+	case flagToggleRequest:
+		return m, m.setFlagCmd(msg)
+	case protectionLoader:
+		if msg.appId == m.flagsAppId && msg.configId == m.flagsConfigId {
+			m.flagDetail.SetProtected(msg.envs, m.protected.Blocked())
+			m.undoView.SetProtected(msg.envs, m.protected.Blocked())
+		}
+		return m, nil
+	case flagSetResult:
+		if msg.proposed > 0 || (msg.err != nil && m.approval.Required(msg.envName)) {
+			m.flagDetail.FinishProposal(msg.proposed, msg.err)
+		} else {
+			m.flagDetail.FinishToggle(msg.envName, msg.enabled, msg.err)
+			if msg.err == nil {
+				m.flagsTable.SetFlagState(msg.flagName, msg.envName, msg.enabled)
+			}
+		}
+		if msg.auditErr != nil {
+			m.flagDetail.Warn(auditWarning(msg.auditErr))
+		}
+		return m, nil
+	case proposalsLoader:
+		if msg.appId != m.flagsAppId || msg.configId != m.flagsConfigId || m.activeView != proposalList {
+			return m, nil
+		}
+		if msg.err != nil {
+			m.proposalsView.SetStatus(fmt.Sprintf("Error: %v", msg.err))
+			return m, m.proposalsView.SetData(nil, nil)
+		}
+		return m, m.proposalsView.SetData(msg.proposals, m.flagsEnvNames())
+	case proposalChangesLoader:
+		if m.activeView != proposalList {
+			return m, nil
+		}
+		if msg.err != nil {
+			m.proposalsView.SetStatus(fmt.Sprintf("Error: %v", msg.err))
+			return m, nil
+		}
+		m.proposalsView.Review(msg.item, msg.changes)
+		return m, nil
+	case proposalApproveRequest:
+		return m, m.approveCmd(msg.proposal)
+	case proposalApproved:
+		m.proposalsView.FinishApprove(msg.deployment, msg.err)
+		if msg.auditErr != nil {
+			m.proposalsView.Warn(auditWarning(msg.auditErr))
+		}
+		if msg.err != nil {
+			return m, nil
+		}
+		return m, m.loadProposalsCmd()
+	case auditLoader:
+		if m.activeView != auditList {
+			return m, nil
+		}
+		if msg.err != nil {
+			m.auditView.SetStatus(fmt.Sprintf("Error: %v", msg.err))
+			return m, m.auditView.SetData(nil, nil)
+		}
+		if len(msg.warnings) > 0 {
+			status := "Warning: " + msg.warnings[0]
+			if more := len(msg.warnings) - 1; more > 0 {
+				status += fmt.Sprintf(", and %d more", more)
+			}
+			m.auditView.SetStatus(status)
+		}
+		return m, m.auditView.SetData(msg.records, m.flagsEnvNames())
+	case undoLoader:
+		if msg.appId != m.flagsAppId || msg.configId != m.flagsConfigId || m.activeView != undoList {
+			return m, nil
+		}
+		return m, m.undoView.SetData(msg.items)
+	case undoRequest:
+		return m, m.undoCmd(msg)
+	case undoDone:
+		m.undoView.FinishUndo(msg.deployment, msg.err)
+		if msg.auditErr != nil {
+			m.undoView.Warn(auditWarning(msg.auditErr))
+		}
+		if msg.err != nil {
+			return m, nil
+		}
+		// the table shows the flags the redeployed version turns on or off
+		for _, change := range msg.changes {
+			for _, field := range change.Fields {
+				if enabled, ok := field.After.(bool); ok && field.Field == "enabled" {
+					m.flagsTable.SetFlagState(change.Flag, msg.envName, enabled)
+				}
+			}
+		}
+		return m, m.loadUndoCmd()
 
 	case tea.KeyMsg:
+		// the typed confirmation gets every key, "q" must not quit
+		if m.activeView == flagDetail && m.flagDetail.IsTyping() {
+			switch msg.String() {
+			case "ctrl+c", "esc":
+			default:
+				return m, m.flagDetail.HandleMsg(msg)
+			}
+		}
+		if m.activeView == undoList && m.undoView.IsTyping() {
+			switch msg.String() {
+			case "ctrl+c", "esc":
+			default:
+				return m, m.undoView.HandleMsg(msg)
+			}
+		}
+		switch msg.String() {
+		// nothing can be fetched offline
+		case "w", "r", "R", "p", "u":
+			if m.cacheConfig.Offline && m.activeView != flagDetail {
+				return m, nil
+			}
+		}
 		switch msg.String() {
 		// allow user to go back to previous view
 		case "esc":
 			switch m.activeView {
 			case configList:
+				m.cancelConfigsLoad()
 				m.activeView = appList
 			case flagsTable:
+				m.cancelFlagsLoad()
+				m.stopWatching()
 				m.activeView = configList
 			case flagDetail:
 				// If confirming, cancel and stay in detail view
@@ -139,36 +448,130 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 				m.activeView = flagsTable
 				m.selectedFlagIdx = -1
+			case proposalList:
+				if m.proposalsView.IsReviewing() {
+					m.proposalsView.CloseReview()
+					return m, nil
+				}
+				m.activeView = flagsTable
+			case undoList:
+				if m.undoView.IsReviewing() {
+					m.undoView.CloseReview()
+					return m, nil
+				}
+				m.activeView = flagsTable
+			case auditList:
+				if m.auditView.IsShowing() {
+					m.auditView.Close()
+					return m, nil
+				}
+				m.activeView = flagsTable
 			}
 			return m, nil
 		case "ctrl+c", "q":
+			m.cancel()
 			return m, tea.Quit
+		case "w":
+			// toggle watching, other views get the key
+			if m.activeView == flagsTable {
+				if m.watching {
+					m.stopWatching()
+					return m, nil
+				}
+				m.watching = true
+				m.watchGen++
+				m.flagsTable.SetWatching(true)
+				return m, watchTickCmd(m.watchGen)
+			}
+		case "r":
+			// refetch the data of the view, bypassing the caches
+			switch m.activeView {
+			case appList:
+				return m, m.fetchAppsCmd(false)
+			case configList:
+				if appId, _, ok := m.selectedIds(); ok {
+					ctx := m.startConfigsLoad()
+					return m, m.fetchConfigsCmd(ctx, appId, false)
+				}
+				return m, nil
+			case flagsTable:
+				if appId, configId, ok := m.selectedIds(); ok {
+					ctx := m.startFlagsLoad()
+					return m, m.refreshFlagsCmd(ctx, appId, configId)
+				}
+				return m, nil
+			}
+		case "p":
+			// review the changes waiting for approval
+			if m.activeView == flagsTable && m.flagsTableError == "" {
+				m.activeView = proposalList
+				m.proposalsView.SetStatus("")
+				return m, tea.Batch(m.proposalsView.SetData(nil, nil), m.loadProposalsCmd())
+			}
+		case "a":
+			// the changes made through lazyflags, read from the local log so
+			// offline too
+			if m.activeView == flagsTable && m.flagsTableError == "" {
+				m.activeView = auditList
+				m.auditView.SetStatus("")
+				return m, tea.Batch(m.auditView.SetData(nil, nil), m.loadAuditCmd())
+			}
+		case "u":
+			// undo the last deployment to an environment
+			if m.activeView == flagsTable && m.flagsTableError == "" {
+				m.activeView = undoList
+				m.undoView.SetStatus("")
+				return m, tea.Batch(m.undoView.SetData(nil), m.loadUndoCmd(), m.loadProtectionCmd())
+			}
+		case "R":
+			// retry only the environments that failed, once all have loaded
+			if m.activeView == flagsTable {
+				if envs := m.flagsTable.FailedEnvs(); len(envs) > 0 && !m.flagsTable.IsLoading() {
+					ctx := m.startFlagsLoad()
+					return m, m.flagsStreamCmd(ctx, envs, streamRetry)
+				}
+				return m, nil
+			}
 		case "enter":
 			if m.activeView == appList {
 				if item, ok := m.appsPanel.SelectedItem(); ok {
 					if app, ok := item.(AppItem); ok {
-						return m, m.loadConfigsCmd(*app.Id)
+						ctx := m.startConfigsLoad()
+						return m, m.loadConfigsCmd(ctx, *app.Id)
 					}
 				}
 			}
 
 			if m.activeView == configList {
-				if item, ok := m.configsPanel.SelectedItem(); ok {
-					if config, ok := item.(ConfigItem); ok {
-						if appItem, ok := m.appsPanel.SelectedItem(); ok {
-							if app, ok := appItem.(AppItem); ok {
-								return m, m.loadFlagsCmd(*app.Id, *config.Id)
-							}
-						}
-					}
+				if appId, configId, ok := m.selectedIds(); ok && configId != "" {
+					ctx := m.startFlagsLoad()
+					return m, m.loadFlagsCmd(ctx, appId, configId)
 				}
 			}
 
 			if m.activeView == flagsTable {
 				selectedFlag := m.flagsTable.GetActiveRow()
 				cmd := m.flagDetail.SetData(selectedFlag, m.flagsTable.EnvOrder())
+				m.flagDetail.SetPolicy(m.policyCheck())
 				m.activeView = flagDetail
-				return m, cmd
+				return m, tea.Batch(cmd, m.loadProtectionCmd())
+			}
+
+			if m.activeView == proposalList && !m.proposalsView.IsReviewing() {
+				if item, ok := m.proposalsView.SelectedProposal(); ok {
+					return m, m.loadProposalChangesCmd(item)
+				}
+				return m, nil
+			}
+
+			if m.activeView == undoList && !m.undoView.IsReviewing() {
+				m.undoView.Review()
+				return m, nil
+			}
+
+			if m.activeView == auditList {
+				m.auditView.Show()
+				return m, nil
 			}
 
 			if m.activeView == flagDetail {
@@ -197,6 +600,12 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		cmd = m.flagsTable.HandleMsg(msg)
 	case flagDetail:
 		cmd = m.flagDetail.HandleMsg(msg)
+	case proposalList:
+		cmd = m.proposalsView.HandleMsg(msg)
+	case auditList:
+		cmd = m.auditView.HandleMsg(msg)
+	case undoList:
+		cmd = m.undoView.HandleMsg(msg)
 	}
 	return m, cmd
 }
@@ -210,6 +619,10 @@ func (m Model) View() string {
 	// Add spacing from top
 	view += "\n"
 
+	if m.cacheConfig.Offline {
+		view += m.offlineBanner() + "\n"
+	}
+
 	switch m.activeView {
 	case appList:
 		if m.appsPanelError != "" {
@@ -231,6 +644,12 @@ func (m Model) View() string {
 		}
 	case flagDetail:
 		view += m.flagDetail.Render()
+	case proposalList:
+		view += m.proposalsView.Render()
+	case auditList:
+		view += m.auditView.Render()
+	case undoList:
+		view += m.undoView.Render()
 	}
 
 	return view
@@ -296,62 +715,627 @@ func (m Model) View() string {
 // 	return RenderPanel(content.String(), "Edit Flag State", 50)
 // }
 
+var offlineStyle = lipgloss.NewStyle().Bold(true).Foreground(nordfoxYellow)
+
+// offlineBanner tells when the data of the view was fetched
+func (m Model) offlineBanner() string {
+	var asOf time.Time
+	switch m.activeView {
+	case appList:
+		asOf = m.appsCachedAt
+	case configList:
+		asOf = m.configsCachedAt
+	case flagsTable, flagDetail:
+		if m.flagsTableError == "" {
+			asOf = m.flagsTable.Updated()
+		}
+	}
+	if asOf.IsZero() {
+		return offlineStyle.Render("offline — nothing cached")
+	}
+	return offlineStyle.Render("offline — data as of " + formatCacheTime(asOf))
+}
+
+// selectedIds returns the ids of the app and config selected in the panels,
+// configId is empty while no config is selected
+func (m Model) selectedIds() (appId string, configId string, ok bool) {
+	item, ok := m.appsPanel.SelectedItem()
+	if !ok {
+		return "", "", false
+	}
+	app, ok := item.(AppItem)
+	if !ok {
+		return "", "", false
+	}
+	if item, ok := m.configsPanel.SelectedItem(); ok {
+		if config, ok := item.(ConfigItem); ok {
+			configId = *config.Id
+		}
+	}
+	return *app.Id, configId, true
+}
+
+// flagsTTL returns how long the flags of the table are cached, a profile
+// override may match the names of the selected app and config
+func (m Model) flagsTTL() time.Duration {
+	var appName, configName string
+	if item, ok := m.appsPanel.SelectedItem(); ok {
+		appName = item.FilterValue()
+	}
+	if item, ok := m.configsPanel.SelectedItem(); ok {
+		configName = item.FilterValue()
+	}
+	return m.cacheConfig.ProfileTTL(m.flagsAppId, appName, m.flagsConfigId, configName)
+}
+
+// startConfigsLoad cancels the configs load in flight and returns the
+// context of the next one
+func (m *Model) startConfigsLoad() context.Context {
+	m.cancelConfigsLoad()
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.configsCancel = cancel
+	return ctx
+}
+
+func (m *Model) cancelConfigsLoad() {
+	if m.configsCancel != nil {
+		m.configsCancel()
+		m.configsCancel = nil
+	}
+}
+
+// startFlagsLoad cancels the flags load in flight, including a retry, and
+// returns the context of the next one
+func (m *Model) startFlagsLoad() context.Context {
+	m.cancelFlagsLoad()
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.flagsCancel = cancel
+	return ctx
+}
+
+func (m *Model) cancelFlagsLoad() {
+	if m.flagsCancel != nil {
+		m.flagsCancel()
+		m.flagsCancel = nil
+	}
+	m.flagsResults = nil
+}
+
 type appsLoader struct {
 	apps []appconfig.App
-	err  error
+	// when the apps were cached, zero when they were just fetched
+	cachedAt time.Time
+	// expired apps from the file cache, they're shown while refetched
+	stale bool
+	// the refetch of stale apps, it leaves them up if it fails
+	revalidate bool
+	err        error
 }
 
+// the last known apps are shown at once, even expired ones
 func (m Model) loadAppsCmd() tea.Cmd {
 	return func() tea.Msg {
-		apps, err := m.appconfigClient.ListApps(context.Background())
+		if cached, ok := filecache.Lookup(&m.filecache, filecache.Apps, ""); ok {
+			return appsLoader{apps: cached.Value, cachedAt: cached.Cached, stale: cached.Stale && !m.cacheConfig.Offline}
+		}
+		apps, err := listApps(m.ctx, &m.appconfigClient, &m.filecache, m.cacheConfig, false)
 		return appsLoader{apps: apps, err: err}
 	}
 }
 
+// fetchAppsCmd lists the apps bypassing the file cache
+func (m Model) fetchAppsCmd(revalidate bool) tea.Cmd {
+	return func() tea.Msg {
+		apps, err := listApps(m.ctx, &m.appconfigClient, &m.filecache, m.cacheConfig, true)
+		return appsLoader{apps: apps, revalidate: revalidate, err: err}
+	}
+}
+
 type configsLoader struct {
+	ctx     context.Context
 	appId   string
 	configs []appconfig.AppFlagConfig
-	err     error
+	// like appsLoader's
+	cachedAt   time.Time
+	stale      bool
+	revalidate bool
+	err        error
 }
 
-func (m Model) loadConfigsCmd(appId string) tea.Cmd {
+func (m Model) loadConfigsCmd(ctx context.Context, appId string) tea.Cmd {
 	return func() tea.Msg {
-		cachedConfig, ok := m.configsCache[appId]
-		if ok {
-			return cachedConfig
+		if cached, ok := filecache.Lookup(&m.filecache, filecache.Profiles, appId); ok {
+			return configsLoader{ctx: ctx, appId: appId, configs: cached.Value, cachedAt: cached.Cached, stale: cached.Stale && !m.cacheConfig.Offline}
 		}
+		configs, err := listProfiles(ctx, &m.appconfigClient, &m.filecache, m.cacheConfig, appId, false)
+		return configsLoader{ctx: ctx, appId: appId, configs: configs, err: err}
+	}
+}
 
-		configs, err := m.appconfigClient.ListAppFlagConfigs(context.Background(), appId)
-		result := configsLoader{appId: appId, configs: configs, err: err}
+// fetchConfigsCmd lists the configs of an app bypassing the file cache
+func (m Model) fetchConfigsCmd(ctx context.Context, appId string, revalidate bool) tea.Cmd {
+	return func() tea.Msg {
+		configs, err := listProfiles(ctx, &m.appconfigClient, &m.filecache, m.cacheConfig, appId, true)
+		return configsLoader{ctx: ctx, appId: appId, configs: configs, revalidate: revalidate, err: err}
+	}
+}
 
-		return result
+func configItems(configs []appconfig.AppFlagConfig) []list.Item {
+	var items []list.Item
+	for _, config := range configs {
+		items = append(items, ConfigItem(config))
 	}
+	return items
 }
 
 type flagsLoader struct {
-	flags []appconfig.Result
+	ctx      context.Context
+	appId    string
+	configId string
+	flags    []appconfig.Result
+	cachedAt time.Time
+	// expired flags shown in stale-while-revalidate mode
+	stale bool
 	err   error
 }
 
 // fetch all feature flags for all environments for a given app + config
 // this request includes a request that costs $$$ so results are cached to file
-func (m Model) loadFlagsCmd(appId string, configId string) tea.Cmd {
+// without a cached copy the results are streamed in one environment at a time
+func (m Model) loadFlagsCmd(ctx context.Context, appId string, configId string) tea.Cmd {
+	return func() tea.Msg {
+		cached, ok := filecache.Lookup(&m.filecache, filecache.Flags, flagsCacheKey(appId, configId))
+		if ok && (!cached.Stale || m.cacheConfig.StaleWhileRevalidate || m.cacheConfig.Offline) {
+			return flagsLoader{ctx: ctx, appId: appId, configId: configId, flags: cached.Value, cachedAt: cached.Cached, stale: cached.Stale && !m.cacheConfig.Offline}
+		}
+		if m.cacheConfig.Offline {
+			return flagsLoader{ctx: ctx, err: fmt.Errorf("%s: %w", filecache.Flags, errNotCached)}
+		}
+
+		envs, err := listEnvironments(ctx, &m.appconfigClient, &m.filecache, m.cacheConfig, appId, false)
+		if err != nil {
+			return flagsLoader{ctx: ctx, err: fmt.Errorf("failed to list app environments: %w", err)}
+		}
+		return flagsStream{
+			ctx:      ctx,
+			appId:    appId,
+			configId: configId,
+			envs:     envs,
+			results:  m.appconfigClient.StreamFlags(ctx, appId, configId, envs),
+		}
+	}
+}
+
+// refetch the flags of a table and its environments, the cached copies are
+// dropped so a later load doesn't show older flags, and so are the data
+// sessions, which return the flags of their last poll until the next
+func (m Model) refreshFlagsCmd(ctx context.Context, appId string, configId string) tea.Cmd {
+	load := m.loadFlagsCmd(ctx, appId, configId)
+	return func() tea.Msg {
+		filecache.Delete(&m.filecache, filecache.Flags, flagsCacheKey(appId, configId))
+		filecache.Delete(&m.filecache, filecache.Environments, appId)
+		m.appconfigClient.ForgetSessions(appId, configId)
+		return load()
+	}
+}
+
+// refetch the flags of some of the table's environments, bypassing the
+// file cache
+func (m Model) flagsStreamCmd(ctx context.Context, envs []appconfig.AppEnvironments, mode streamMode) tea.Cmd {
+	appId, configId := m.flagsAppId, m.flagsConfigId
 	return func() tea.Msg {
-		cacheKey := fmt.Sprintf("%s:%s", appId, configId)
-		cached, ok := m.filecache.Get(cacheKey)
-		if ok {
-			return flagsLoader{
-				flags: cached,
-				err:   nil,
+		return flagsStream{
+			ctx:      ctx,
+			appId:    appId,
+			configId: configId,
+			envs:     envs,
+			results:  m.appconfigClient.StreamFlags(ctx, appId, configId, envs),
+			mode:     mode,
+		}
+	}
+}
+
+// poll every environment of the table. Sessions are polled with their next
+// token and not before AppConfig's poll interval, so polls cost little.
+func (m Model) pollFlagsCmd(ctx context.Context) tea.Cmd {
+	return m.flagsStreamCmd(ctx, m.flagsTable.Envs(), streamPoll)
+}
+
+// watchInterval is how often a watched table is polled, 0 leaves the
+// polling to whoever sends watchTick
+var watchInterval = 15 * time.Second
+
+type watchTick struct {
+	gen int
+}
+
+func watchTickCmd(gen int) tea.Cmd {
+	if watchInterval == 0 {
+		return nil
+	}
+	return tea.Tick(watchInterval, func(time.Time) tea.Msg {
+		return watchTick{gen: gen}
+	})
+}
+
+func (m *Model) stopWatching() {
+	m.watching = false
+	m.flagsTable.SetWatching(false)
+}
+
+// ageInterval is how often the age of the flags shown is re-rendered, 0
+// turns it off
+var ageInterval = time.Second
+
+type ageTick struct{}
+
+// loadedAt is when a list was cached, now when cachedAt is zero because it
+// was just fetched
+func (m Model) loadedAt(cachedAt time.Time) time.Time {
+	if cachedAt.IsZero() {
+		return m.now()
+	}
+	return cachedAt
+}
+
+func ageTickCmd() tea.Cmd {
+	return tea.Tick(ageInterval, func(time.Time) tea.Msg {
+		return ageTick{}
+	})
+}
+
+// startAgeTicks starts re-rendering the age of the data shown, unless it
+// already is
+func (m *Model) startAgeTicks() tea.Cmd {
+	if m.ageTicking || ageInterval == 0 {
+		return nil
+	}
+	m.ageTicking = true
+	return ageTickCmd()
+}
+
+type streamMode int
+
+const (
+	// fills a new table
+	streamLoad streamMode = iota
+	// refetches environments that failed to load
+	streamRetry
+	// updates the cells of a watched table in place
+	streamPoll
+)
+
+// flagsStream starts a load, one flagsResult per environment follows.
+// Retries and polls update the table that is already shown.
+type flagsStream struct {
+	ctx      context.Context
+	appId    string
+	configId string
+	envs     []appconfig.AppEnvironments
+	results  <-chan appconfig.Result
+	mode     streamMode
+}
+
+type flagsResult struct {
+	results <-chan appconfig.Result
+	result  appconfig.Result
+}
+
+type flagsStreamDone struct {
+	results <-chan appconfig.Result
+}
+
+func waitForFlagsResult(results <-chan appconfig.Result) tea.Cmd {
+	return func() tea.Msg {
+		result, ok := <-results
+		if !ok {
+			return flagsStreamDone{results: results}
+		}
+		return flagsResult{results: results, result: result}
+	}
+}
+
+type flagSetResult struct {
+	flagName string
+	envName  string
+	enabled  bool
+	// version proposed instead of deployed, the environment needs approval
+	proposed int32
+	err      error
+	// the change was made but the audit log couldn't record it
+	auditErr error
+}
+
+// deploys a toggle confirmed in the flag detail view. The cached flags are
+// dropped so the next load shows the deployed state.
+func (m Model) setFlagCmd(req flagToggleRequest) tea.Cmd {
+	appId, configId := m.flagsAppId, m.flagsConfigId
+	envId := m.flagsEnvIds[req.envName]
+
+	if m.approval.Required(req.envName) {
+		return func() tea.Msg {
+			proposal, err := m.appconfigClient.ProposeFlag(m.ctx, appId, configId, envId, req.flagName, req.enabled)
+			return flagSetResult{
+				flagName: req.flagName,
+				envName:  req.envName,
+				enabled:  req.enabled,
+				proposed: proposal.Version,
+				err:      err,
+				auditErr: m.audit.Failure(),
 			}
 		}
+	}
 
-		flags, err := m.appconfigClient.GetFlags(context.Background(), appId, configId)
-		result := flagsLoader{
-			flags: flags,
-			err:   err,
+	return func() tea.Msg {
+		// not tied to the view, leaving it must not abort a half done change
+		_, err := m.appconfigClient.SetFlag(m.ctx, appId, configId, envId, req.flagName, req.enabled, m.strategy)
+		if err == nil {
+			filecache.Delete(&m.filecache, filecache.Flags, flagsCacheKey(appId, configId))
+		}
+		return flagSetResult{
+			flagName: req.flagName,
+			envName:  req.envName,
+			enabled:  req.enabled,
+			err:      err,
+			auditErr: m.audit.Failure(),
 		}
-		m.filecache.Add(cacheKey, flags)
-		return result
 	}
 }
+
+type proposalsLoader struct {
+	appId     string
+	configId  string
+	proposals []appconfig.Proposal
+	err       error
+}
+
+func (m Model) loadProposalsCmd() tea.Cmd {
+	appId, configId := m.flagsAppId, m.flagsConfigId
+	return func() tea.Msg {
+		proposals, err := m.appconfigClient.ListProposals(m.ctx, appId, configId)
+		return proposalsLoader{appId: appId, configId: configId, proposals: proposals, err: err}
+	}
+}
+
+type proposalChangesLoader struct {
+	item    ProposalItem
+	changes appconfig.ChangeSet
+	err     error
+}
+
+func (m Model) loadProposalChangesCmd(item ProposalItem) tea.Cmd {
+	appId, configId := m.flagsAppId, m.flagsConfigId
+	return func() tea.Msg {
+		changes, err := m.appconfigClient.ProposalChanges(m.ctx, appId, configId, item.proposal)
+		return proposalChangesLoader{item: item, changes: changes, err: err}
+	}
+}
+
+type proposalApproved struct {
+	deployment appconfig.Deployment
+	err        error
+	auditErr   error
+}
+
+// approves and deploys a reviewed proposal, the client refuses the user's
+// own proposals
+func (m Model) approveCmd(proposal appconfig.Proposal) tea.Cmd {
+	appId, configId := m.flagsAppId, m.flagsConfigId
+	return func() tea.Msg {
+		_, deployment, err := m.appconfigClient.Approve(m.ctx, appId, configId, proposal.Version, m.strategy)
+		if err == nil {
+			filecache.Delete(&m.filecache, filecache.Flags, flagsCacheKey(appId, configId))
+		}
+		return proposalApproved{deployment: deployment, err: err, auditErr: m.audit.Failure()}
+	}
+}
+
+type undoLoader struct {
+	appId    string
+	configId string
+	items    []UndoItem
+}
+
+// loadUndoCmd plans the undo of the last deployment to each environment of
+// the flags shown
+func (m Model) loadUndoCmd() tea.Cmd {
+	appId, configId := m.flagsAppId, m.flagsConfigId
+	envOrder := m.flagsTable.EnvOrder()
+	envIds := maps.Clone(m.flagsEnvIds)
+	return func() tea.Msg {
+		items := make([]UndoItem, 0, len(envOrder))
+		for _, envName := range envOrder {
+			plan, err := m.appconfigClient.PlanUndo(m.ctx, appId, configId, envIds[envName])
+			items = append(items, UndoItem{envName: envName, plan: plan, err: err})
+		}
+		return undoLoader{appId: appId, configId: configId, items: items}
+	}
+}
+
+type undoDone struct {
+	envName    string
+	changes    appconfig.ChangeSet
+	deployment appconfig.Deployment
+	err        error
+	auditErr   error
+}
+
+// undoCmd stops the deployment reviewed if it's still rolling out and
+// redeploys the version before it
+func (m Model) undoCmd(req undoRequest) tea.Cmd {
+	appId, configId := m.flagsAppId, m.flagsConfigId
+	return func() tea.Msg {
+		deployment, err := m.appconfigClient.Undo(m.ctx, appId, configId, req.plan, m.strategy)
+		if err == nil {
+			filecache.Delete(&m.filecache, filecache.Flags, flagsCacheKey(appId, configId))
+		}
+		return undoDone{envName: req.envName, changes: req.plan.Changes, deployment: deployment, err: err, auditErr: m.audit.Failure()}
+	}
+}
+
+// auditWarning is the status of a change the audit log couldn't record,
+// writing to stderr would scribble over the TUI
+func auditWarning(err error) string {
+	return fmt.Sprintf("Warning: not written to the audit log: %v", err)
+}
+
+type auditLoader struct {
+	records []audit.Record
+	// lines of the log skipped
+	warnings []string
+	err      error
+}
+
+// loadAuditCmd reads the records of the flags shown from the audit log
+func (m Model) loadAuditCmd() tea.Cmd {
+	filter := audit.Filter{App: m.flagsAppId, Profile: m.flagsConfigId}
+	return func() tea.Msg {
+		records, warnings, err := m.audit.Read()
+		return auditLoader{records: audit.Select(records, filter), warnings: warnings, err: err}
+	}
+}
+
+// flagsEnvNames maps the environment ids of the flags shown to their names
+func (m Model) flagsEnvNames() map[string]string {
+	names := make(map[string]string, len(m.flagsEnvIds))
+	for name, id := range m.flagsEnvIds {
+		names[id] = name
+	}
+	return names
+}
+
+type protectionLoader struct {
+	appId    string
+	configId string
+	envs     map[string]bool
+}
+
+// loadProtectionCmd finds the protected environments of the flags shown.
+// Until their tags are known every environment counts as protected when
+// tags protect any.
+func (m Model) loadProtectionCmd() tea.Cmd {
+	appId, configId := m.flagsAppId, m.flagsConfigId
+	envIds := maps.Clone(m.flagsEnvIds)
+
+	envs := make(map[string]bool, len(envIds))
+	for envName := range envIds {
+		envs[envName] = m.protected.MatchName(envName) || len(m.protected.Tags) > 0
+	}
+	m.flagDetail.SetProtected(envs, m.protected.Blocked())
+	m.undoView.SetProtected(envs, m.protected.Blocked())
+	// tags can't be read offline, nothing can be changed anyway
+	if len(m.protected.Tags) == 0 || m.cacheConfig.Offline {
+		return nil
+	}
+
+	return func() tea.Msg {
+		return protectionLoader{
+			appId:    appId,
+			configId: configId,
+			envs:     protectedEnvs(m.ctx, &m.appconfigClient, m.protected, appId, envIds),
+		}
+	}
+}
+
+// policyCheck checks a toggle of the flag detail against the policy rules,
+// comparing with the flags shown
+func (m Model) policyCheck() func(envName string, enabled bool) []string {
+	if m.rules.Empty() {
+		return nil
+	}
+	flagName := m.flagsTable.GetActiveRow().FlagName
+	enabled := enabledFlags(m.flagsTable.Results())
+
+	return func(envName string, on bool) []string {
+		violations := m.rules.Check(policy.Proposal{
+			Env:     envName,
+			Changes: toggleChange(flagName, enabled[envName][flagName], on),
+			Enabled: enabled,
+			Time:    m.now(),
+		})
+		messages := make([]string, len(violations))
+		for i, v := range violations {
+			messages[i] = v.String()
+		}
+		return messages
+	}
+}
+
+func flagsCacheKey(appId string, configId string) string {
+	return fmt.Sprintf("%s:%s", appId, configId)
+}
+
+// errNotCached is returned offline for what was never fetched
+var errNotCached = errors.New("not cached while offline")
+
+// offlineValue returns the cached value of kind and key whatever its age
+func offlineValue[T any](cache *filecache.Cache, kind filecache.Kind[T], key string) (T, error) {
+	cached, ok := filecache.Lookup(cache, kind, key)
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("%s: %w", kind, errNotCached)
+	}
+	return cached.Value, nil
+}
+
+// shared by the TUI and the CLI so both benefit from the same file cache
+func getFlags(ctx context.Context, client *appconfig.Client, cache *filecache.Cache, cacheConfig settings.Cache, appId string, configId string, ttl time.Duration) ([]appconfig.Result, error) {
+	cacheKey := flagsCacheKey(appId, configId)
+	if cacheConfig.Offline {
+		return offlineValue(cache, filecache.Flags, cacheKey)
+	}
+	if cached, ok := filecache.Get(cache, filecache.Flags, cacheKey); ok {
+		return cached, nil
+	}
+
+	flags, err := client.GetFlags(ctx, appId, configId)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range flags {
+		// failures are not cached so the next call tries again
+		if result.Err != nil {
+			return flags, nil
+		}
+	}
+	filecache.Add(cache, filecache.Flags, cacheKey, flags, ttl)
+	return flags, nil
+}
+
+// cachedList returns the value of kind and key from the file cache unless
+// it expired, otherwise it's fetched and cached for ttl. fresh skips the
+// cached value, offline it's all there is.
+func cachedList[T any](cache *filecache.Cache, cacheConfig settings.Cache, kind filecache.Kind[T], key string, ttl time.Duration, fresh bool, fetch func() (T, error)) (T, error) {
+	if cacheConfig.Offline {
+		return offlineValue(cache, kind, key)
+	}
+	if !fresh {
+		if cached, ok := filecache.Get(cache, kind, key); ok {
+			return cached, nil
+		}
+	}
+	value, err := fetch()
+	if err != nil {
+		return value, err
+	}
+	filecache.Add(cache, kind, key, value, ttl)
+	return value, nil
+}
+
+func listApps(ctx context.Context, client *appconfig.Client, cache *filecache.Cache, cacheConfig settings.Cache, fresh bool) ([]appconfig.App, error) {
+	return cachedList(cache, cacheConfig, filecache.Apps, "", cacheConfig.AppsTTL, fresh, func() ([]appconfig.App, error) {
+		return client.ListApps(ctx)
+	})
+}
+
+func listProfiles(ctx context.Context, client *appconfig.Client, cache *filecache.Cache, cacheConfig settings.Cache, appId string, fresh bool) ([]appconfig.AppFlagConfig, error) {
+	return cachedList(cache, cacheConfig, filecache.Profiles, appId, cacheConfig.ProfilesTTL, fresh, func() ([]appconfig.AppFlagConfig, error) {
+		return client.ListAppFlagConfigs(ctx, appId)
+	})
+}
+
+func listEnvironments(ctx context.Context, client *appconfig.Client, cache *filecache.Cache, cacheConfig settings.Cache, appId string, fresh bool) ([]appconfig.AppEnvironments, error) {
+	return cachedList(cache, cacheConfig, filecache.Environments, appId, cacheConfig.EnvironmentsTTL, fresh, func() ([]appconfig.AppEnvironments, error) {
+		return client.ListAppEnvironments(ctx, appId)
+	})
+}
@@ -0,0 +1,112 @@
+package app_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/simonschwartz/app-config-lazy-flags/cmd"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig/fake"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/audit"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/filecache"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/policy"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+)
+
+// the identity of the user driving the TUI in tests
+const tuiCaller = "local/tester"
+
+func newFakeModel(t *testing.T, clock *testClock, file settings.File, seed func(*filecache.Cache), faults ...fake.Fault) (tea.Model, *fake.Backend, *audit.Log) {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	backend := fake.NewBackend(fake.DemoData())
+	backend.RollbackDuration = 200 * time.Millisecond
+	for _, f := range faults {
+		backend.AddFault(f)
+	}
+	cache, err := filecache.New(
+		filecache.WithIdentity(filecache.Identity{Account: "local", Region: "us-east-1"}),
+		filecache.WithClock(clock.Now),
+	)
+	if err != nil {
+		t.Fatalf("filecache: %v", err)
+	}
+	if seed != nil {
+		seed(cache)
+	}
+	auditLog := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	rules, err := policy.New(file.Policy)
+	if err != nil {
+		t.Fatalf("policy: %v", err)
+	}
+	client := appconfig.NewWithClients(backend, backend, appconfig.WithClock(clock.Now), appconfig.WithAccount("local"), appconfig.WithCaller(tuiCaller), appconfig.WithAudit(auditLog.Record), appconfig.WithAuditClock(clock.Now), appconfig.WithCheck(app.PolicyCheck(rules, clock.Now)))
+	return app.WithClock(app.NewModel(client, cache, app.ModelOptions{Cache: file.Cache, Protected: file.Protected, Rules: rules, Approval: file.Approval, Deploy: file.Deploy, Audit: auditLog}), clock.Now), backend, auditLog
+}
+
+func TestModelLoaders(t *testing.T) {
+	d := newTUI(t)
+
+	if view := d.model.View(); !strings.Contains(view, "Wordle") {
+		t.Fatalf("expected the apps panel to list Wordle:\n%s", view)
+	}
+
+	d.press("enter")
+	if view := d.model.View(); !strings.Contains(view, "WebFeatureFlags") || !strings.Contains(view, "APIFeatureFlags") {
+		t.Fatalf("expected the configs panel to list both profiles:\n%s", view)
+	}
+
+	d.press("enter")
+	view := d.model.View()
+	for _, expected := range []string{"development", "staging", "production", "beta_feature", "dark_mode", "new_checkout"} {
+		if !strings.Contains(view, expected) {
+			t.Errorf("expected the flags table to contain %q:\n%s", expected, view)
+		}
+	}
+	if calls := d.backend.Calls("GetLatestConfiguration"); calls != 3 {
+		t.Errorf("expected one GetLatestConfiguration call per environment, got %d", calls)
+	}
+}
+
+func TestModelLoaderErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		fault    fake.Fault
+		steps    int
+		expected string
+	}{
+		{
+			name:     "should show an error when apps can't be listed",
+			fault:    fake.Fault{Operation: "ListApplications", Err: fake.ErrAccessDenied},
+			expected: "Error: failed to list applications",
+		},
+		{
+			name:     "should show an error when configs can't be listed",
+			fault:    fake.Fault{Operation: "ListConfigurationProfiles", Err: fake.ErrThrottling},
+			steps:    1,
+			expected: "Error: failed to list application configs",
+		},
+		{
+			name:     "should show an error when environments can't be listed",
+			fault:    fake.Fault{Operation: "ListEnvironments", Err: fake.ErrInternal},
+			steps:    2,
+			expected: "Error: failed to list app environments",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTUI(t, tt.fault)
+			for i := 0; i < tt.steps; i++ {
+				d.press("enter")
+			}
+
+			if view := d.model.View(); !strings.Contains(view, tt.expected) {
+				t.Errorf("expected the view to contain %q:\n%s", tt.expected, view)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package app
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// output formats accepted by --output
+const (
+	outputText     = "text"
+	outputJSON     = "json"
+	outputYAML     = "yaml"
+	outputCSV      = "csv"
+	outputMarkdown = "markdown"
+)
+
+var outputFormats = []string{outputText, outputJSON, outputYAML, outputCSV, outputMarkdown}
+
+func validateOutputFormat(format string) error {
+	for _, f := range outputFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return usageErrorf("unknown output format %q, expected one of: %s", format, strings.Join(outputFormats, ", "))
+}
+
+// FlagMatrix is the JSON and YAML representation of FlagsTableData.
+// The shape is part of the CLI contract, keep it backwards compatible:
+//
+//	{
+//	  "environments": ["development", "production"],
+//	  "flags": [
+//	    {"name": "dark_mode", "states": {"development": "on", "production": "off"}}
+//	  ]
+//	}
+//
+// Environments are in AppConfig's listing order, flags are sorted by name
+// and every flag has a state for every environment: "on", "off", "-"
+// when the flag is not defined in that environment, or "error" when the
+// environment's flags could not be fetched.
+type FlagMatrix struct {
+	Environments []string        `json:"environments" yaml:"environments"`
+	Flags        []FlagMatrixRow `json:"flags" yaml:"flags"`
+}
+
+type FlagMatrixRow struct {
+	Name   string            `json:"name" yaml:"name"`
+	States map[string]string `json:"states" yaml:"states"`
+}
+
+func (d FlagsTableData) ToMatrix() FlagMatrix {
+	matrix := FlagMatrix{
+		Environments: append([]string{}, d.EnvOrder...),
+		Flags:        make([]FlagMatrixRow, 0, len(d.Flags)),
+	}
+	for _, flag := range d.Flags {
+		states := make(map[string]string, len(d.EnvOrder))
+		for _, envName := range d.EnvOrder {
+			states[envName] = flag.GetEnvState(envName)
+		}
+		matrix.Flags = append(matrix.Flags, FlagMatrixRow{Name: flag.FlagName, States: states})
+	}
+	return matrix
+}
+
+// WriteFlagsTable renders the flags × environments matrix in one of the
+// output formats.
+func WriteFlagsTable(w io.Writer, data FlagsTableData, format string) error {
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(data.ToMatrix())
+	case outputYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(data.ToMatrix()); err != nil {
+			return err
+		}
+		return enc.Close()
+	case outputCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(append([]string{"flag"}, data.EnvOrder...))
+		for _, row := range data.ToTableRows() {
+			cw.Write(row)
+		}
+		cw.Flush()
+		return cw.Error()
+	case outputMarkdown:
+		return writeMarkdownTable(w, data)
+	case outputText:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "FLAG\t%s\n", strings.Join(data.EnvOrder, "\t"))
+		for _, row := range data.ToTableRows() {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+	return validateOutputFormat(format)
+}
+
+// renders a GitHub flavoured markdown table, ready to paste into release
+// notes and pull requests
+func writeMarkdownTable(w io.Writer, data FlagsTableData) error {
+	escape := strings.NewReplacer("|", `\|`, "\n", " ")
+
+	header := []string{"Flag"}
+	for _, envName := range data.EnvOrder {
+		header = append(header, escape.Replace(envName))
+	}
+
+	var b strings.Builder
+	b.WriteString("| " + strings.Join(header, " | ") + " |\n")
+	b.WriteString("|" + strings.Repeat(" --- |", len(header)) + "\n")
+	for _, row := range data.ToTableRows() {
+		cells := make([]string, 0, len(row))
+		for i, cell := range row {
+			if i == 0 {
+				cell = "`" + cell + "`"
+			}
+			cells = append(cells, escape.Replace(cell))
+		}
+		b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,131 @@
+package app_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/simonschwartz/app-config-lazy-flags/cmd"
+)
+
+func TestWriteFlagsTable(t *testing.T) {
+	data := app.FlagsTableData{
+		EnvOrder: []string{"development", "production"},
+		Flags: []app.FlagRowData{
+			{
+				FlagName:  "beta_feature",
+				EnvStates: map[string]string{"development": "on", "production": "-"},
+			},
+			{
+				FlagName:  "dark_mode",
+				EnvStates: map[string]string{"development": "on", "production": "off"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		format   string
+		expected string
+	}{
+		{
+			name:   "should render text",
+			format: "text",
+			expected: strings.Join([]string{
+				"FLAG          development  production",
+				"beta_feature  on           -",
+				"dark_mode     on           off",
+				"",
+			}, "\n"),
+		},
+		{
+			name:   "should render json",
+			format: "json",
+			expected: strings.Join([]string{
+				`{`,
+				`  "environments": [`,
+				`    "development",`,
+				`    "production"`,
+				`  ],`,
+				`  "flags": [`,
+				`    {`,
+				`      "name": "beta_feature",`,
+				`      "states": {`,
+				`        "development": "on",`,
+				`        "production": "-"`,
+				`      }`,
+				`    },`,
+				`    {`,
+				`      "name": "dark_mode",`,
+				`      "states": {`,
+				`        "development": "on",`,
+				`        "production": "off"`,
+				`      }`,
+				`    }`,
+				`  ]`,
+				`}`,
+				``,
+			}, "\n"),
+		},
+		{
+			name:   "should render yaml",
+			format: "yaml",
+			expected: strings.Join([]string{
+				`environments:`,
+				`  - development`,
+				`  - production`,
+				`flags:`,
+				`  - name: beta_feature`,
+				`    states:`,
+				`      development: "on"`,
+				`      production: '-'`,
+				`  - name: dark_mode`,
+				`    states:`,
+				`      development: "on"`,
+				`      production: "off"`,
+				``,
+			}, "\n"),
+		},
+		{
+			name:   "should render csv",
+			format: "csv",
+			expected: strings.Join([]string{
+				"flag,development,production",
+				"beta_feature,on,-",
+				"dark_mode,on,off",
+				"",
+			}, "\n"),
+		},
+		{
+			name:   "should render markdown",
+			format: "markdown",
+			expected: strings.Join([]string{
+				"| Flag | development | production |",
+				"| --- | --- | --- |",
+				"| `beta_feature` | on | - |",
+				"| `dark_mode` | on | off |",
+				"",
+			}, "\n"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := app.WriteFlagsTable(&buf, data, tt.format); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if buf.String() != tt.expected {
+				t.Errorf("result: \n%v, expected \n%v", buf.String(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestWriteFlagsTableUnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	if err := app.WriteFlagsTable(&buf, app.FlagsTableData{}, "xml"); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
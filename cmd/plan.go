@@ -0,0 +1,263 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/filecache"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/policy"
+)
+
+// DesiredState describes the flag values each environment should have.
+// Environments that are not listed are left alone, flags missing from a
+// listed environment are deleted from it. Flags without a definition keep
+// the one deployed.
+//
+//	application: Wordle
+//	profile: WebFeatureFlags
+//	flags:                    # optional definitions, replace the deployed ones
+//	  dark_mode:
+//	    name: Dark mode
+//	environments:
+//	  development:
+//	    dark_mode: true       # shorthand for {enabled: true}
+//	  production:
+//	    dark_mode:
+//	      enabled: false
+type DesiredState struct {
+	Application  string                              `json:"application" yaml:"application"`
+	Profile      string                              `json:"profile" yaml:"profile"`
+	Flags        map[string]appconfig.FlagDefinition `json:"flags,omitempty" yaml:"flags,omitempty"`
+	Environments map[string]map[string]any           `json:"environments" yaml:"environments"`
+}
+
+// values returns the desired values of an environment, expanding the
+// boolean shorthand.
+func (s DesiredState) values(envName string) (map[string]appconfig.FlagValue, error) {
+	values := make(map[string]appconfig.FlagValue, len(s.Environments[envName]))
+	for name, v := range s.Environments[envName] {
+		switch v := v.(type) {
+		case bool:
+			values[name] = appconfig.FlagValue{"enabled": v}
+		case map[string]any:
+			values[name] = appconfig.FlagValue(v)
+		default:
+			return nil, fmt.Errorf("environments.%s.%s: expected true, false or an object of flag values", envName, name)
+		}
+	}
+	return values, nil
+}
+
+type envPlan struct {
+	env         appconfig.AppEnvironments
+	baseVersion int32
+	base        *appconfig.FlagDocument
+	target      *appconfig.FlagDocument
+	changes     appconfig.ChangeSet
+}
+
+// withDefaultValues gives every defined flag without a value the value off,
+// so a desired false isn't a change
+func withDefaultValues(doc *appconfig.FlagDocument) *appconfig.FlagDocument {
+	doc = doc.Clone()
+	for name := range doc.Flags {
+		if _, ok := doc.Values[name]; !ok {
+			doc.Values[name] = appconfig.FlagValue{"enabled": false}
+		}
+	}
+	return doc
+}
+
+// targetDocument applies the desired definitions and values to the
+// deployed document, keeping the definitions of flags the file doesn't
+// define.
+func (s DesiredState) targetDocument(base *appconfig.FlagDocument, desired map[string]appconfig.FlagValue) *appconfig.FlagDocument {
+	target := base.Clone()
+	for name := range target.Flags {
+		if _, ok := desired[name]; !ok {
+			delete(target.Flags, name)
+			delete(target.Values, name)
+		}
+	}
+	for name, value := range desired {
+		if def, ok := s.Flags[name]; ok {
+			target.Flags[name] = def
+		} else if _, ok := target.Flags[name]; !ok {
+			target.Flags[name] = appconfig.FlagDefinition{Name: name}
+		}
+		target.Values[name] = value
+	}
+	return target
+}
+
+func (c *cli) loadPlan(ctx context.Context, file string) (appconfig.App, appconfig.AppFlagConfig, []envPlan, error) {
+	var state DesiredState
+	if err := unmarshalFile(file, &state); err != nil {
+		return appconfig.App{}, appconfig.AppFlagConfig{}, nil, err
+	}
+	if state.Application == "" || state.Profile == "" {
+		return appconfig.App{}, appconfig.AppFlagConfig{}, nil, usageErrorf("%s: application and profile are required", file)
+	}
+
+	app, err := c.resolveApp(ctx, state.Application)
+	if err != nil {
+		return appconfig.App{}, appconfig.AppFlagConfig{}, nil, err
+	}
+	profile, err := c.resolveProfile(ctx, *app.Id, state.Profile)
+	if err != nil {
+		return appconfig.App{}, appconfig.AppFlagConfig{}, nil, err
+	}
+
+	envNames := make([]string, 0, len(state.Environments))
+	for name := range state.Environments {
+		envNames = append(envNames, name)
+	}
+	sort.Strings(envNames)
+
+	var plans []envPlan
+	for _, envName := range envNames {
+		desired, err := state.values(envName)
+		if err != nil {
+			return app, profile, nil, err
+		}
+
+		env, err := c.resolveEnv(ctx, *app.Id, envName)
+		if err != nil {
+			return app, profile, nil, err
+		}
+
+		plan := envPlan{env: env, base: &appconfig.FlagDocument{
+			Version: "1",
+			Flags:   map[string]appconfig.FlagDefinition{},
+			Values:  map[string]appconfig.FlagValue{},
+		}}
+		deployed, err := c.client.DeployedVersion(ctx, *app.Id, *profile.Id, *env.Id)
+		switch {
+		case errors.Is(err, appconfig.ErrNoDeployment):
+		case err != nil:
+			return app, profile, nil, err
+		default:
+			plan.baseVersion = deployed.Version
+			if plan.base, err = c.client.GetFlagDocument(ctx, *app.Id, *profile.Id, deployed.Version); err != nil {
+				return app, profile, nil, err
+			}
+		}
+
+		plan.target = state.targetDocument(plan.base, desired)
+		if err := plan.target.Validate(); err != nil {
+			return app, profile, nil, fmt.Errorf("%s: desired state for %s is invalid:\n%w", file, envName, err)
+		}
+		plan.changes = appconfig.DiffDocuments(withDefaultValues(plan.base), plan.target)
+		plans = append(plans, plan)
+	}
+
+	return app, profile, plans, nil
+}
+
+func (c *cli) writePlan(plans []envPlan) (changed int) {
+	var created, updated, deleted int
+	for _, plan := range plans {
+		if len(plan.changes) == 0 {
+			continue
+		}
+		changed++
+		fmt.Fprintf(c.out, "%s (deployed version %d):\n", *plan.env.Name, plan.baseVersion)
+		plan.changes.Write(c.out, "  ")
+		fmt.Fprintln(c.out)
+
+		cr, up, del := plan.changes.Counts()
+		created, updated, deleted = created+cr, updated+up, deleted+del
+	}
+
+	if changed == 0 {
+		fmt.Fprintln(c.out, "No changes. Deployed flags match the desired state.")
+		return 0
+	}
+	fmt.Fprintf(c.out, "Plan: %d to create, %d to update, %d to delete across %d environment(s).\n", created, updated, deleted, changed)
+	return changed
+}
+
+func (c *cli) plan(ctx context.Context, args []string) error {
+	fs := newFlagSet("plan")
+	file := fs.String("file", "", "desired state file")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "file"); err != nil {
+		return err
+	}
+
+	_, _, plans, err := c.loadPlan(ctx, *file)
+	if err != nil {
+		return err
+	}
+	c.writePlan(plans)
+	return nil
+}
+
+func (c *cli) apply(ctx context.Context, args []string) error {
+	fs := newFlagSet("apply")
+	file := fs.String("file", "", "desired state file")
+	strategy := fs.String("strategy", c.strategy, "deployment strategy id")
+	confirm := fs.String("confirm", "", "names of the protected environments changed, comma separated")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "file"); err != nil {
+		return err
+	}
+
+	app, profile, plans, err := c.loadPlan(ctx, *file)
+	if err != nil {
+		return err
+	}
+	if c.writePlan(plans) == 0 {
+		return nil
+	}
+	fmt.Fprintln(c.out)
+
+	// nothing is applied when any environment is refused
+	var proposals []policy.Proposal
+	for _, plan := range plans {
+		if len(plan.changes) == 0 {
+			continue
+		}
+		if err := c.checkProtected(ctx, *app.Id, *plan.env.Id, *plan.env.Name, *confirm); err != nil {
+			return err
+		}
+		proposals = append(proposals, policy.Proposal{Env: *plan.env.Name, Changes: plan.changes})
+	}
+	if err := c.checkPolicy(ctx, *app.Id, *profile.Id, proposals...); err != nil {
+		return err
+	}
+
+	// environments deployed before a failure serve new flags too
+	defer filecache.Delete(c.cache, filecache.Flags, flagsCacheKey(*app.Id, *profile.Id))
+	for _, plan := range plans {
+		if len(plan.changes) == 0 {
+			continue
+		}
+
+		if c.approval.Required(*plan.env.Name) {
+			proposal, err := c.client.Propose(ctx, *app.Id, *profile.Id, *plan.env.Id, plan.target, "apply "+filepath.Base(*file))
+			if err != nil {
+				return fmt.Errorf("%s: %w", *plan.env.Name, err)
+			}
+			fmt.Fprintf(c.out, "%s: proposed version %d, waiting for another user to approve it\n", *plan.env.Name, proposal.Version)
+			continue
+		}
+
+		description := fmt.Sprintf("lazyflags: apply %s to %s", filepath.Base(*file), *plan.env.Name)
+		deployment, err := c.client.DeployDocument(ctx, *app.Id, *profile.Id, *plan.env.Id, plan.target, description, *strategy)
+		if err != nil {
+			return fmt.Errorf("%s: %w", *plan.env.Name, err)
+		}
+		fmt.Fprintf(c.out, "%s: created version %d, started deployment %d (%s)\n",
+			*plan.env.Name, deployment.Version, deployment.Number, deployment.State)
+	}
+	return nil
+}
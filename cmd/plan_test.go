@@ -0,0 +1,294 @@
+package app_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig/fake"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+)
+
+// the flags of WebFeatureFlags deployed to development
+const deployedDevelopment = `
+application: Wordle
+profile: WebFeatureFlags
+environments:
+  development:
+    beta_feature: true
+    dark_mode: true
+    new_checkout: false
+`
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		name     string
+		desired  string
+		expected string
+	}{
+		{
+			name:     "should plan nothing when the desired state is deployed",
+			desired:  deployedDevelopment,
+			expected: "No changes. Deployed flags match the desired state.\n",
+		},
+		{
+			name: "should plan a change of a definition only",
+			desired: deployedDevelopment + `
+flags:
+  dark_mode:
+    name: Dark mode
+`,
+			expected: strings.Join([]string{
+				"development (deployed version 1):",
+				"  ~ dark_mode",
+				`      name: "dark_mode" -> "Dark mode"`,
+				"",
+				"Plan: 0 to create, 1 to update, 0 to delete across 1 environment(s).",
+				"",
+			}, "\n"),
+		},
+		{
+			name: "should plan values, creations and deletions",
+			desired: `
+application: Wordle
+profile: WebFeatureFlags
+environments:
+  staging:
+    beta_feature: true
+    dark_mode: true
+    search: {enabled: false}
+`,
+			expected: strings.Join([]string{
+				"staging (deployed version 2):",
+				"  ~ beta_feature",
+				"      enabled: off -> on",
+				"  - new_checkout",
+				"      enabled: on",
+				"  + search",
+				"      enabled: off",
+				"",
+				"Plan: 1 to create, 1 to update, 1 to delete across 1 environment(s).",
+				"",
+			}, "\n"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCLI(t, settings.Default())
+			file := c.writeFile("desired.yaml", tt.desired)
+
+			code, stdout, stderr := c.run("plan", "--file", file)
+			if code != 0 {
+				t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+			}
+			if stdout != tt.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tt.expected, stdout)
+			}
+			if calls := c.backend.Calls("CreateHostedConfigurationVersion"); calls != 0 {
+				t.Errorf("expected plan to create nothing, got %d versions", calls)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	badRequest := &fake.APIError{Code: "BadRequestException", Message: "invalid deployment", Status: http.StatusBadRequest}
+
+	tests := []struct {
+		name     string
+		settings func(*settings.File)
+		desired  string
+		args     []string
+		faults   []fake.Fault
+		// exit code, and what stdout and stderr contain
+		expectedCode   int
+		expectedOut    string
+		expectedErr    string
+		expectedDeploy int
+		// flags on in each environment afterwards, by environment id
+		expectedOn map[string][]string
+		// definition names afterwards, by environment id then flag
+		expectedNames map[string]map[string]string
+	}{
+		{
+			name:        "should do nothing when the desired state is deployed",
+			desired:     deployedDevelopment,
+			expectedOut: "No changes.",
+			expectedOn:  map[string][]string{"dev0001": {"beta_feature", "dark_mode"}},
+		},
+		{
+			name: "should deploy a change of a definition only",
+			desired: deployedDevelopment + `
+flags:
+  dark_mode:
+    name: Dark mode
+`,
+			expectedOut:    "development: created version 4, started deployment 3",
+			expectedDeploy: 1,
+			expectedOn:     map[string][]string{"dev0001": {"beta_feature", "dark_mode"}},
+			expectedNames:  map[string]map[string]string{"dev0001": {"dark_mode": "Dark mode", "beta_feature": "beta_feature"}},
+		},
+		{
+			name: "should refuse a protected environment without --confirm",
+			desired: `
+application: Wordle
+profile: WebFeatureFlags
+environments:
+  production:
+    beta_feature: true
+    dark_mode: false
+    new_checkout: false
+`,
+			expectedCode: 1,
+			expectedErr:  `environment "production" is protected, confirm the change with --confirm production`,
+			expectedOn:   map[string][]string{"pro0001": nil},
+		},
+		{
+			name: "should deploy a protected environment confirmed",
+			desired: `
+application: Wordle
+profile: WebFeatureFlags
+environments:
+  production:
+    beta_feature: true
+    dark_mode: false
+    new_checkout: false
+`,
+			args:           []string{"--confirm", "production"},
+			expectedOut:    "production: created version 4, started deployment 3",
+			expectedDeploy: 1,
+			expectedOn:     map[string][]string{"pro0001": {"beta_feature"}},
+		},
+		{
+			name:     "should propose where approval is required",
+			settings: func(f *settings.File) { f.Approval.Environments = []string{"staging"} },
+			desired: `
+application: Wordle
+profile: WebFeatureFlags
+environments:
+  staging:
+    beta_feature: true
+    dark_mode: true
+    new_checkout: true
+`,
+			expectedOut: "staging: proposed version 4, waiting for another user to approve it",
+			expectedOn:  map[string][]string{"sta0001": {"dark_mode", "new_checkout"}},
+		},
+		{
+			name: "should stop at a failure of the second environment",
+			desired: `
+application: Wordle
+profile: WebFeatureFlags
+environments:
+  development:
+    beta_feature: true
+    dark_mode: true
+    new_checkout: true
+  staging:
+    beta_feature: true
+    dark_mode: true
+    new_checkout: true
+`,
+			faults:         []fake.Fault{{Operation: "StartDeployment", Environment: "staging", Err: badRequest}},
+			expectedCode:   1,
+			expectedOut:    "development: created version 4, started deployment 3",
+			expectedErr:    "error: staging: ",
+			expectedDeploy: 1,
+			expectedOn: map[string][]string{
+				"dev0001": {"beta_feature", "dark_mode", "new_checkout"},
+				"sta0001": {"dark_mode", "new_checkout"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := settings.Default()
+			file.Protected.Tags = map[string]string{"Protected": "true"}
+			if tt.settings != nil {
+				tt.settings(&file)
+			}
+			c := newCLI(t, file, tt.faults...)
+			path := c.writeFile("desired.yaml", tt.desired)
+
+			code, stdout, stderr := c.run(append([]string{"apply", "--file", path}, tt.args...)...)
+			if code != tt.expectedCode {
+				t.Fatalf("expected exit code %d, got %d:\n%s%s", tt.expectedCode, code, stdout, stderr)
+			}
+			if !strings.Contains(stdout, tt.expectedOut) {
+				t.Errorf("expected stdout to contain %q:\n%s", tt.expectedOut, stdout)
+			}
+			if !strings.Contains(stderr, tt.expectedErr) {
+				t.Errorf("expected stderr to contain %q:\n%s", tt.expectedErr, stderr)
+			}
+			// failed deployments count as calls
+			deployments := c.backend.Calls("StartDeployment")
+			if tt.faults != nil {
+				deployments--
+			}
+			if deployments != tt.expectedDeploy {
+				t.Errorf("expected %d deployments, got %d", tt.expectedDeploy, deployments)
+			}
+
+			for envId, flags := range tt.expectedOn {
+				on := map[string]bool{}
+				for _, name := range flags {
+					on[name] = true
+				}
+				for name, value := range c.deployedDocument(envId).Values {
+					if value.Enabled() != on[name] {
+						t.Errorf("%s: expected %s to be on: %v", envId, name, on[name])
+					}
+				}
+			}
+			for envId, names := range tt.expectedNames {
+				doc := c.deployedDocument(envId)
+				for flag, name := range names {
+					if doc.Flags[flag].Name != name {
+						t.Errorf("%s: expected %s to be named %q, got %q", envId, flag, name, doc.Flags[flag].Name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestApplyClearsCacheOnFailure(t *testing.T) {
+	badRequest := &fake.APIError{Code: "BadRequestException", Message: "invalid deployment", Status: http.StatusBadRequest}
+	c := newCLI(t, settings.Default(), fake.Fault{Operation: "StartDeployment", Environment: "staging", Err: badRequest})
+
+	get := func() string {
+		t.Helper()
+		code, stdout, stderr := c.run("flags", "get", "--app", "Wordle", "--profile", "WebFeatureFlags", "--output", "csv")
+		if code != 0 {
+			t.Fatalf("flags get: exit code %d: %s", code, stderr)
+		}
+		return stdout
+	}
+	// cached for a minute
+	if before := get(); !strings.Contains(before, "new_checkout,off,on,off") {
+		t.Fatalf("unexpected flags:\n%s", before)
+	}
+
+	path := c.writeFile("desired.yaml", `
+application: Wordle
+profile: WebFeatureFlags
+environments:
+  development:
+    beta_feature: true
+    dark_mode: true
+    new_checkout: true
+  staging:
+    beta_feature: true
+    dark_mode: true
+    new_checkout: true
+`)
+	if code, _, _ := c.run("apply", "--file", path); code != 1 {
+		t.Fatalf("expected apply to fail, got exit code %d", code)
+	}
+
+	if after := get(); !strings.Contains(after, "new_checkout,on,on,off") {
+		t.Errorf("expected the development deployment instead of the cached flags:\n%s", after)
+	}
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/policy"
+)
+
+// enabledFlags returns the flags on in each environment, keyed by
+// environment then flag
+func enabledFlags(results []appconfig.Result) map[string]map[string]bool {
+	enabled := make(map[string]map[string]bool, len(results))
+	for _, result := range results {
+		enabled[result.EnvName] = make(map[string]bool, len(result.Flags))
+		for name, flag := range result.Flags {
+			enabled[result.EnvName][name] = flag.Enabled
+		}
+	}
+	return enabled
+}
+
+// toggleChange is the change set of turning a single flag on or off, none
+// when it already is
+func toggleChange(flagName string, before, after bool) appconfig.ChangeSet {
+	if before == after {
+		return nil
+	}
+	return appconfig.ChangeSet{{
+		Flag:   flagName,
+		Kind:   appconfig.ChangeUpdate,
+		Fields: []appconfig.FieldChange{{Field: "enabled", Before: before, After: after}},
+	}}
+}
+
+// deployedFlags returns the flags on in the version deployed to each
+// environment right now, keyed by environment name then flag, and the
+// environment names by id
+func deployedFlags(ctx context.Context, client *appconfig.Client, appId string, profileId string) (map[string]map[string]bool, map[string]string, error) {
+	envs, err := client.ListAppEnvironments(ctx, appId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	enabled := make(map[string]map[string]bool, len(envs))
+	names := make(map[string]string, len(envs))
+	// environments often run the same version
+	docs := map[int32]*appconfig.FlagDocument{}
+	for _, env := range envs {
+		name := aws.ToString(env.Name)
+		names[aws.ToString(env.Id)] = name
+		enabled[name] = map[string]bool{}
+
+		deployed, err := client.DeployedVersion(ctx, appId, profileId, aws.ToString(env.Id))
+		if errors.Is(err, appconfig.ErrNoDeployment) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		doc, ok := docs[deployed.Version]
+		if !ok {
+			if doc, err = client.GetFlagDocument(ctx, appId, profileId, deployed.Version); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", name, err)
+			}
+			docs[deployed.Version] = doc
+		}
+		for flagName, value := range doc.Values {
+			enabled[name][flagName] = value.Enabled()
+		}
+	}
+	return enabled, names, nil
+}
+
+// policyCheck refuses every change of the client that breaks a rule of the
+// settings file, see appconfig.WithCheck. Rules compare with what is
+// deployed right before the change, not with the cache. nil without rules.
+func policyCheck(rules *policy.Engine, now func() time.Time) func(context.Context, *appconfig.Client, appconfig.Change) error {
+	if rules.Empty() {
+		return nil
+	}
+	return func(ctx context.Context, client *appconfig.Client, change appconfig.Change) error {
+		enabled, names, err := deployedFlags(ctx, client, change.AppId, change.ConfigId)
+		if err != nil {
+			return fmt.Errorf("failed to check the policy: %w", err)
+		}
+		env := names[change.EnvId]
+		if env == "" {
+			env = change.EnvId
+		}
+		violations := rules.Check(policy.Proposal{Env: env, Changes: change.Changes, Enabled: enabled, Time: now()})
+		if env == "" {
+			env = "new version"
+		}
+		return policy.Error(env, violations)
+	}
+}
+
+// checkPolicy refuses the proposals if any breaks a rule of the settings
+// file, so a change of several environments is refused before any is
+// made. The client checks each change again as it's made.
+func (c *cli) checkPolicy(ctx context.Context, appId string, profileId string, proposals ...policy.Proposal) error {
+	if c.rules.Empty() {
+		return nil
+	}
+
+	enabled, _, err := deployedFlags(ctx, c.client, appId, profileId)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	var errs []error
+	for _, p := range proposals {
+		p.Enabled, p.Time = enabled, now
+		errs = append(errs, policy.Error(p.Env, c.rules.Check(p)))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,247 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// ProfileFile is the on-disk form of a feature flag configuration profile,
+// written by `profiles export` and read by `profiles import`.
+type ProfileFile struct {
+	Application string `json:"application" yaml:"application"`
+	Profile     string `json:"profile" yaml:"profile"`
+	// hosted configuration version the document was exported from
+	Version int32 `json:"version" yaml:"version"`
+	// deployed version per environment name, informational only on import
+	Environments map[string]int32       `json:"environments,omitempty" yaml:"environments,omitempty"`
+	Document     appconfig.FlagDocument `json:"document" yaml:"document"`
+}
+
+// file format is picked from the extension, anything but .yaml/.yml is JSON
+func isYAMLFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+func marshalFile(v any, format string) ([]byte, error) {
+	if format == outputYAML {
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), enc.Close()
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// unmarshalFile decodes JSON or YAML into v. YAML is converted to JSON first
+// so flag values get the same types no matter which format they came from.
+func unmarshalFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if isYAMLFile(path) {
+		var raw any
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if data, err = json.Marshal(raw); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *cli) profilesExport(ctx context.Context, args []string) error {
+	fs := newFlagSet("profiles export")
+	appRef := fs.String("app", "", "application name or id")
+	profileRef := fs.String("profile", "", "configuration profile name or id")
+	file := fs.String("file", "", "write to this file instead of stdout")
+	format := fs.String("format", "", "json or yaml, defaults to the file extension")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "app", "profile"); err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = outputJSON
+		if isYAMLFile(*file) {
+			*format = outputYAML
+		}
+	}
+	if *format != outputJSON && *format != outputYAML {
+		return usageErrorf("profiles export: --format must be json or yaml")
+	}
+
+	app, err := c.resolveApp(ctx, *appRef)
+	if err != nil {
+		return err
+	}
+	profile, err := c.resolveProfile(ctx, *app.Id, *profileRef)
+	if err != nil {
+		return err
+	}
+
+	latest, err := c.client.LatestVersion(ctx, *app.Id, *profile.Id)
+	if err != nil {
+		return err
+	}
+	if latest == 0 {
+		return fmt.Errorf("profile %q has no hosted configuration versions", *profile.Name)
+	}
+	doc, err := c.client.GetFlagDocument(ctx, *app.Id, *profile.Id, latest)
+	if err != nil {
+		return err
+	}
+
+	envs, err := c.client.ListAppEnvironments(ctx, *app.Id)
+	if err != nil {
+		return err
+	}
+	deployed := make(map[string]int32, len(envs))
+	for _, env := range envs {
+		deployment, err := c.client.DeployedVersion(ctx, *app.Id, *profile.Id, *env.Id)
+		if errors.Is(err, appconfig.ErrNoDeployment) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		deployed[*env.Name] = deployment.Version
+	}
+
+	data, err := marshalFile(ProfileFile{
+		Application:  *app.Name,
+		Profile:      *profile.Name,
+		Version:      latest,
+		Environments: deployed,
+		Document:     *doc,
+	}, *format)
+	if err != nil {
+		return err
+	}
+
+	if *file == "" {
+		_, err = c.out.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*file, data, 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Exported version %d of %s to %s\n", latest, *profile.Name, *file)
+	return nil
+}
+
+func (c *cli) profilesImport(ctx context.Context, args []string) error {
+	fs := newFlagSet("profiles import")
+	file := fs.String("file", "", "file written by profiles export")
+	appRef := fs.String("app", "", "application name or id, defaults to the one in the file")
+	profileRef := fs.String("profile", "", "configuration profile name or id, defaults to the one in the file")
+	dryRun := fs.Bool("dry-run", false, "print the change set without creating a version")
+	description := fs.String("description", "", "description of the new version")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "file"); err != nil {
+		return err
+	}
+
+	var pf ProfileFile
+	if err := unmarshalFile(*file, &pf); err != nil {
+		return err
+	}
+	if *appRef == "" {
+		*appRef = pf.Application
+	}
+	if *profileRef == "" {
+		*profileRef = pf.Profile
+	}
+	if *appRef == "" || *profileRef == "" {
+		return usageErrorf("profiles import: --app and --profile are required when the file does not name them")
+	}
+	if appconfig.IsReservedDescription(*description) {
+		return usageErrorf("profiles import: --description: %v", appconfig.ErrReservedDescription)
+	}
+
+	doc := &pf.Document
+	if doc.Flags == nil {
+		doc.Flags = map[string]appconfig.FlagDefinition{}
+	}
+	if doc.Values == nil {
+		doc.Values = map[string]appconfig.FlagValue{}
+	}
+	if err := doc.Validate(); err != nil {
+		return fmt.Errorf("%s is not a valid feature flag document:\n%w", *file, err)
+	}
+
+	app, err := c.resolveApp(ctx, *appRef)
+	if err != nil {
+		return err
+	}
+	profile, err := c.resolveProfile(ctx, *app.Id, *profileRef)
+	if err != nil {
+		return err
+	}
+
+	latest, err := c.client.LatestVersion(ctx, *app.Id, *profile.Id)
+	if err != nil {
+		return err
+	}
+	var current *appconfig.FlagDocument
+	if latest > 0 {
+		if current, err = c.client.GetFlagDocument(ctx, *app.Id, *profile.Id, latest); err != nil {
+			return err
+		}
+	}
+
+	changes := appconfig.DiffDocuments(current, doc)
+	if len(changes) == 0 {
+		fmt.Fprintf(c.out, "No changes, %s matches version %d.\n", *profile.Name, latest)
+		return nil
+	}
+
+	fmt.Fprintf(c.out, "Changes against version %d of %s:\n\n", latest, *profile.Name)
+	changes.Write(c.out, "  ")
+	created, updated, deleted := changes.Counts()
+	fmt.Fprintf(c.out, "\n%d to create, %d to update, %d to delete.\n", created, updated, deleted)
+
+	if *dryRun {
+		return nil
+	}
+
+	if *description == "" {
+		*description = fmt.Sprintf("lazyflags: import %s", filepath.Base(*file))
+	}
+	version, err := c.client.CreateFlagVersion(ctx, *app.Id, *profile.Id, doc, *description, latest)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "Created version %d. It is not deployed yet, use `deploy --version %d` to roll it out.\n", version, version)
+	return nil
+}
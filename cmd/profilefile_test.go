@@ -0,0 +1,181 @@
+package app_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+)
+
+func TestProfilesExportImport(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{name: "should round-trip YAML", file: "flags.yaml"},
+		{name: "should round-trip JSON", file: "flags.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCLI(t, settings.Default())
+			path := filepath.Join(c.dir, tt.file)
+
+			code, stdout, stderr := c.run("profiles", "export", "--app", "Wordle", "--profile", "WebFeatureFlags", "--file", path)
+			if code != 0 {
+				t.Fatalf("export: exit code %d: %s", code, stderr)
+			}
+			if expected := "Exported version 3 of WebFeatureFlags to " + path + "\n"; stdout != expected {
+				t.Errorf("expected %q, got %q", expected, stdout)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode := info.Mode().Perm(); mode != 0600 {
+				t.Errorf("expected the export to be only readable by its owner, got %v", mode)
+			}
+
+			code, stdout, stderr = c.run("profiles", "import", "--file", path)
+			if code != 0 {
+				t.Fatalf("import: exit code %d: %s", code, stderr)
+			}
+			if expected := "No changes, WebFeatureFlags matches version 3.\n"; stdout != expected {
+				t.Errorf("expected %q, got %q", expected, stdout)
+			}
+			if calls := c.backend.Calls("CreateHostedConfigurationVersion"); calls != 0 {
+				t.Errorf("expected an unchanged import to create nothing, got %d versions", calls)
+			}
+		})
+	}
+}
+
+func TestProfilesImport(t *testing.T) {
+	c := newCLI(t, settings.Default())
+	path := filepath.Join(c.dir, "flags.yaml")
+	if code, _, stderr := c.run("profiles", "export", "--app", "Wordle", "--profile", "WebFeatureFlags", "--file", path); code != 0 {
+		t.Fatalf("export: exit code %d: %s", code, stderr)
+	}
+
+	// turn dark_mode on in the exported document
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := strings.Replace(string(data), "dark_mode:\n      enabled: false", "dark_mode:\n      enabled: true", 1)
+	if edited == string(data) {
+		t.Fatalf("dark_mode not found in the export:\n%s", data)
+	}
+	path = c.writeFile("edited.yaml", edited)
+
+	expected := strings.Join([]string{
+		"Changes against version 3 of WebFeatureFlags:",
+		"",
+		"  ~ dark_mode",
+		"      enabled: off -> on",
+		"",
+		"0 to create, 1 to update, 0 to delete.",
+		"",
+	}, "\n")
+	code, stdout, stderr := c.run("profiles", "import", "--file", path, "--dry-run")
+	if code != 0 {
+		t.Fatalf("dry run: exit code %d: %s", code, stderr)
+	}
+	if stdout != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, stdout)
+	}
+	if calls := c.backend.Calls("CreateHostedConfigurationVersion"); calls != 0 {
+		t.Fatalf("expected the dry run to create nothing, got %d versions", calls)
+	}
+
+	code, stdout, stderr = c.run("profiles", "import", "--file", path)
+	if code != 0 {
+		t.Fatalf("import: exit code %d: %s", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, expected) || !strings.Contains(stdout, "Created version 4.") {
+		t.Errorf("expected the change set and version 4, got:\n%s", stdout)
+	}
+	client := appconfig.NewWithClients(c.backend, c.backend)
+	doc, err := client.GetFlagDocument(context.Background(), "wordle1", "webflg1", 4)
+	if err != nil {
+		t.Fatalf("GetFlagDocument: %v", err)
+	}
+	if !doc.Values["dark_mode"].Enabled() || doc.Values["beta_feature"].Enabled() {
+		t.Errorf("expected version 4 to only turn dark_mode on, got %v", doc.Values)
+	}
+	// an import creates a version, deploying it is up to deploy
+	if calls := c.backend.Calls("StartDeployment"); calls != 0 {
+		t.Errorf("expected the import not to deploy, got %d deployments", calls)
+	}
+}
+
+func TestProfilesImportInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		args    []string
+		// exit code and what stderr contains
+		expectedCode int
+		expectedErr  string
+	}{
+		{
+			name:         "should refuse unknown fields",
+			file:         "flags.yaml",
+			content:      "application: Wordle\nprofile: WebFeatureFlags\nbogus: 1\n",
+			expectedCode: 1,
+			expectedErr:  `json: unknown field "bogus"`,
+		},
+		{
+			name:         "should refuse a file cut short",
+			file:         "flags.json",
+			content:      `{"application": "Wordle"`,
+			expectedCode: 1,
+			expectedErr:  "flags.json: unexpected EOF",
+		},
+		{
+			name:         "should refuse an invalid document",
+			file:         "flags.yaml",
+			content:      "application: Wordle\nprofile: WebFeatureFlags\ndocument:\n  version: \"1\"\n  values:\n    search: {enabled: true}\n",
+			expectedCode: 1,
+			expectedErr:  "is not a valid feature flag document:\nflag \"search\": has a value but no definition",
+		},
+		{
+			name:         "should refuse an invalid document on a dry run",
+			file:         "flags.yaml",
+			content:      "application: Wordle\nprofile: WebFeatureFlags\ndocument:\n  version: \"2\"\n",
+			args:         []string{"--dry-run"},
+			expectedCode: 1,
+			expectedErr:  `unsupported document version "2"`,
+		},
+		{
+			name:         "should require the profile when the file doesn't name it",
+			file:         "flags.yaml",
+			content:      "application: Wordle\ndocument:\n  version: \"1\"\n",
+			expectedCode: 2,
+			expectedErr:  "--app and --profile are required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCLI(t, settings.Default())
+			path := c.writeFile(tt.file, tt.content)
+
+			code, _, stderr := c.run(append([]string{"profiles", "import", "--file", path}, tt.args...)...)
+			if code != tt.expectedCode {
+				t.Errorf("expected exit code %d, got %d: %s", tt.expectedCode, code, stderr)
+			}
+			if !strings.Contains(stderr, tt.expectedErr) {
+				t.Errorf("expected stderr to contain %q, got %q", tt.expectedErr, stderr)
+			}
+			if calls := c.backend.Calls("CreateHostedConfigurationVersion"); calls != 0 {
+				t.Errorf("expected nothing to be created, got %d versions", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/filecache"
+)
+
+// errNeedsApproval refuses direct deployments to environments whose changes
+// another user approves
+var errNeedsApproval = errors.New("needs approval, propose the change with flags set or apply")
+
+// writeProposed tells how the proposal is approved
+func (c *cli) writeProposed(app appconfig.App, profile appconfig.AppFlagConfig, env appconfig.AppEnvironments, proposal appconfig.Proposal) {
+	fmt.Fprintf(c.out, "Proposed version %d for %s, another user approves it with:\n", proposal.Version, *env.Name)
+	fmt.Fprintf(c.out, "  lazyflags proposals approve --app %s --profile %s --version %d\n", *app.Name, *profile.Name, proposal.Version)
+}
+
+func (c *cli) proposalsList(ctx context.Context, args []string) error {
+	fs := newFlagSet("proposals list")
+	appRef := fs.String("app", "", "application name or id")
+	profileRef := fs.String("profile", "", "configuration profile name or id")
+	all := fs.Bool("all", false, "include deployed and stale proposals")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "app", "profile"); err != nil {
+		return err
+	}
+
+	app, err := c.resolveApp(ctx, *appRef)
+	if err != nil {
+		return err
+	}
+	profile, err := c.resolveProfile(ctx, *app.Id, *profileRef)
+	if err != nil {
+		return err
+	}
+	proposals, err := c.client.ListProposals(ctx, *app.Id, *profile.Id)
+	if err != nil {
+		return err
+	}
+	envNames, err := c.envNames(ctx, *app.Id)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tENV\tSTATE\tPROPOSED BY\tSUMMARY")
+	for _, p := range proposals {
+		if !*all && p.State != appconfig.ProposalPending {
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", p.Version, envNames[p.EnvId], p.State, p.ProposedBy, p.Summary)
+	}
+	return w.Flush()
+}
+
+func (c *cli) proposalsShow(ctx context.Context, args []string) error {
+	fs := newFlagSet("proposals show")
+	appRef := fs.String("app", "", "application name or id")
+	profileRef := fs.String("profile", "", "configuration profile name or id")
+	version := fs.Int("version", 0, "version number of the proposal")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "app", "profile", "version"); err != nil {
+		return err
+	}
+
+	app, err := c.resolveApp(ctx, *appRef)
+	if err != nil {
+		return err
+	}
+	profile, err := c.resolveProfile(ctx, *app.Id, *profileRef)
+	if err != nil {
+		return err
+	}
+	proposal, err := c.client.GetProposal(ctx, *app.Id, *profile.Id, int32(*version))
+	if err != nil {
+		return err
+	}
+	changes, err := c.client.ProposalChanges(ctx, *app.Id, *profile.Id, proposal)
+	if err != nil {
+		return err
+	}
+	envNames, err := c.envNames(ctx, *app.Id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "Version %d for %s (%s), proposed by %s on version %d:\n",
+		proposal.Version, envNames[proposal.EnvId], proposal.State, proposal.ProposedBy, proposal.BaseVersion)
+	changes.Write(c.out, "  ")
+	return nil
+}
+
+func (c *cli) proposalsApprove(ctx context.Context, args []string) error {
+	fs := newFlagSet("proposals approve")
+	appRef := fs.String("app", "", "application name or id")
+	profileRef := fs.String("profile", "", "configuration profile name or id")
+	version := fs.Int("version", 0, "version number of the proposal")
+	strategy := fs.String("strategy", c.strategy, "deployment strategy id")
+	confirm := fs.String("confirm", "", "name of the environment, required when it is protected")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "app", "profile", "version"); err != nil {
+		return err
+	}
+
+	app, err := c.resolveApp(ctx, *appRef)
+	if err != nil {
+		return err
+	}
+	profile, err := c.resolveProfile(ctx, *app.Id, *profileRef)
+	if err != nil {
+		return err
+	}
+	proposal, err := c.client.GetProposal(ctx, *app.Id, *profile.Id, int32(*version))
+	if err != nil {
+		return err
+	}
+	env, err := c.resolveEnv(ctx, *app.Id, proposal.EnvId)
+	if err != nil {
+		return err
+	}
+	if err := c.checkProtected(ctx, *app.Id, *env.Id, *env.Name, *confirm); err != nil {
+		return err
+	}
+
+	proposal, deployment, err := c.client.Approve(ctx, *app.Id, *profile.Id, proposal.Version, *strategy)
+	if err != nil {
+		return err
+	}
+	filecache.Delete(c.cache, filecache.Flags, flagsCacheKey(*app.Id, *profile.Id))
+
+	fmt.Fprintf(c.out, "Approved version %d proposed by %s, started deployment %d to %s (%s)\n",
+		proposal.Version, proposal.ProposedBy, deployment.Number, *env.Name, deployment.State)
+	return nil
+}
+
+// envNames maps the environment ids of an application to their names
+func (c *cli) envNames(ctx context.Context, appId string) (map[string]string, error) {
+	envs, err := listEnvironments(ctx, c.client, c.cache, c.cacheConfig, appId, false)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(envs))
+	for _, env := range envs {
+		names[*env.Id] = *env.Name
+	}
+	return names, nil
+}
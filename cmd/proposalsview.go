@@ -0,0 +1,214 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+)
+
+// ProposalItem is a change waiting for approval
+type ProposalItem struct {
+	proposal appconfig.Proposal
+	envName  string
+}
+
+func (i ProposalItem) FilterValue() string {
+	return fmt.Sprintf("v%d  %-12s %-28s %s", i.proposal.Version, i.envName, i.proposal.Summary, i.proposal.ProposedBy)
+}
+
+// proposalApproveRequest is sent when the reviewer approves a proposal. The
+// Model deploys it and reports back through FinishApprove.
+type proposalApproveRequest struct {
+	proposal appconfig.Proposal
+}
+
+// ProposalsView lists the pending proposals of a configuration profile and
+// reviews one at a time.
+//
+// CLI output of a review looks like this:
+//
+// ┌─ Review version 5 ───────────────────────────────────────────────────────┐
+// │                                                                          │
+// │  production, proposed by arn:aws:sts::123456789012:assumed-role/dev/ann  │
+// │                                                                          │
+// │  ~ dark_mode                                                             │
+// │      enabled: off -> on                                                  │
+// │                                                                          │
+// │   Approve and deploy     Cancel                                          │
+// │                                                                          │
+// └──────────────────────────────────────────────────────────────────────────┘
+type ProposalsView struct {
+	list  *ListPanel
+	width int
+
+	// proposal under review, the list shows while reviewing is false
+	reviewing bool
+	item      ProposalItem
+	changes   appconfig.ChangeSet
+	btnIdx    int // 0 = Approve, 1 = Cancel
+	approving bool
+	// outcome of the last approval or why the review couldn't load
+	status string
+}
+
+func NewProposalsView(height int, width int) *ProposalsView {
+	return &ProposalsView{
+		list:  NewListPanel(height, width, "Proposals waiting for approval", []list.Item{}),
+		width: width,
+	}
+}
+
+// SetData replaces the proposals listed, only pending ones are shown
+func (v *ProposalsView) SetData(proposals []appconfig.Proposal, envNames map[string]string) tea.Cmd {
+	var items []list.Item
+	for _, p := range proposals {
+		if p.State == appconfig.ProposalPending {
+			items = append(items, ProposalItem{proposal: p, envName: envNames[p.EnvId]})
+		}
+	}
+	v.reviewing = false
+	return v.list.SetItems(items)
+}
+
+// SelectedProposal returns the proposal highlighted in the list
+func (v *ProposalsView) SelectedProposal() (ProposalItem, bool) {
+	item, ok := v.list.SelectedItem()
+	if !ok {
+		return ProposalItem{}, false
+	}
+	proposal, ok := item.(ProposalItem)
+	return proposal, ok
+}
+
+// Review shows what a proposal changes, Cancel is selected for safety
+func (v *ProposalsView) Review(item ProposalItem, changes appconfig.ChangeSet) {
+	v.reviewing = true
+	v.item = item
+	v.changes = changes
+	v.btnIdx = 1
+	v.status = ""
+}
+
+// SetStatus shows a message under the list or the review
+func (v *ProposalsView) SetStatus(status string) {
+	v.status = status
+}
+
+// Warn adds a warning under the status, e.g. a change the audit log
+// couldn't record
+func (v *ProposalsView) Warn(warning string) {
+	if v.status != "" {
+		v.status += "\n"
+	}
+	v.status += warning
+}
+
+func (v *ProposalsView) IsReviewing() bool {
+	return v.reviewing
+}
+
+func (v *ProposalsView) CloseReview() {
+	v.reviewing = false
+	v.status = ""
+}
+
+// FinishApprove ends an approval started from the review
+func (v *ProposalsView) FinishApprove(deployment appconfig.Deployment, err error) {
+	v.approving = false
+	if err != nil {
+		v.status = fmt.Sprintf("Error: %v", err)
+		return
+	}
+	v.reviewing = false
+	v.status = fmt.Sprintf("Approved version %d, started deployment %d to %s", v.item.proposal.Version, deployment.Number, v.item.envName)
+}
+
+func (v *ProposalsView) HandleMsg(msg tea.Msg) tea.Cmd {
+	if v.approving {
+		return nil
+	}
+	if !v.reviewing {
+		return v.list.HandleMsg(msg)
+	}
+
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return nil
+	}
+	switch keyMsg.String() {
+	case "left", "h":
+		v.btnIdx = 0
+	case "right", "l":
+		v.btnIdx = 1
+	case "enter":
+		if v.btnIdx == 1 {
+			v.CloseReview()
+			return nil
+		}
+		v.approving = true
+		v.status = ""
+		request := proposalApproveRequest{proposal: v.item.proposal}
+		return func() tea.Msg { return request }
+	}
+	return nil
+}
+
+func (v *ProposalsView) Render() string {
+	if v.reviewing {
+		return v.renderReview()
+	}
+	if len(v.list.model.Items()) == 0 {
+		msg := "Nothing is waiting for approval"
+		if v.status != "" {
+			msg = v.status
+		}
+		return v.list.RenderError(msg)
+	}
+	if v.status != "" {
+		return RenderPanel(v.list.model.View()+"\n"+v.status, v.list.title, v.width)
+	}
+	return v.list.Render()
+}
+
+func (v *ProposalsView) renderReview() string {
+	selectedStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(lipgloss.Color("#000")).
+		Background(nordfoxBlue).
+		Padding(0, 1)
+	normalStyle := lipgloss.NewStyle().
+		Padding(0, 1)
+
+	approveBtn := "Approve and deploy"
+	cancelBtn := "Cancel"
+	if v.btnIdx == 0 {
+		approveBtn = selectedStyle.Render(approveBtn)
+		cancelBtn = normalStyle.Render(cancelBtn)
+	} else {
+		approveBtn = normalStyle.Render(approveBtn)
+		cancelBtn = selectedStyle.Render(cancelBtn)
+	}
+
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("%s, proposed by %s\n\n", v.item.envName, v.item.proposal.ProposedBy))
+	if len(v.changes) == 0 {
+		content.WriteString("No changes\n")
+	} else {
+		content.WriteString(v.changes.String())
+	}
+	content.WriteString("\n")
+	if v.approving {
+		content.WriteString("Deploying...")
+	} else {
+		content.WriteString(approveBtn + "     " + cancelBtn)
+	}
+	if v.status != "" {
+		content.WriteString("\n\n" + v.status)
+	}
+
+	return RenderPanel(content.String(), fmt.Sprintf("Review version %d", v.item.proposal.Version), v.width)
+}
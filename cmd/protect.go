@@ -0,0 +1,50 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+)
+
+// isProtected reports whether changes to an environment need a typed
+// confirmation. An environment whose tags can't be read is protected.
+func isProtected(ctx context.Context, client *appconfig.Client, protected settings.Protected, appId string, envId string, envName string) bool {
+	if protected.MatchName(envName) {
+		return true
+	}
+	if len(protected.Tags) == 0 {
+		return false
+	}
+	tags, err := client.EnvironmentTags(ctx, appId, envId)
+	return err != nil || protected.MatchTags(tags)
+}
+
+// protectedEnvs returns which of the environments, keyed by name, are
+// protected
+func protectedEnvs(ctx context.Context, client *appconfig.Client, protected settings.Protected, appId string, envIds map[string]string) map[string]bool {
+	envs := make(map[string]bool, len(envIds))
+	for envName, envId := range envIds {
+		envs[envName] = isProtected(ctx, client, protected, appId, envId, envName)
+	}
+	return envs
+}
+
+// checkProtected refuses changes to a protected environment unless it is
+// named by --confirm, confirm lists the names given, comma separated. With
+// a block they're refused unless lazyflags runs with --elevated.
+func (c *cli) checkProtected(ctx context.Context, appId string, envId string, envName string, confirm string) error {
+	if !isProtected(ctx, c.client, c.protected, appId, envId, envName) {
+		return nil
+	}
+	if c.protected.Blocked() {
+		return fmt.Errorf("environment %q is protected, run with --elevated to change it", envName)
+	}
+	if !slices.Contains(strings.Split(confirm, ","), envName) {
+		return fmt.Errorf("environment %q is protected, confirm the change with --confirm %s", envName, envName)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package app_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+)
+
+func TestProtectedConfirm(t *testing.T) {
+	commands := []struct {
+		name string
+		args []string
+	}{
+		{name: "flags set", args: []string{"flags", "set", "--app", "Wordle", "--profile", "WebFeatureFlags", "--env", "production", "--flag", "beta_feature", "--enabled=true"}},
+		{name: "deploy", args: []string{"deploy", "--app", "Wordle", "--profile", "WebFeatureFlags", "--env", "production", "--version", "2"}},
+		{name: "undo", args: []string{"undo", "--app", "Wordle", "--profile", "WebFeatureFlags", "--env", "production"}},
+	}
+
+	byName := func(f *settings.File) { f.Protected.Environments = []string{"prod*"} }
+	tests := []struct {
+		name     string
+		settings func(*settings.File)
+		confirm  []string
+		// exit code and what stderr contains, refused changes create and
+		// deploy nothing
+		expectedCode int
+		expectedErr  string
+	}{
+		{
+			name:         "should refuse a protected environment without --confirm",
+			settings:     byName,
+			expectedCode: 1,
+			expectedErr:  `error: environment "production" is protected, confirm the change with --confirm production`,
+		},
+		{
+			name:         "should refuse --confirm naming another environment",
+			settings:     byName,
+			confirm:      []string{"--confirm", "staging"},
+			expectedCode: 1,
+			expectedErr:  `error: environment "production" is protected, confirm the change with --confirm production`,
+		},
+		{
+			name:         "should refuse an environment protected by its tags",
+			settings:     func(f *settings.File) { f.Protected.Tags = map[string]string{"Protected": "true"} },
+			expectedCode: 1,
+			expectedErr:  `error: environment "production" is protected, confirm the change with --confirm production`,
+		},
+		{
+			name:     "should change a protected environment confirmed",
+			settings: byName,
+			confirm:  []string{"--confirm", "production"},
+		},
+		{
+			name: "should refuse a blocked environment even confirmed",
+			settings: func(f *settings.File) {
+				byName(f)
+				f.Protected.Block = true
+			},
+			confirm:      []string{"--confirm", "production"},
+			expectedCode: 1,
+			expectedErr:  `error: environment "production" is protected, run with --elevated to change it`,
+		},
+	}
+
+	for _, cmd := range commands {
+		for _, tt := range tests {
+			t.Run(cmd.name+" "+tt.name, func(t *testing.T) {
+				file := settings.Default()
+				tt.settings(&file)
+				c := newCLI(t, file)
+
+				// something to undo, version 4 is live in production
+				client := appconfig.NewWithClients(c.backend, c.backend)
+				if _, err := client.SetFlag(context.Background(), "wordle1", "webflg1", "pro0001", "dark_mode", true, ""); err != nil {
+					t.Fatalf("SetFlag: %v", err)
+				}
+				versions, deploys := c.backend.Calls("CreateHostedConfigurationVersion"), c.backend.Calls("StartDeployment")
+
+				code, stdout, stderr := c.run(append(cmd.args, tt.confirm...)...)
+				if code != tt.expectedCode {
+					t.Errorf("expected exit code %d, got %d: %s%s", tt.expectedCode, code, stdout, stderr)
+				}
+				if !strings.Contains(stderr, tt.expectedErr) {
+					t.Errorf("expected stderr to contain %q, got %q", tt.expectedErr, stderr)
+				}
+
+				expectedDeploys := 0
+				if tt.expectedCode == 0 {
+					expectedDeploys = 1
+				}
+				if calls := c.backend.Calls("StartDeployment") - deploys; calls != expectedDeploys {
+					t.Errorf("expected %d deployments, got %d", expectedDeploys, calls)
+				}
+				if tt.expectedCode != 0 && c.backend.Calls("CreateHostedConfigurationVersion") != versions {
+					t.Errorf("expected a refused change to create no version")
+				}
+			})
+		}
+	}
+}
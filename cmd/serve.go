@@ -0,0 +1,42 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig/fake"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/localserver"
+)
+
+// serve runs the local AppConfig stand-in until interrupted
+func (c *cli) serve(ctx context.Context, args []string) error {
+	fs := newFlagSet("serve")
+	addr := fs.String("addr", "127.0.0.1:4566", "address to listen on")
+	data := fs.String("data", "", "JSON file to persist to, in memory when empty")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	store := fake.NewBackend(fake.DemoData())
+	if *data != "" {
+		var err error
+		if store, err = fake.Open(*data); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+
+	log.SetOutput(os.Stderr)
+	fmt.Fprintf(c.out, "Local AppConfig listening on http://%s\n", listener.Addr())
+	fmt.Fprintf(c.out, "Run lazyflags --endpoint http://%s to use it\n", listener.Addr())
+
+	return http.Serve(listener, localserver.NewHandler(store))
+}
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/filecache"
+)
+
+func (c *cli) undo(ctx context.Context, args []string) error {
+	fs := newFlagSet("undo")
+	appRef := fs.String("app", "", "application name or id")
+	profileRef := fs.String("profile", "", "configuration profile name or id")
+	envRef := fs.String("env", "", "environment name or id")
+	strategy := fs.String("strategy", c.strategy, "deployment strategy id")
+	dryRun := fs.Bool("dry-run", false, "only show what the undo changes")
+	confirm := fs.String("confirm", "", "name of the environment, required when it is protected")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "app", "profile", "env"); err != nil {
+		return err
+	}
+
+	app, err := c.resolveApp(ctx, *appRef)
+	if err != nil {
+		return err
+	}
+	profile, err := c.resolveProfile(ctx, *app.Id, *profileRef)
+	if err != nil {
+		return err
+	}
+	env, err := c.resolveEnv(ctx, *app.Id, *envRef)
+	if err != nil {
+		return err
+	}
+
+	plan, err := c.client.PlanUndo(ctx, *app.Id, *profile.Id, *env.Id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *env.Name, err)
+	}
+	fmt.Fprintf(c.out, "Undo deployment %d of version %d to %s, redeploying version %d:\n",
+		plan.Current.Number, plan.Current.Version, *env.Name, plan.Previous.Version)
+	plan.Changes.Write(c.out, "  ")
+	if plan.InProgress() {
+		fmt.Fprintf(c.out, "Deployment %d is still rolling out and is stopped first.\n", plan.Current.Number)
+	}
+	if *dryRun {
+		return nil
+	}
+
+	if err := c.checkProtected(ctx, *app.Id, *env.Id, *env.Name, *confirm); err != nil {
+		return err
+	}
+	if c.approval.Required(*env.Name) {
+		return fmt.Errorf("%s %w", *env.Name, errNeedsApproval)
+	}
+
+	deployment, err := c.client.Undo(ctx, *app.Id, *profile.Id, plan, *strategy)
+	if err != nil {
+		return err
+	}
+	filecache.Delete(c.cache, filecache.Flags, flagsCacheKey(*app.Id, *profile.Id))
+
+	fmt.Fprintf(c.out, "Started deployment %d of version %d to %s (%s)\n",
+		deployment.Number, deployment.Version, *env.Name, deployment.State)
+	return nil
+}
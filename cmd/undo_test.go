@@ -0,0 +1,112 @@
+package app_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+)
+
+func TestUndo(t *testing.T) {
+	undone := strings.Join([]string{
+		"Undo deployment 3 of version 4 to staging, redeploying version 2:",
+		"  ~ beta_feature",
+		"      enabled: on -> off",
+		"",
+	}, "\n")
+
+	tests := []struct {
+		name     string
+		settings func(*settings.File)
+		// strategy beta_feature is turned on in staging with, empty for no
+		// toggle
+		strategy string
+		args     []string
+		// exit code, what stdout and stderr contain
+		expectedCode int
+		expectedOut  string
+		expectedErr  string
+		// StopDeployment and StartDeployment calls of the undo
+		expectedStops   int
+		expectedDeploys int
+		// beta_feature in staging afterwards
+		expectedOn bool
+	}{
+		{
+			name:         "should have nothing to undo without an earlier version",
+			expectedCode: 1,
+			expectedErr:  "error: staging: no other version was deployed to the environment before",
+		},
+		{
+			name:        "should only show the undo on a dry run",
+			strategy:    "AppConfig.AllAtOnce",
+			args:        []string{"--dry-run"},
+			expectedOut: undone,
+			expectedOn:  true,
+		},
+		{
+			name:            "should redeploy the previous version",
+			strategy:        "AppConfig.AllAtOnce",
+			expectedOut:     undone + "Started deployment 4 of version 2 to staging (COMPLETE)\n",
+			expectedDeploys: 1,
+		},
+		{
+			name:            "should stop a rollout and redeploy once it's rolled back",
+			strategy:        "AppConfig.Linear50PercentEvery30Seconds",
+			args:            []string{"--strategy", "AppConfig.AllAtOnce"},
+			expectedOut:     undone + "Deployment 3 is still rolling out and is stopped first.\nStarted deployment 4 of version 2 to staging (COMPLETE)\n",
+			expectedStops:   1,
+			expectedDeploys: 1,
+		},
+		{
+			name:         "should refuse environments that need approval",
+			settings:     func(f *settings.File) { f.Approval.Environments = []string{"staging"} },
+			strategy:     "AppConfig.AllAtOnce",
+			expectedCode: 1,
+			expectedOut:  undone,
+			expectedErr:  "error: staging needs approval",
+			expectedOn:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := settings.Default()
+			if tt.settings != nil {
+				tt.settings(&file)
+			}
+			c := newCLI(t, file)
+
+			if tt.strategy != "" {
+				client := appconfig.NewWithClients(c.backend, c.backend)
+				if _, err := client.SetFlag(context.Background(), "wordle1", "webflg1", "sta0001", "beta_feature", true, tt.strategy); err != nil {
+					t.Fatalf("SetFlag: %v", err)
+				}
+			}
+			deploys := c.backend.Calls("StartDeployment")
+
+			args := append([]string{"undo", "--app", "Wordle", "--profile", "WebFeatureFlags", "--env", "staging"}, tt.args...)
+			code, stdout, stderr := c.run(args...)
+			if code != tt.expectedCode {
+				t.Errorf("expected exit code %d, got %d: %s", tt.expectedCode, code, stderr)
+			}
+			if stdout != tt.expectedOut {
+				t.Errorf("expected stdout:\n%s\ngot:\n%s", tt.expectedOut, stdout)
+			}
+			if !strings.Contains(stderr, tt.expectedErr) {
+				t.Errorf("expected stderr to contain %q, got %q", tt.expectedErr, stderr)
+			}
+			if calls := c.backend.Calls("StopDeployment"); calls != tt.expectedStops {
+				t.Errorf("expected %d stopped deployments, got %d", tt.expectedStops, calls)
+			}
+			if calls := c.backend.Calls("StartDeployment") - deploys; calls != tt.expectedDeploys {
+				t.Errorf("expected %d deployments, got %d", tt.expectedDeploys, calls)
+			}
+			if on := c.enabled("sta0001", "beta_feature"); on != tt.expectedOn {
+				t.Errorf("expected beta_feature on in staging to be %v, got %v", tt.expectedOn, on)
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/cursor"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+)
+
+// UndoItem is the undo of the last deployment to an environment, or why
+// there is none
+type UndoItem struct {
+	envName string
+	plan    appconfig.UndoPlan
+	err     error
+}
+
+func (i UndoItem) FilterValue() string {
+	switch {
+	case errors.Is(i.err, appconfig.ErrNothingToUndo):
+		return fmt.Sprintf("%-12s nothing to undo", i.envName)
+	case i.err != nil:
+		return fmt.Sprintf("%-12s error: %v", i.envName, i.err)
+	}
+	s := fmt.Sprintf("%-12s deployment %d  v%d -> v%d  %s", i.envName, i.plan.Current.Number, i.plan.Current.Version, i.plan.Previous.Version, changeCounts(i.plan.Changes))
+	if i.plan.InProgress() {
+		s += "  rolling out"
+	}
+	return s
+}
+
+// changeCounts describes a change set in a few characters, e.g. +0 ~1 -0
+func changeCounts(changes appconfig.ChangeSet) string {
+	created, updated, deleted := changes.Counts()
+	return fmt.Sprintf("+%d ~%d -%d", created, updated, deleted)
+}
+
+// undoRequest is sent when the user confirms an undo. The Model carries it
+// out and reports back through FinishUndo.
+type undoRequest struct {
+	envName string
+	plan    appconfig.UndoPlan
+}
+
+// UndoView lists the last deployment to each environment of the flags
+// shown and undoes one by redeploying the version live before it.
+//
+// CLI output of a review looks like this:
+//
+// ┌─ Undo deployment 4 ──────────────────────────────────────────────────────┐
+// │                                                                          │
+// │  Redeploy version 3 to production, undoing version 4:                    │
+// │                                                                          │
+// │  ~ dark_mode                                                             │
+// │      enabled: on -> off                                                  │
+// │                                                                          │
+// │   Yes, undo     Cancel                                                   │
+// │                                                                          │
+// └──────────────────────────────────────────────────────────────────────────┘
+type UndoView struct {
+	list  *ListPanel
+	width int
+
+	// undo under review, the list shows while reviewing is false
+	reviewing bool
+	item      UndoItem
+	btnIdx    int // 0 = Yes, 1 = Cancel
+	undoing   bool
+	// outcome of the last undo
+	status string
+
+	// environments, keyed by name, whose undo needs a typed confirmation,
+	// or is refused when blocked
+	protected map[string]bool
+	blocked   bool
+	// environments whose changes another user approves can't be undone
+	needsApproval func(envName string) bool
+	typing        bool
+	input         textinput.Model
+	typedErr      string
+}
+
+func NewUndoView(height int, width int) *UndoView {
+	input := textinput.New()
+	input.Prompt = "> "
+	input.Width = 30
+	// a blinking cursor would re-render the view twice a second
+	input.Cursor.SetMode(cursor.CursorStatic)
+
+	return &UndoView{
+		list:  NewListPanel(height, width, "Undo the last deployment", []list.Item{}),
+		width: width,
+		input: input,
+	}
+}
+
+// SetData replaces the environments listed, in the order of the table
+func (v *UndoView) SetData(items []UndoItem) tea.Cmd {
+	listItems := make([]list.Item, 0, len(items))
+	for _, item := range items {
+		listItems = append(listItems, item)
+	}
+	v.reviewing = false
+	v.typing = false
+	return v.list.SetItems(listItems)
+}
+
+// SetProtected marks the environments, keyed by name, whose undo needs a
+// typed confirmation, or is refused when blocked
+func (v *UndoView) SetProtected(envs map[string]bool, blocked bool) {
+	v.protected = envs
+	v.blocked = blocked
+}
+
+// SetApproval sets which environments need another user's approval
+func (v *UndoView) SetApproval(required func(envName string) bool) {
+	v.needsApproval = required
+}
+
+func (v *UndoView) SetStatus(status string) {
+	v.status = status
+}
+
+// Warn adds a warning under the status, e.g. a change the audit log
+// couldn't record
+func (v *UndoView) Warn(warning string) {
+	if v.status != "" {
+		v.status += "\n"
+	}
+	v.status += warning
+}
+
+// Review shows what undoing the highlighted environment changes, Cancel is
+// selected for safety. Environments with nothing to undo stay in the list.
+func (v *UndoView) Review() {
+	selected, ok := v.list.SelectedItem()
+	if !ok {
+		return
+	}
+	item, ok := selected.(UndoItem)
+	if !ok || item.err != nil {
+		return
+	}
+	v.reviewing = true
+	v.item = item
+	v.btnIdx = 1
+	v.status = ""
+}
+
+func (v *UndoView) IsReviewing() bool {
+	return v.reviewing
+}
+
+// IsTyping reports whether the confirmation of a protected environment is
+// being typed, every key but esc belongs to the input then
+func (v *UndoView) IsTyping() bool {
+	return v.typing
+}
+
+func (v *UndoView) CloseReview() {
+	v.reviewing = false
+	v.typing = false
+	v.input.Blur()
+}
+
+// FinishUndo ends an undo started from the review
+func (v *UndoView) FinishUndo(deployment appconfig.Deployment, err error) {
+	v.undoing = false
+	if err != nil {
+		v.status = fmt.Sprintf("Error: %v", err)
+		return
+	}
+	v.reviewing = false
+	v.status = fmt.Sprintf("Started deployment %d of version %d to %s", deployment.Number, deployment.Version, v.item.envName)
+}
+
+// refusal is why the environment under review can't be undone from here
+func (v *UndoView) refusal() string {
+	switch {
+	case v.protected[v.item.envName] && v.blocked:
+		return "Protected, start with --elevated"
+	case v.needsApproval != nil && v.needsApproval(v.item.envName):
+		return "Needs approval, propose the change"
+	}
+	return ""
+}
+
+func (v *UndoView) HandleMsg(msg tea.Msg) tea.Cmd {
+	if v.undoing {
+		return nil
+	}
+	if !v.reviewing {
+		return v.list.HandleMsg(msg)
+	}
+	if v.typing {
+		return v.handleTyping(msg)
+	}
+
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return nil
+	}
+	switch keyMsg.String() {
+	case "left", "h":
+		if v.refusal() == "" {
+			v.btnIdx = 0
+		}
+	case "right", "l":
+		v.btnIdx = 1
+	case "enter":
+		if v.btnIdx == 1 {
+			v.CloseReview()
+			return nil
+		}
+		if v.protected[v.item.envName] {
+			v.typing = true
+			v.typedErr = ""
+			v.input.Reset()
+			v.input.Focus()
+			return nil
+		}
+		return v.start()
+	}
+	return nil
+}
+
+// handleTyping undoes once the environment name is typed
+func (v *UndoView) handleTyping(msg tea.Msg) tea.Cmd {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return nil
+	}
+
+	switch keyMsg.String() {
+	case "enter":
+		typed := strings.TrimSpace(v.input.Value())
+		if typed != v.item.envName {
+			v.typedErr = fmt.Sprintf("%q doesn't match", typed)
+			return nil
+		}
+		v.typing = false
+		v.input.Blur()
+		return v.start()
+	case "esc":
+		v.CloseReview()
+		return nil
+	}
+
+	v.typedErr = ""
+	var cmd tea.Cmd
+	v.input, cmd = v.input.Update(msg)
+	return cmd
+}
+
+func (v *UndoView) start() tea.Cmd {
+	v.undoing = true
+	v.status = ""
+	request := undoRequest{envName: v.item.envName, plan: v.item.plan}
+	return func() tea.Msg { return request }
+}
+
+func (v *UndoView) Render() string {
+	if v.reviewing {
+		return v.renderReview()
+	}
+	if len(v.list.model.Items()) == 0 {
+		msg := "Loading deployments..."
+		if v.status != "" {
+			msg = v.status
+		}
+		return v.list.RenderError(msg)
+	}
+	if v.status != "" {
+		return RenderPanel(v.list.model.View()+"\n"+v.status, v.list.title, v.width)
+	}
+	return v.list.Render()
+}
+
+func (v *UndoView) renderReview() string {
+	selectedStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(lipgloss.Color("#000")).
+		Background(nordfoxBlue).
+		Padding(0, 1)
+	normalStyle := lipgloss.NewStyle().
+		Padding(0, 1)
+
+	yesBtn := "Yes, undo"
+	cancelBtn := "Cancel"
+	if v.btnIdx == 0 {
+		yesBtn = selectedStyle.Render(yesBtn)
+		cancelBtn = normalStyle.Render(cancelBtn)
+	} else {
+		yesBtn = normalStyle.Render(yesBtn)
+		cancelBtn = selectedStyle.Render(cancelBtn)
+	}
+
+	plan := v.item.plan
+	var content strings.Builder
+	if v.protected[v.item.envName] {
+		content.WriteString(alertStyle.Render("PROTECTED ENVIRONMENT") + "\n\n")
+	}
+	content.WriteString(fmt.Sprintf("Redeploy version %d to %s, undoing version %d:\n\n", plan.Previous.Version, v.item.envName, plan.Current.Version))
+	if len(plan.Changes) == 0 {
+		content.WriteString("No changes\n")
+	} else {
+		content.WriteString(plan.Changes.String())
+	}
+	if plan.InProgress() {
+		content.WriteString(fmt.Sprintf("\nDeployment %d is still rolling out, it is stopped first.\n", plan.Current.Number))
+	}
+	content.WriteString("\n")
+
+	switch {
+	case v.undoing:
+		content.WriteString("Deploying...")
+	case v.refusal() != "":
+		content.WriteString(v.refusal() + "\n\n" + selectedStyle.Render("Cancel"))
+	case v.typing:
+		content.WriteString(fmt.Sprintf("Type %s to confirm:\n", v.item.envName))
+		content.WriteString(v.input.View())
+		if v.typedErr != "" {
+			content.WriteString("\n" + v.typedErr)
+		}
+	default:
+		content.WriteString(yesBtn + "     " + cancelBtn)
+	}
+	if v.status != "" {
+		content.WriteString("\n\n" + v.status)
+	}
+
+	return RenderPanel(content.String(), fmt.Sprintf("Undo deployment %d", plan.Current.Number), v.width)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/appconfig"
@@ -29,6 +30,51 @@ type ConfigClient interface {
 		*appconfig.ListConfigurationProfilesInput,
 		...func(*appconfig.Options),
 	) (*appconfig.ListConfigurationProfilesOutput, error)
+	GetConfigurationProfile(
+		context.Context,
+		*appconfig.GetConfigurationProfileInput,
+		...func(*appconfig.Options),
+	) (*appconfig.GetConfigurationProfileOutput, error)
+	ListDeployments(
+		context.Context,
+		*appconfig.ListDeploymentsInput,
+		...func(*appconfig.Options),
+	) (*appconfig.ListDeploymentsOutput, error)
+	GetDeployment(
+		context.Context,
+		*appconfig.GetDeploymentInput,
+		...func(*appconfig.Options),
+	) (*appconfig.GetDeploymentOutput, error)
+	ListHostedConfigurationVersions(
+		context.Context,
+		*appconfig.ListHostedConfigurationVersionsInput,
+		...func(*appconfig.Options),
+	) (*appconfig.ListHostedConfigurationVersionsOutput, error)
+	GetHostedConfigurationVersion(
+		context.Context,
+		*appconfig.GetHostedConfigurationVersionInput,
+		...func(*appconfig.Options),
+	) (*appconfig.GetHostedConfigurationVersionOutput, error)
+	CreateHostedConfigurationVersion(
+		context.Context,
+		*appconfig.CreateHostedConfigurationVersionInput,
+		...func(*appconfig.Options),
+	) (*appconfig.CreateHostedConfigurationVersionOutput, error)
+	StartDeployment(
+		context.Context,
+		*appconfig.StartDeploymentInput,
+		...func(*appconfig.Options),
+	) (*appconfig.StartDeploymentOutput, error)
+	StopDeployment(
+		context.Context,
+		*appconfig.StopDeploymentInput,
+		...func(*appconfig.Options),
+	) (*appconfig.StopDeploymentOutput, error)
+	ListTagsForResource(
+		context.Context,
+		*appconfig.ListTagsForResourceInput,
+		...func(*appconfig.Options),
+	) (*appconfig.ListTagsForResourceOutput, error)
 }
 
 type DataClient interface {
@@ -72,16 +118,44 @@ type Flags map[string]Flag
 type Client struct {
 	configClient ConfigClient
 	dataClient   DataClient
+	// shared by copies of the Client
+	fanout             *fanout
+	sessions           *sessionStore
+	deploymentProfiles *profileIds
+	// region and account of the caller, resources are tagged by ARN
+	region  string
+	account string
+	// ARN of the caller, proposals record who made them
+	caller string
+	// told about every change, see WithAudit
+	audit    func(Event)
+	auditNow func() time.Time
+	// refuses changes before they are made, see WithCheck
+	check func(ctx context.Context, client *Client, change Change) error
 }
 
-func New(cfg aws.Config) *Client {
+func New(cfg aws.Config, opts ...Option) *Client {
 	configClient := appconfig.NewFromConfig(cfg)
 	dataClient := appconfigdata.NewFromConfig(cfg)
 
-	return &Client{
-		configClient: configClient,
-		dataClient:   dataClient,
+	return NewWithClients(configClient, dataClient, append([]Option{withRegion(cfg.Region)}, opts...)...)
+}
+
+// NewWithClients builds a Client on top of existing API clients, e.g. the
+// in-memory backend in package fake.
+func NewWithClients(configClient ConfigClient, dataClient DataClient, opts ...Option) *Client {
+	c := &Client{
+		configClient:       configClient,
+		dataClient:         dataClient,
+		fanout:             newFanout(),
+		sessions:           newSessionStore(),
+		deploymentProfiles: newProfileIds(),
+		auditNow:           time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
 func (c *Client) ListApps(ctx context.Context) ([]App, error) {
@@ -142,34 +216,70 @@ func (c *Client) ListAppEnvironments(ctx context.Context, appId string) ([]AppEn
 }
 
 // Careful - this request costs $$$
+// The session of each app/profile/env is kept and polled with its next token,
+// so only changes are downloaded. Until the session's poll interval has
+// passed the last flags are returned without calling AWS.
 func (c *Client) GetLatestFlagConfig(ctx context.Context, appId, configId, envId string, minPollInterval int32) (Flags, error) {
-	session, err := c.dataClient.StartConfigurationSession(ctx, &appconfigdata.StartConfigurationSessionInput{
-		ApplicationIdentifier:                &appId,
-		ConfigurationProfileIdentifier:       &configId,
-		EnvironmentIdentifier:                &envId,
-		RequiredMinimumPollIntervalInSeconds: &minPollInterval,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to establish configuration session: %w", err)
+	key := sessionKey(appId, configId, envId)
+	sess := c.sessions.get(key)
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	now := c.sessions.now()
+	if sess.flags != nil && now.Before(sess.nextPoll) {
+		return sess.flags.clone(), nil
+	}
+
+	if sess.token == "" {
+		session, err := c.dataClient.StartConfigurationSession(ctx, &appconfigdata.StartConfigurationSessionInput{
+			ApplicationIdentifier:                &appId,
+			ConfigurationProfileIdentifier:       &configId,
+			EnvironmentIdentifier:                &envId,
+			RequiredMinimumPollIntervalInSeconds: &minPollInterval,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to establish configuration session: %w", err)
+		}
+		sess.token = aws.ToString(session.InitialConfigurationToken)
 	}
 
-	res, err := c.dataClient.GetLatestConfiguration(ctx, &appconfigdata.GetLatestConfigurationInput{ConfigurationToken: session.InitialConfigurationToken})
+	res, err := c.dataClient.GetLatestConfiguration(ctx, &appconfigdata.GetLatestConfigurationInput{ConfigurationToken: &sess.token})
 	if err != nil {
+		// the token may have been used or expired, start over next time
+		c.sessions.forget(key)
 		return nil, fmt.Errorf("failed to get feature flags: %w", err)
 	}
+	sess.token = aws.ToString(res.NextPollConfigurationToken)
+	sess.nextPoll = now.Add(time.Duration(res.NextPollIntervalInSeconds) * time.Second)
+
+	// an empty body means nothing changed since the last poll
+	if len(res.Configuration) == 0 && sess.flags != nil {
+		return sess.flags.clone(), nil
+	}
 
 	var flags Flags
 
 	err = json.Unmarshal(res.Configuration, &flags)
 	if err != nil {
+		c.sessions.forget(key)
 		return nil, fmt.Errorf("failed to unmarshal feature flags: %w", err)
 	}
+	sess.flags = flags
 
 	log.Printf("config: %v", flags)
-	return flags, nil
+	return flags.clone(), nil
+}
+
+func (f Flags) clone() Flags {
+	flags := make(Flags, len(f))
+	for name, flag := range f {
+		flags[name] = flag
+	}
+	return flags
 }
 
 type Result struct {
+	EnvId    string
 	EnvName  string
 	EnvState types.EnvironmentState
 	Flags    Flags
@@ -181,21 +291,49 @@ func (c *Client) GetFlags(ctx context.Context, appId string, configId string) ([
 	if err != nil {
 		return nil, fmt.Errorf("failed to list app environments: %w", err)
 	}
+
+	// results keep the order environments are listed in, so output is stable
+	index := make(map[string]int, len(envs))
+	for i, env := range envs {
+		index[*env.Id] = i
+	}
+	results := make([]Result, len(envs))
+	for result := range c.StreamFlags(ctx, appId, configId, envs) {
+		results[index[result.EnvId]] = result
+	}
+
+	return results, nil
+}
+
+// StreamFlags fetches the flags of each environment concurrently and sends
+// every Result as soon as it arrives. As many workers as the Client's
+// concurrency limit fetch the environments in order, so a long list of
+// environments doesn't start a goroutine each. The channel is closed once
+// all environments are done.
+func (c *Client) StreamFlags(ctx context.Context, appId string, configId string, envs []AppEnvironments) <-chan Result {
 	results := make(chan Result, len(envs))
+	pending := make(chan AppEnvironments, len(envs))
+	for _, env := range envs {
+		pending <- env
+	}
+	close(pending)
 
 	var wg sync.WaitGroup
-	for _, env := range envs {
+	for range min(cap(c.fanout.slots), len(envs)) {
 		wg.Add(1)
-		go func(env AppEnvironments) {
+		go func() {
 			defer wg.Done()
-			flags, err := c.GetLatestFlagConfig(ctx, appId, configId, *env.Id, 60)
-			results <- Result{
-				EnvName:  *env.Name,
-				EnvState: env.State,
-				Flags:    flags,
-				Err:      err,
+			for env := range pending {
+				flags, err := c.fetchFlags(ctx, appId, configId, *env.Id)
+				results <- Result{
+					EnvId:    *env.Id,
+					EnvName:  *env.Name,
+					EnvState: env.State,
+					Flags:    flags,
+					Err:      err,
+				}
 			}
-		}(env)
+		}()
 	}
 
 	go func() {
@@ -203,10 +341,5 @@ func (c *Client) GetFlags(ctx context.Context, appId string, configId string) ([
 		close(results)
 	}()
 
-	res := make([]Result, 0, len(envs))
-	for result := range results {
-		res = append(res, result)
-	}
-
-	return res, nil
+	return results
 }
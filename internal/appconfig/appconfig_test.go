@@ -0,0 +1,295 @@
+package appconfig_test
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig/fake"
+)
+
+func newFakeClient() (*appconfig.Client, *fake.Backend) {
+	backend := fake.NewBackend(fake.DemoData())
+	return appconfig.NewWithClients(backend, backend), backend
+}
+
+func TestGetFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		faults   []fake.Fault
+		expected map[string]string
+	}{
+		{
+			name: "should return flags for every environment",
+			expected: map[string]string{
+				"development": "",
+				"staging":     "",
+				"production":  "",
+			},
+		},
+		{
+			name: "should report a throttled environment without failing the others",
+			faults: []fake.Fault{
+				{Operation: "GetLatestConfiguration", Environment: "staging", Err: fake.ErrThrottling},
+			},
+			expected: map[string]string{
+				"development": "",
+				"staging":     "failed to get feature flags: ThrottlingException: Rate exceeded",
+				"production":  "",
+			},
+		},
+		{
+			name: "should report an environment that is not allowed to be read",
+			faults: []fake.Fault{
+				{Operation: "StartConfigurationSession", Environment: "pro0001", Err: fake.ErrAccessDenied},
+			},
+			expected: map[string]string{
+				"development": "",
+				"staging":     "",
+				"production":  "failed to establish configuration session: AccessDeniedException: User is not authorized to perform this action",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, backend := newFakeClient()
+			for _, f := range tt.faults {
+				backend.AddFault(f)
+			}
+
+			results, err := client.GetFlags(context.Background(), "wordle1", "webflg1")
+			if err != nil {
+				t.Fatalf("GetFlags: %v", err)
+			}
+			if len(results) != len(tt.expected) {
+				t.Fatalf("expected %d results, got %d", len(tt.expected), len(results))
+			}
+
+			for _, result := range results {
+				var got string
+				if result.Err != nil {
+					got = result.Err.Error()
+				}
+				if got != tt.expected[result.EnvName] {
+					t.Errorf("%s: expected error %q, got %q", result.EnvName, tt.expected[result.EnvName], got)
+				}
+				if result.Err == nil && len(result.Flags) != 3 {
+					t.Errorf("%s: expected 3 flags, got %v", result.EnvName, result.Flags)
+				}
+			}
+		})
+	}
+}
+
+func TestGetFlagsListEnvironmentsFails(t *testing.T) {
+	client, backend := newFakeClient()
+	backend.AddFault(fake.Fault{Operation: "ListEnvironments", Err: fake.ErrAccessDenied})
+
+	if _, err := client.GetFlags(context.Background(), "wordle1", "webflg1"); !errors.Is(err, fake.ErrAccessDenied) {
+		t.Errorf("expected AccessDenied, got %v", err)
+	}
+}
+
+func TestGetFlagsTimeout(t *testing.T) {
+	client, backend := newFakeClient()
+	backend.AddFault(fake.Fault{Operation: "GetLatestConfiguration", Environment: "production", Delay: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	results, err := client.GetFlags(ctx, "wordle1", "webflg1")
+	if err != nil {
+		t.Fatalf("GetFlags: %v", err)
+	}
+	for _, result := range results {
+		timedOut := errors.Is(result.Err, context.DeadlineExceeded)
+		if timedOut != (result.EnvName == "production") {
+			t.Errorf("%s: unexpected error %v", result.EnvName, result.Err)
+		}
+	}
+}
+
+func TestSetFlag(t *testing.T) {
+	client, backend := newFakeClient()
+	ctx := context.Background()
+
+	// a failed deployment leaves the new version undeployed
+	backend.AddFault(fake.Fault{Operation: "StartDeployment", Err: fake.ErrInternal, Times: 1})
+	if _, err := client.SetFlag(ctx, "wordle1", "webflg1", "production", "dark_mode", true, ""); err == nil {
+		t.Fatalf("expected the deployment to fail")
+	}
+	deployed, err := client.DeployedVersion(ctx, "wordle1", "webflg1", "pro0001")
+	if err != nil {
+		t.Fatalf("DeployedVersion: %v", err)
+	}
+	if deployed.Version != 3 {
+		t.Errorf("expected version 3 to stay deployed, got %d", deployed.Version)
+	}
+
+	deployment, err := client.SetFlag(ctx, "wordle1", "webflg1", "pro0001", "dark_mode", true, "")
+	if err != nil {
+		t.Fatalf("SetFlag: %v", err)
+	}
+	if deployment.Version != 5 {
+		t.Errorf("expected version 5, got %d", deployment.Version)
+	}
+
+	flags, err := client.GetLatestFlagConfig(ctx, "wordle1", "webflg1", "pro0001", 60)
+	if err != nil {
+		t.Fatalf("GetLatestFlagConfig: %v", err)
+	}
+	if !flags["dark_mode"].Enabled {
+		t.Errorf("expected dark_mode to be on in production")
+	}
+	if calls := backend.Calls("StartDeployment"); calls != 2 {
+		t.Errorf("expected 2 StartDeployment calls, got %d", calls)
+	}
+}
+
+func TestSetFlagAfterStoppedDeployment(t *testing.T) {
+	client, backend := newFakeClient()
+	ctx := context.Background()
+	now := time.Now()
+	backend.Now = func() time.Time { return now }
+
+	bad, err := client.SetFlag(ctx, "wordle1", "webflg1", "pro0001", "dark_mode", true, "AppConfig.Linear20PercentEvery6Minutes")
+	if err != nil {
+		t.Fatalf("SetFlag: %v", err)
+	}
+	if _, err := client.StopDeployment(ctx, "wordle1", "webflg1", "pro0001", bad); err != nil {
+		t.Fatalf("StopDeployment: %v", err)
+	}
+
+	deployed, err := client.DeployedVersion(ctx, "wordle1", "webflg1", "pro0001")
+	if err != nil {
+		t.Fatalf("DeployedVersion: %v", err)
+	}
+	if deployed.Version != 3 {
+		t.Fatalf("expected the rolled back version 4 to be skipped, got version %d", deployed.Version)
+	}
+
+	// AppConfig takes one deployment at a time, rolling back included
+	_, err = client.SetFlag(ctx, "wordle1", "webflg1", "pro0001", "beta_feature", true, "")
+	if apiErr := (*fake.APIError)(nil); !errors.As(err, &apiErr) || apiErr.Code != "ConflictException" {
+		t.Fatalf("expected a ConflictException while rolling back, got %v", err)
+	}
+	now = now.Add(backend.RollbackDuration)
+
+	// the toggle builds on what clients receive, not on the stopped version
+	if _, err := client.SetFlag(ctx, "wordle1", "webflg1", "pro0001", "beta_feature", true, ""); err != nil {
+		t.Fatalf("SetFlag: %v", err)
+	}
+	flags, err := client.GetLatestFlagConfig(ctx, "wordle1", "webflg1", "pro0001", 60)
+	if err != nil {
+		t.Fatalf("GetLatestFlagConfig: %v", err)
+	}
+	if !flags["beta_feature"].Enabled || flags["dark_mode"].Enabled {
+		t.Errorf("expected only beta_feature to be turned on, got %+v", flags)
+	}
+	if calls := backend.Calls("StopDeployment"); calls != 1 {
+		t.Errorf("expected 1 StopDeployment call, got %d", calls)
+	}
+}
+
+func TestDeployedVersion(t *testing.T) {
+	started := time.Date(2025, 2, 1, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		// changes the demo data, development has deployment 1 of webflg1
+		// version 1 and deployment 2 of apiflg1
+		data            func(app *fake.Application, dev *fake.Environment)
+		expectedVersion int32
+	}{
+		{
+			name:            "should return the deployed version",
+			data:            func(*fake.Application, *fake.Environment) {},
+			expectedVersion: 1,
+		},
+		{
+			name: "should find a deployment past the first page",
+			data: func(_ *fake.Application, dev *fake.Environment) {
+				for i := range 60 {
+					dev.Deployments = append(dev.Deployments, &fake.Deployment{Number: int32(3 + i), ProfileId: "apiflg1", Version: 1, StrategyId: "AppConfig.AllAtOnce", StartedAt: started})
+				}
+			},
+			expectedVersion: 1,
+		},
+		{
+			name: "should skip deployments of another profile with the same name",
+			data: func(app *fake.Application, dev *fake.Environment) {
+				app.Profiles = append(app.Profiles, &fake.Profile{Id: "webflg2", Name: "WebFeatureFlags", Type: "AWS.AppConfig.FeatureFlags", Versions: app.Profiles[0].Versions})
+				dev.Deployments = append(dev.Deployments, &fake.Deployment{Number: 3, ProfileId: "webflg2", Version: 3, StrategyId: "AppConfig.AllAtOnce", StartedAt: started})
+			},
+			expectedVersion: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apps := fake.DemoData()
+			for _, env := range apps[0].Environments {
+				if env.Id == "dev0001" {
+					tt.data(apps[0], env)
+				}
+			}
+			backend := fake.NewBackend(apps)
+			client := appconfig.NewWithClients(backend, backend)
+
+			deployed, err := client.DeployedVersion(context.Background(), "wordle1", "webflg1", "dev0001")
+			if err != nil {
+				t.Fatalf("DeployedVersion: %v", err)
+			}
+			if deployed.Version != tt.expectedVersion {
+				t.Errorf("expected version %d, got %d", tt.expectedVersion, deployed.Version)
+			}
+		})
+	}
+}
+
+func TestEnvironmentTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []appconfig.Option
+		envId    string
+		expected map[string]string
+		wantErr  bool
+	}{
+		{
+			name:     "should return the tags of an environment",
+			opts:     []appconfig.Option{appconfig.WithAccount("123456789012")},
+			envId:    "pro0001",
+			expected: map[string]string{"Protected": "true"},
+		},
+		{
+			name:     "should return no tags of an untagged environment",
+			opts:     []appconfig.Option{appconfig.WithAccount("123456789012")},
+			envId:    "dev0001",
+			expected: map[string]string{},
+		},
+		{
+			name:    "should fail without the account to build the ARN with",
+			envId:   "pro0001",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := fake.NewBackend(fake.DemoData())
+			client := appconfig.NewWithClients(backend, backend, tt.opts...)
+
+			tags, err := client.EnvironmentTags(context.Background(), "wordle1", tt.envId)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EnvironmentTags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !maps.Equal(tags, tt.expected) {
+				t.Errorf("EnvironmentTags() = %v, expected %v", tags, tt.expected)
+			}
+		})
+	}
+}
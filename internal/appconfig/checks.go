@@ -0,0 +1,51 @@
+package appconfig
+
+import "context"
+
+// Change is a version about to be created or deployed, see WithCheck
+type Change struct {
+	AppId    string
+	ConfigId string
+	// empty for versions created for no environment in particular, they
+	// are compared with the latest version
+	EnvId string
+	// what it changes compared with the version deployed to the
+	// environment right now
+	Changes ChangeSet
+}
+
+// WithCheck calls check before every version is created and every
+// deployment started, whichever method makes it. An error refuses the
+// change and nothing is written. The Client is passed so check can read
+// the live state of other environments.
+func WithCheck(check func(ctx context.Context, client *Client, change Change) error) Option {
+	return func(c *Client) {
+		c.check = check
+	}
+}
+
+func (c *Client) checkChange(ctx context.Context, change Change) error {
+	if c.check == nil {
+		return nil
+	}
+	return c.check(ctx, c, change)
+}
+
+// latestChanges compares doc with the latest version, or with version
+// latest when it's set
+func (c *Client) latestChanges(ctx context.Context, appId, configId string, doc *FlagDocument, latest int32) (ChangeSet, error) {
+	if latest == 0 {
+		var err error
+		if latest, err = c.LatestVersion(ctx, appId, configId); err != nil {
+			return nil, err
+		}
+	}
+	var base *FlagDocument
+	if latest > 0 {
+		var err error
+		if base, err = c.GetFlagDocument(ctx, appId, configId, latest); err != nil {
+			return nil, err
+		}
+	}
+	return DiffDocuments(base, doc), nil
+}
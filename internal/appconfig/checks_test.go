@@ -0,0 +1,129 @@
+package appconfig_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig/fake"
+)
+
+func TestWithCheck(t *testing.T) {
+	refused := errors.New("refused")
+
+	tests := []struct {
+		name   string
+		change func(ctx context.Context, client *appconfig.Client) error
+		// environment of the change checked, empty for a version only
+		expectedEnv string
+		// call the change would make if it weren't refused
+		refusedOp string
+	}{
+		{
+			name: "should check a toggle against the deployed version",
+			change: func(ctx context.Context, client *appconfig.Client) error {
+				_, err := client.SetFlag(ctx, "wordle1", "webflg1", "pro0001", "dark_mode", true, "")
+				return err
+			},
+			expectedEnv: "pro0001",
+			refusedOp:   "CreateHostedConfigurationVersion",
+		},
+		{
+			name: "should check a proposal",
+			change: func(ctx context.Context, client *appconfig.Client) error {
+				_, err := client.ProposeFlag(ctx, "wordle1", "webflg1", "pro0001", "dark_mode", true)
+				return err
+			},
+			expectedEnv: "pro0001",
+			refusedOp:   "CreateHostedConfigurationVersion",
+		},
+		{
+			name: "should check a version against the latest version",
+			change: func(ctx context.Context, client *appconfig.Client) error {
+				doc, err := client.GetFlagDocument(ctx, "wordle1", "webflg1", 3)
+				if err != nil {
+					return err
+				}
+				doc = doc.Clone()
+				if err := doc.SetEnabled("dark_mode", true); err != nil {
+					return err
+				}
+				_, err = client.CreateFlagVersion(ctx, "wordle1", "webflg1", doc, "", 0)
+				return err
+			},
+			refusedOp: "CreateHostedConfigurationVersion",
+		},
+		{
+			name: "should check a deployment of an existing version against the deployed version",
+			change: func(ctx context.Context, client *appconfig.Client) error {
+				// version 4 turned dark_mode on in production
+				_, err := client.StartDeployment(ctx, "wordle1", "webflg1", "pro0001", 4, "")
+				return err
+			},
+			expectedEnv: "pro0001",
+			refusedOp:   "StartDeployment",
+		},
+		{
+			name: "should check an undo",
+			change: func(ctx context.Context, client *appconfig.Client) error {
+				plan, err := client.PlanUndo(ctx, "wordle1", "webflg1", "pro0001")
+				if err != nil {
+					return err
+				}
+				_, err = client.Undo(ctx, "wordle1", "webflg1", plan, "")
+				return err
+			},
+			expectedEnv: "pro0001",
+			refusedOp:   "StartDeployment",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			backend := fake.NewBackend(fake.DemoData())
+			var checked []appconfig.Change
+			refuse := false
+			client := appconfig.NewWithClients(backend, backend,
+				appconfig.WithCaller(alice),
+				appconfig.WithCheck(func(ctx context.Context, client *appconfig.Client, change appconfig.Change) error {
+					checked = append(checked, change)
+					if refuse {
+						return refused
+					}
+					return nil
+				}),
+			)
+			// versions 4 and 5 turn dark_mode on and off again in production,
+			// so there is a version to deploy and a deployment to undo
+			if _, err := client.SetFlag(ctx, "wordle1", "webflg1", "pro0001", "dark_mode", true, ""); err != nil {
+				t.Fatalf("SetFlag: %v", err)
+			}
+			if _, err := client.SetFlag(ctx, "wordle1", "webflg1", "pro0001", "dark_mode", false, ""); err != nil {
+				t.Fatalf("SetFlag: %v", err)
+			}
+
+			checked = nil
+			refuse = true
+			calls := backend.Calls(tt.refusedOp)
+			if err := tt.change(ctx, client); !errors.Is(err, refused) {
+				t.Fatalf("expected the change to be refused, got %v", err)
+			}
+			if got := backend.Calls(tt.refusedOp); got != calls {
+				t.Errorf("expected no %s call, got %d", tt.refusedOp, got-calls)
+			}
+
+			if len(checked) != 1 {
+				t.Fatalf("expected 1 check, got %+v", checked)
+			}
+			change := checked[0]
+			if change.AppId != "wordle1" || change.ConfigId != "webflg1" || change.EnvId != tt.expectedEnv {
+				t.Errorf("expected a change of wordle1/webflg1 in %q, got %+v", tt.expectedEnv, change)
+			}
+			if len(change.Changes) != 1 || change.Changes[0].Flag != "dark_mode" {
+				t.Errorf("expected dark_mode to change, got %+v", change.Changes)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package appconfig
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+type ChangeKind string
+
+const (
+	ChangeCreate ChangeKind = "create"
+	ChangeUpdate ChangeKind = "update"
+	ChangeDelete ChangeKind = "delete"
+)
+
+// FieldChange describes one changed property of a flag. Field is "enabled",
+// "name", "description", "deprecation", "attributes" (the definitions) or
+// the key of an attribute value.
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before,omitempty"`
+	After  any    `json:"after,omitempty"`
+}
+
+type FlagChange struct {
+	Flag   string        `json:"flag"`
+	Kind   ChangeKind    `json:"kind"`
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// ChangeSet is the semantic difference between two flag documents, sorted
+// by flag key.
+type ChangeSet []FlagChange
+
+// DiffDocuments compares flag definitions and values. A nil document is
+// treated as empty.
+func DiffDocuments(before, after *FlagDocument) ChangeSet {
+	if before == nil {
+		before = &FlagDocument{}
+	}
+	if after == nil {
+		after = &FlagDocument{}
+	}
+
+	names := map[string]bool{}
+	for name := range before.Flags {
+		names[name] = true
+	}
+	for name := range after.Flags {
+		names[name] = true
+	}
+
+	var changes ChangeSet
+	for _, name := range sortedKeys(names) {
+		beforeDef, inBefore := before.Flags[name]
+		afterDef, inAfter := after.Flags[name]
+
+		switch {
+		case !inBefore:
+			changes = append(changes, FlagChange{
+				Flag:   name,
+				Kind:   ChangeCreate,
+				Fields: diffValues(nil, after.Values[name]),
+			})
+		case !inAfter:
+			changes = append(changes, FlagChange{
+				Flag:   name,
+				Kind:   ChangeDelete,
+				Fields: diffValues(before.Values[name], nil),
+			})
+		default:
+			fields := diffDefinitions(beforeDef, afterDef)
+			fields = append(fields, diffValues(before.Values[name], after.Values[name])...)
+			if len(fields) > 0 {
+				changes = append(changes, FlagChange{Flag: name, Kind: ChangeUpdate, Fields: fields})
+			}
+		}
+	}
+
+	return changes
+}
+
+// DiffValues only compares flag values, for documents where definitions are
+// not known (e.g. the data plane's view of an environment).
+func DiffValues(before, after map[string]FlagValue) ChangeSet {
+	names := map[string]bool{}
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+
+	var changes ChangeSet
+	for _, name := range sortedKeys(names) {
+		beforeValue, inBefore := before[name]
+		afterValue, inAfter := after[name]
+
+		switch {
+		case !inBefore:
+			changes = append(changes, FlagChange{Flag: name, Kind: ChangeCreate, Fields: diffValues(nil, afterValue)})
+		case !inAfter:
+			changes = append(changes, FlagChange{Flag: name, Kind: ChangeDelete, Fields: diffValues(beforeValue, nil)})
+		default:
+			if fields := diffValues(beforeValue, afterValue); len(fields) > 0 {
+				changes = append(changes, FlagChange{Flag: name, Kind: ChangeUpdate, Fields: fields})
+			}
+		}
+	}
+	return changes
+}
+
+func diffDefinitions(before, after FlagDefinition) []FieldChange {
+	var fields []FieldChange
+	if before.Name != after.Name {
+		fields = append(fields, FieldChange{Field: "name", Before: before.Name, After: after.Name})
+	}
+	if before.Description != after.Description {
+		fields = append(fields, FieldChange{Field: "description", Before: before.Description, After: after.Description})
+	}
+	if !reflect.DeepEqual(emptyToNil(before.Deprecation), emptyToNil(after.Deprecation)) {
+		fields = append(fields, FieldChange{Field: "deprecation", Before: before.Deprecation, After: after.Deprecation})
+	}
+	if !reflect.DeepEqual(emptyToNil(before.Attributes), emptyToNil(after.Attributes)) {
+		fields = append(fields, FieldChange{Field: "attributes", Before: sortedKeys(before.Attributes), After: sortedKeys(after.Attributes)})
+	}
+	return fields
+}
+
+// "enabled" is always reported first, flags without a value are disabled
+func diffValues(before, after FlagValue) []FieldChange {
+	var fields []FieldChange
+	if before.Enabled() != after.Enabled() || (before == nil) != (after == nil) {
+		var beforeEnabled, afterEnabled any
+		if before != nil {
+			beforeEnabled = before.Enabled()
+		}
+		if after != nil {
+			afterEnabled = after.Enabled()
+		}
+		fields = append(fields, FieldChange{Field: "enabled", Before: beforeEnabled, After: afterEnabled})
+	}
+
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	delete(keys, "enabled")
+
+	for _, key := range sortedKeys(keys) {
+		if !reflect.DeepEqual(before[key], after[key]) {
+			fields = append(fields, FieldChange{Field: key, Before: before[key], After: after[key]})
+		}
+	}
+	return fields
+}
+
+func emptyToNil[V any](m map[string]V) map[string]V {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// Counts returns the number of created, updated and deleted flags.
+func (c ChangeSet) Counts() (created, updated, deleted int) {
+	for _, change := range c {
+		switch change.Kind {
+		case ChangeCreate:
+			created++
+		case ChangeUpdate:
+			updated++
+		case ChangeDelete:
+			deleted++
+		}
+	}
+	return created, updated, deleted
+}
+
+// Write prints the change set in a terraform plan like format:
+//
+//   - new_flag
+//     enabled: on
+//     ~ dark_mode
+//     enabled: off -> on
+//   - old_flag
+func (c ChangeSet) Write(w io.Writer, indent string) {
+	symbols := map[ChangeKind]string{ChangeCreate: "+", ChangeUpdate: "~", ChangeDelete: "-"}
+
+	for _, change := range c {
+		fmt.Fprintf(w, "%s%s %s\n", indent, symbols[change.Kind], change.Flag)
+		for _, field := range change.Fields {
+			switch change.Kind {
+			case ChangeCreate:
+				fmt.Fprintf(w, "%s    %s: %s\n", indent, field.Field, formatFieldValue(field.After))
+			case ChangeDelete:
+				fmt.Fprintf(w, "%s    %s: %s\n", indent, field.Field, formatFieldValue(field.Before))
+			default:
+				fmt.Fprintf(w, "%s    %s: %s -> %s\n", indent, field.Field, formatFieldValue(field.Before), formatFieldValue(field.After))
+			}
+		}
+	}
+}
+
+func (c ChangeSet) String() string {
+	var b strings.Builder
+	c.Write(&b, "")
+	return b.String()
+}
+
+func formatFieldValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "(none)"
+	case bool:
+		if v {
+			return "on"
+		}
+		return "off"
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []string:
+		return "[" + strings.Join(v, ", ") + "]"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,133 @@
+package appconfig_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+)
+
+func TestDiffDocuments(t *testing.T) {
+	before := &appconfig.FlagDocument{
+		Version: "1",
+		Flags: map[string]appconfig.FlagDefinition{
+			"dark_mode":    {Name: "Dark mode"},
+			"new_checkout": {Name: "New checkout"},
+			"old_banner":   {Name: "Old banner"},
+		},
+		Values: map[string]appconfig.FlagValue{
+			"dark_mode":  {"enabled": false},
+			"old_banner": {"enabled": true},
+		},
+	}
+	after := &appconfig.FlagDocument{
+		Version: "1",
+		Flags: map[string]appconfig.FlagDefinition{
+			"dark_mode":    {Name: "Dark mode"},
+			"new_checkout": {Name: "New checkout", Description: "v2 checkout"},
+			"beta_feature": {Name: "Beta"},
+		},
+		Values: map[string]appconfig.FlagValue{
+			"dark_mode":    {"enabled": true},
+			"beta_feature": {"enabled": true},
+		},
+	}
+
+	changes := appconfig.DiffDocuments(before, after)
+
+	expected := strings.Join([]string{
+		"+ beta_feature",
+		"    enabled: on",
+		"~ dark_mode",
+		"    enabled: off -> on",
+		`~ new_checkout`,
+		`    description: "" -> "v2 checkout"`,
+		"- old_banner",
+		"    enabled: on",
+		"",
+	}, "\n")
+	if changes.String() != expected {
+		t.Errorf("result: \n%v, expected \n%v", changes.String(), expected)
+	}
+
+	created, updated, deleted := changes.Counts()
+	if created != 1 || updated != 2 || deleted != 1 {
+		t.Errorf("counts: %d/%d/%d, expected 1/2/1", created, updated, deleted)
+	}
+}
+
+func TestFlagDocumentValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     appconfig.FlagDocument
+		wantErr string
+	}{
+		{
+			name: "should accept valid document",
+			doc: appconfig.FlagDocument{
+				Version: "1",
+				Flags: map[string]appconfig.FlagDefinition{
+					"dark_mode": {
+						Name: "Dark mode",
+						Attributes: map[string]map[string]any{
+							"color": {"constraints": map[string]any{"type": "string"}},
+						},
+					},
+				},
+				Values: map[string]appconfig.FlagValue{
+					"dark_mode": {"enabled": true, "color": "blue"},
+				},
+			},
+		},
+		{
+			name: "should reject value without definition",
+			doc: appconfig.FlagDocument{
+				Version: "1",
+				Flags:   map[string]appconfig.FlagDefinition{},
+				Values:  map[string]appconfig.FlagValue{"dark_mode": {"enabled": true}},
+			},
+			wantErr: `flag "dark_mode": has a value but no definition`,
+		},
+		{
+			name: "should reject invalid key",
+			doc: appconfig.FlagDocument{
+				Version: "1",
+				Flags:   map[string]appconfig.FlagDefinition{"1dark": {Name: "Dark"}},
+			},
+			wantErr: `flag "1dark": key must start with a letter`,
+		},
+		{
+			name: "should reject attribute of wrong type",
+			doc: appconfig.FlagDocument{
+				Version: "1",
+				Flags: map[string]appconfig.FlagDefinition{
+					"dark_mode": {
+						Name: "Dark mode",
+						Attributes: map[string]map[string]any{
+							"color": {"constraints": map[string]any{"type": "string"}},
+						},
+					},
+				},
+				Values: map[string]appconfig.FlagValue{
+					"dark_mode": {"enabled": true, "color": 3.0},
+				},
+			},
+			wantErr: `flag "dark_mode": attribute "color": expected string`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.doc.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error: %v, expected %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package appconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// FlagDocument is the full content of a hosted AWS.AppConfig.FeatureFlags
+// configuration version. Flags holds the definitions shared by every
+// environment, Values the per-version flag state and attribute values.
+//
+// The data plane (GetLatestConfiguration) only returns the Values part,
+// which is what Flags decodes.
+type FlagDocument struct {
+	Flags   map[string]FlagDefinition `json:"flags" yaml:"flags"`
+	Values  map[string]FlagValue      `json:"values" yaml:"values"`
+	Version string                    `json:"version" yaml:"version"`
+}
+
+type FlagDefinition struct {
+	Name        string                    `json:"name" yaml:"name"`
+	Description string                    `json:"description,omitempty" yaml:"description,omitempty"`
+	Attributes  map[string]map[string]any `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+	Deprecation map[string]any            `json:"_deprecation,omitempty" yaml:"_deprecation,omitempty"`
+}
+
+// FlagValue holds "enabled" plus any attribute values of a flag.
+type FlagValue map[string]any
+
+func (v FlagValue) Enabled() bool {
+	enabled, _ := v["enabled"].(bool)
+	return enabled
+}
+
+func ParseFlagDocument(content []byte) (*FlagDocument, error) {
+	var doc FlagDocument
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal flag document: %w", err)
+	}
+	if doc.Flags == nil {
+		doc.Flags = make(map[string]FlagDefinition)
+	}
+	if doc.Values == nil {
+		doc.Values = make(map[string]FlagValue)
+	}
+	if doc.Version == "" {
+		doc.Version = "1"
+	}
+	return &doc, nil
+}
+
+// Clone returns a deep copy so a document can be modified without touching
+// the version it was read from.
+func (d *FlagDocument) Clone() *FlagDocument {
+	data, err := json.Marshal(d)
+	if err != nil {
+		panic(err)
+	}
+	clone, err := ParseFlagDocument(data)
+	if err != nil {
+		panic(err)
+	}
+	return clone
+}
+
+// SetEnabled changes the state of an existing flag, keeping its attributes.
+func (d *FlagDocument) SetEnabled(flagName string, enabled bool) error {
+	if _, ok := d.Flags[flagName]; !ok {
+		return fmt.Errorf("flag %q is not defined", flagName)
+	}
+
+	value := FlagValue{}
+	for k, v := range d.Values[flagName] {
+		value[k] = v
+	}
+	value["enabled"] = enabled
+	d.Values[flagName] = value
+	return nil
+}
+
+// ToFlags converts the document to the shape the data plane returns.
+func (d *FlagDocument) ToFlags() Flags {
+	flags := make(Flags, len(d.Flags))
+	for name := range d.Flags {
+		flags[name] = Flag{Enabled: d.Values[name].Enabled()}
+	}
+	return flags
+}
+
+func (d *FlagDocument) Marshal() ([]byte, error) {
+	content, err := json.Marshal(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal flag document: %w", err)
+	}
+	return content, nil
+}
+
+// same rule the AppConfig console applies to flag and attribute keys
+var flagKeyPattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{0,63}$`)
+
+// Validate checks the document against the AWS.AppConfig.FeatureFlags
+// format, so mistakes are reported before a version is created.
+func (d *FlagDocument) Validate() error {
+	var errs []error
+
+	if d.Version != "1" {
+		errs = append(errs, fmt.Errorf("unsupported document version %q", d.Version))
+	}
+
+	for _, name := range sortedKeys(d.Flags) {
+		def := d.Flags[name]
+		if !flagKeyPattern.MatchString(name) {
+			errs = append(errs, fmt.Errorf("flag %q: key must start with a letter and only contain letters, numbers, _ or -", name))
+		}
+		if def.Name == "" {
+			errs = append(errs, fmt.Errorf("flag %q: name is required", name))
+		}
+		for attrName := range def.Attributes {
+			if !flagKeyPattern.MatchString(attrName) {
+				errs = append(errs, fmt.Errorf("flag %q: invalid attribute key %q", name, attrName))
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(d.Values) {
+		def, ok := d.Flags[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("flag %q: has a value but no definition", name))
+			continue
+		}
+		for key, value := range d.Values[name] {
+			if key == "enabled" {
+				if _, ok := value.(bool); !ok {
+					errs = append(errs, fmt.Errorf("flag %q: enabled must be true or false", name))
+				}
+				continue
+			}
+			attr, ok := def.Attributes[key]
+			if !ok {
+				errs = append(errs, fmt.Errorf("flag %q: value for undefined attribute %q", name, key))
+				continue
+			}
+			if err := checkAttributeType(attr, value); err != nil {
+				errs = append(errs, fmt.Errorf("flag %q: attribute %q: %w", name, key, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func checkAttributeType(attr map[string]any, value any) error {
+	constraints, _ := attr["constraints"].(map[string]any)
+	want, _ := constraints["type"].(string)
+
+	var ok bool
+	switch want {
+	case "":
+		return nil
+	case "string":
+		_, ok = value.(string)
+	case "boolean":
+		_, ok = value.(bool)
+	case "number":
+		switch value.(type) {
+		case float64, int, int64:
+			ok = true
+		}
+	case "string[]", "number[]":
+		_, ok = value.([]any)
+	default:
+		return nil
+	}
+
+	if !ok {
+		return fmt.Errorf("expected %s, got %v", want, value)
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
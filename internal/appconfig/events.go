@@ -0,0 +1,86 @@
+package appconfig
+
+import (
+	"context"
+	"time"
+)
+
+// Action is a change made through the Client
+type Action string
+
+const (
+	ActionCreateVersion   Action = "create_version"
+	ActionStartDeployment Action = "start_deployment"
+	ActionStopDeployment  Action = "stop_deployment"
+)
+
+// Event describes a change made through the Client, who made it and what
+// it changed
+type Event struct {
+	Time time.Time
+	// caller ARN, account and region of the credentials
+	Caller  string
+	Account string
+	Region  string
+
+	Action      Action
+	AppId       string
+	ConfigId    string
+	EnvId       string
+	Version     int32
+	Deployment  int32
+	Description string
+	// what the version changes compared with the version before it, or
+	// what the deployment changes compared with the version deployed before
+	Changes ChangeSet
+}
+
+// WithAudit calls record after every version created and deployment
+// started or stopped. Working out what changed takes extra calls, only
+// made with it.
+func WithAudit(record func(Event)) Option {
+	return func(c *Client) {
+		c.audit = record
+	}
+}
+
+// WithAuditClock replaces time.Now for stamping the events of WithAudit
+func WithAuditClock(now func() time.Time) Option {
+	return func(c *Client) {
+		c.auditNow = now
+	}
+}
+
+func (c *Client) emit(e Event) {
+	if c.audit == nil {
+		return
+	}
+	e.Time = c.auditNow()
+	e.Caller, e.Account, e.Region = c.caller, c.account, c.region
+	c.audit(e)
+}
+
+// versionChanges compares doc with the version before it, for versions
+// created without a known base
+func (c *Client) versionChanges(ctx context.Context, appId, configId string, version int32, doc *FlagDocument) ChangeSet {
+	var base *FlagDocument
+	if version > 1 {
+		// a previous version that can't be read counts as empty
+		base, _ = c.GetFlagDocument(ctx, appId, configId, version-1)
+	}
+	return DiffDocuments(base, doc)
+}
+
+// deploymentChanges compares a version with the version deployed to an
+// environment, before it is deployed
+func (c *Client) deploymentChanges(ctx context.Context, appId, configId, envId string, version int32) (ChangeSet, error) {
+	doc, err := c.GetFlagDocument(ctx, appId, configId, version)
+	if err != nil {
+		return nil, err
+	}
+	base, err := c.deployedDocument(ctx, appId, configId, envId)
+	if err != nil {
+		return nil, err
+	}
+	return DiffDocuments(base, doc), nil
+}
@@ -0,0 +1,97 @@
+package appconfig_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig/fake"
+)
+
+func TestWithAudit(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(ctx context.Context, client *appconfig.Client) error
+		expected []appconfig.Action
+	}{
+		{
+			name: "should record the version and deployment of a toggle",
+			change: func(ctx context.Context, client *appconfig.Client) error {
+				_, err := client.SetFlag(ctx, "wordle1", "webflg1", "pro0001", "dark_mode", true, "")
+				return err
+			},
+			expected: []appconfig.Action{appconfig.ActionCreateVersion, appconfig.ActionStartDeployment},
+		},
+		{
+			name: "should record a proposal as a version only",
+			change: func(ctx context.Context, client *appconfig.Client) error {
+				_, err := client.ProposeFlag(ctx, "wordle1", "webflg1", "pro0001", "dark_mode", true)
+				return err
+			},
+			expected: []appconfig.Action{appconfig.ActionCreateVersion},
+		},
+		{
+			name: "should record a deployment of an existing version",
+			change: func(ctx context.Context, client *appconfig.Client) error {
+				deployed, err := client.DeployedVersion(ctx, "wordle1", "webflg1", "pro0001")
+				if err != nil {
+					return err
+				}
+				doc, err := client.GetFlagDocument(ctx, "wordle1", "webflg1", deployed.Version)
+				if err != nil {
+					return err
+				}
+				doc = doc.Clone()
+				if err := doc.SetEnabled("dark_mode", true); err != nil {
+					return err
+				}
+				version, err := client.CreateFlagVersion(ctx, "wordle1", "webflg1", doc, "", 0)
+				if err != nil {
+					return err
+				}
+				_, err = client.StartDeployment(ctx, "wordle1", "webflg1", "pro0001", version, "")
+				return err
+			},
+			expected: []appconfig.Action{appconfig.ActionCreateVersion, appconfig.ActionStartDeployment},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []appconfig.Event
+			backend := fake.NewBackend(fake.DemoData())
+			stamped := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+			client := appconfig.NewWithClients(backend, backend,
+				appconfig.WithCaller(alice),
+				appconfig.WithAudit(func(e appconfig.Event) { events = append(events, e) }),
+				appconfig.WithAuditClock(func() time.Time { return stamped }),
+			)
+
+			if err := tt.change(context.Background(), client); err != nil {
+				t.Fatalf("change: %v", err)
+			}
+
+			if len(events) != len(tt.expected) {
+				t.Fatalf("expected %d events, got %+v", len(tt.expected), events)
+			}
+			for i, e := range events {
+				if e.Action != tt.expected[i] {
+					t.Errorf("event %d: expected %s, got %s", i, tt.expected[i], e.Action)
+				}
+				if !e.Time.Equal(stamped) {
+					t.Errorf("event %d: expected time %s, got %s", i, stamped, e.Time)
+				}
+				if e.Caller != alice {
+					t.Errorf("event %d: expected caller %s, got %q", i, alice, e.Caller)
+				}
+				if e.Version == 0 {
+					t.Errorf("event %d: expected a version", i)
+				}
+				if len(e.Changes) != 1 || e.Changes[0].Flag != "dark_mode" {
+					t.Errorf("event %d: expected dark_mode to change, got %+v", i, e.Changes)
+				}
+			}
+		})
+	}
+}
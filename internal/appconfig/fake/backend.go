@@ -0,0 +1,805 @@
+// Package fake is an in-memory AppConfig and AppConfigData backend.
+// Backend implements appconfig.ConfigClient and appconfig.DataClient, so an
+// appconfig.Client can run against it in tests, and it is the store behind
+// the local server. Only the operations used by appconfig.Client exist.
+package fake
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/appconfig"
+	"github.com/aws/aws-sdk-go-v2/service/appconfig/types"
+	"github.com/aws/aws-sdk-go-v2/service/appconfigdata"
+	"github.com/aws/smithy-go"
+)
+
+const featureFlagsType = "AWS.AppConfig.FeatureFlags"
+
+// APIError mirrors the error responses of the AWS APIs. It satisfies
+// smithy.APIError so callers see the same error codes as against AWS.
+type APIError struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *APIError) ErrorCode() string    { return e.Code }
+func (e *APIError) ErrorMessage() string { return e.Message }
+func (e *APIError) HTTPStatusCode() int  { return e.Status }
+
+func (e *APIError) ErrorFault() smithy.ErrorFault {
+	if e.Status >= http.StatusInternalServerError {
+		return smithy.FaultServer
+	}
+	return smithy.FaultClient
+}
+
+func notFound(format string, args ...any) error {
+	return &APIError{Code: "ResourceNotFoundException", Message: fmt.Sprintf(format, args...), Status: http.StatusNotFound}
+}
+
+func badRequest(format string, args ...any) error {
+	return &APIError{Code: "BadRequestException", Message: fmt.Sprintf(format, args...), Status: http.StatusBadRequest}
+}
+
+func conflict(format string, args ...any) error {
+	return &APIError{Code: "ConflictException", Message: fmt.Sprintf(format, args...), Status: http.StatusConflict}
+}
+
+type Application struct {
+	Id           string         `json:"id"`
+	Name         string         `json:"name"`
+	Description  string         `json:"description,omitempty"`
+	Profiles     []*Profile     `json:"profiles"`
+	Environments []*Environment `json:"environments"`
+}
+
+type Profile struct {
+	Id       string           `json:"id"`
+	Name     string           `json:"name"`
+	Type     string           `json:"type"`
+	Versions []*HostedVersion `json:"versions"`
+}
+
+type HostedVersion struct {
+	Number      int32           `json:"number"`
+	ContentType string          `json:"contentType"`
+	Description string          `json:"description,omitempty"`
+	Content     json.RawMessage `json:"content"`
+}
+
+type Environment struct {
+	Id          string            `json:"id"`
+	Name        string            `json:"name"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Deployments []*Deployment     `json:"deployments"`
+}
+
+type Deployment struct {
+	Number      int32         `json:"number"`
+	ProfileId   string        `json:"profileId"`
+	Version     int32         `json:"version"`
+	StrategyId  string        `json:"strategyId"`
+	Description string        `json:"description,omitempty"`
+	StartedAt   time.Time     `json:"startedAt"`
+	Duration    time.Duration `json:"duration"`
+	Stopped     bool          `json:"stopped,omitempty"`
+	// when a stopped deployment is done rolling back
+	RolledBackAt time.Time `json:"rolledBackAt,omitzero"`
+}
+
+// deployment durations of the predefined strategies, unknown strategies
+// complete immediately
+var strategyDurations = map[string]time.Duration{
+	"AppConfig.AllAtOnce":                     0,
+	"AppConfig.Linear50PercentEvery30Seconds": time.Minute,
+	"AppConfig.Linear20PercentEvery6Minutes":  30 * time.Minute,
+	"AppConfig.Canary10Percent20Minutes":      20 * time.Minute,
+}
+
+func (d *Deployment) state(now time.Time) types.DeploymentState {
+	switch {
+	case d.Stopped && now.Before(d.RolledBackAt):
+		return types.DeploymentStateRollingBack
+	case d.Stopped:
+		return types.DeploymentStateRolledBack
+	case now.Before(d.StartedAt.Add(d.Duration)):
+		return types.DeploymentStateDeploying
+	default:
+		return types.DeploymentStateComplete
+	}
+}
+
+// session is an AppConfigData configuration session
+type session struct {
+	appId     string
+	profileId string
+	envId     string
+	interval  int32
+	// deployment number last returned, 0 before the first poll
+	served int32
+}
+
+// Backend holds all applications in memory. When opened from a path every
+// change is written back to that file.
+type Backend struct {
+	mu        sync.Mutex
+	path      string
+	apps      []*Application
+	sessions  map[string]*session
+	faults    []*Fault
+	calls     map[string]int
+	cancelled map[string]int
+	waiting   map[string]int
+
+	// Now is the clock deployments are timed with, overridable in tests
+	Now func() time.Time
+	// RollbackDuration is how long a stopped deployment is ROLLING_BACK
+	// before it is ROLLED_BACK, overridable in tests
+	RollbackDuration time.Duration
+}
+
+func NewBackend(apps []*Application) *Backend {
+	return &Backend{
+		apps:      apps,
+		sessions:  make(map[string]*session),
+		calls:     make(map[string]int),
+		cancelled: make(map[string]int),
+		waiting:   make(map[string]int),
+		Now:       time.Now,
+
+		RollbackDuration: 2 * time.Second,
+	}
+}
+
+// Open loads the backend from path, seeding it with demo data when the
+// file does not exist yet.
+func Open(path string) (*Backend, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		b := NewBackend(DemoData())
+		b.path = path
+		return b, b.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var apps []*Application
+	if err := json.Unmarshal(data, &apps); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	b := NewBackend(apps)
+	b.path = path
+	return b, nil
+}
+
+func (b *Backend) save() error {
+	if b.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(b.apps, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
+
+func newId() string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 7)
+	rand.Read(b)
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b)
+}
+
+func (b *Backend) app(ref string) (*Application, error) {
+	for _, app := range b.apps {
+		if app.Id == ref || app.Name == ref {
+			return app, nil
+		}
+	}
+	return nil, notFound("Application %s not found", ref)
+}
+
+func (b *Backend) profile(appRef, ref string) (*Application, *Profile, error) {
+	app, err := b.app(appRef)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range app.Profiles {
+		if p.Id == ref || p.Name == ref {
+			return app, p, nil
+		}
+	}
+	return nil, nil, notFound("ConfigurationProfile %s not found", ref)
+}
+
+func (b *Backend) environment(appRef, ref string) (*Application, *Environment, error) {
+	app, err := b.app(appRef)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, env := range app.Environments {
+		if env.Id == ref || env.Name == ref {
+			return app, env, nil
+		}
+	}
+	return nil, nil, notFound("Environment %s not found", ref)
+}
+
+func (p *Profile) version(number int32) *HostedVersion {
+	for _, v := range p.Versions {
+		if v.Number == number {
+			return v
+		}
+	}
+	return nil
+}
+
+// served returns the deployment whose configuration clients currently
+// receive: the most recent one that completed and was not stopped.
+func (env *Environment) served(profileId string, now time.Time) *Deployment {
+	for i := len(env.Deployments) - 1; i >= 0; i-- {
+		d := env.Deployments[i]
+		if d.ProfileId == profileId && d.state(now) == types.DeploymentStateComplete {
+			return d
+		}
+	}
+	return nil
+}
+
+func environmentState(env *Environment, now time.Time) types.EnvironmentState {
+	for _, d := range env.Deployments {
+		if d.state(now) == types.DeploymentStateDeploying {
+			return types.EnvironmentStateDeploying
+		}
+	}
+	if len(env.Deployments) > 0 && env.Deployments[len(env.Deployments)-1].Stopped {
+		return types.EnvironmentStateRolledBack
+	}
+	return types.EnvironmentStateReadyForDeployment
+}
+
+// ----------------------------------------------------------------------
+// AppConfig control plane
+
+func (b *Backend) ListApplications(ctx context.Context, in *appconfig.ListApplicationsInput, _ ...func(*appconfig.Options)) (*appconfig.ListApplicationsOutput, error) {
+	if err := b.before(ctx, "ListApplications", "", ""); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := &appconfig.ListApplicationsOutput{}
+	for _, app := range b.apps {
+		out.Items = append(out.Items, types.Application{
+			Id:          aws.String(app.Id),
+			Name:        aws.String(app.Name),
+			Description: aws.String(app.Description),
+		})
+	}
+	return out, nil
+}
+
+func (b *Backend) ListConfigurationProfiles(ctx context.Context, in *appconfig.ListConfigurationProfilesInput, _ ...func(*appconfig.Options)) (*appconfig.ListConfigurationProfilesOutput, error) {
+	if err := b.before(ctx, "ListConfigurationProfiles", aws.ToString(in.ApplicationId), ""); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	app, err := b.app(aws.ToString(in.ApplicationId))
+	if err != nil {
+		return nil, err
+	}
+	out := &appconfig.ListConfigurationProfilesOutput{}
+	for _, p := range app.Profiles {
+		out.Items = append(out.Items, types.ConfigurationProfileSummary{
+			ApplicationId: aws.String(app.Id),
+			Id:            aws.String(p.Id),
+			Name:          aws.String(p.Name),
+			LocationUri:   aws.String("hosted"),
+			Type:          aws.String(p.Type),
+		})
+	}
+	return out, nil
+}
+
+func (b *Backend) GetConfigurationProfile(ctx context.Context, in *appconfig.GetConfigurationProfileInput, _ ...func(*appconfig.Options)) (*appconfig.GetConfigurationProfileOutput, error) {
+	if err := b.before(ctx, "GetConfigurationProfile", aws.ToString(in.ApplicationId), ""); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	app, p, err := b.profile(aws.ToString(in.ApplicationId), aws.ToString(in.ConfigurationProfileId))
+	if err != nil {
+		return nil, err
+	}
+	return &appconfig.GetConfigurationProfileOutput{
+		ApplicationId: aws.String(app.Id),
+		Id:            aws.String(p.Id),
+		Name:          aws.String(p.Name),
+		LocationUri:   aws.String("hosted"),
+		Type:          aws.String(p.Type),
+	}, nil
+}
+
+func (b *Backend) ListEnvironments(ctx context.Context, in *appconfig.ListEnvironmentsInput, _ ...func(*appconfig.Options)) (*appconfig.ListEnvironmentsOutput, error) {
+	if err := b.before(ctx, "ListEnvironments", aws.ToString(in.ApplicationId), ""); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	app, err := b.app(aws.ToString(in.ApplicationId))
+	if err != nil {
+		return nil, err
+	}
+	now := b.Now()
+	out := &appconfig.ListEnvironmentsOutput{}
+	for _, env := range app.Environments {
+		out.Items = append(out.Items, types.Environment{
+			ApplicationId: aws.String(app.Id),
+			Id:            aws.String(env.Id),
+			Name:          aws.String(env.Name),
+			State:         environmentState(env, now),
+		})
+	}
+	return out, nil
+}
+
+func (b *Backend) ListHostedConfigurationVersions(ctx context.Context, in *appconfig.ListHostedConfigurationVersionsInput, _ ...func(*appconfig.Options)) (*appconfig.ListHostedConfigurationVersionsOutput, error) {
+	if err := b.before(ctx, "ListHostedConfigurationVersions", aws.ToString(in.ApplicationId), ""); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	app, p, err := b.profile(aws.ToString(in.ApplicationId), aws.ToString(in.ConfigurationProfileId))
+	if err != nil {
+		return nil, err
+	}
+
+	// most recent first, like AWS
+	out := &appconfig.ListHostedConfigurationVersionsOutput{}
+	for i := len(p.Versions) - 1; i >= 0; i-- {
+		if in.MaxResults != nil && len(out.Items) >= int(*in.MaxResults) {
+			break
+		}
+		v := p.Versions[i]
+		out.Items = append(out.Items, types.HostedConfigurationVersionSummary{
+			ApplicationId:          aws.String(app.Id),
+			ConfigurationProfileId: aws.String(p.Id),
+			ContentType:            aws.String(v.ContentType),
+			Description:            aws.String(v.Description),
+			VersionNumber:          v.Number,
+		})
+	}
+	return out, nil
+}
+
+func (b *Backend) GetHostedConfigurationVersion(ctx context.Context, in *appconfig.GetHostedConfigurationVersionInput, _ ...func(*appconfig.Options)) (*appconfig.GetHostedConfigurationVersionOutput, error) {
+	if err := b.before(ctx, "GetHostedConfigurationVersion", aws.ToString(in.ApplicationId), ""); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	app, p, err := b.profile(aws.ToString(in.ApplicationId), aws.ToString(in.ConfigurationProfileId))
+	if err != nil {
+		return nil, err
+	}
+	v := p.version(aws.ToInt32(in.VersionNumber))
+	if v == nil {
+		return nil, notFound("HostedConfigurationVersion %d not found", aws.ToInt32(in.VersionNumber))
+	}
+	return &appconfig.GetHostedConfigurationVersionOutput{
+		ApplicationId:          aws.String(app.Id),
+		ConfigurationProfileId: aws.String(p.Id),
+		Content:                v.Content,
+		ContentType:            aws.String(v.ContentType),
+		Description:            aws.String(v.Description),
+		VersionNumber:          v.Number,
+	}, nil
+}
+
+func (b *Backend) CreateHostedConfigurationVersion(ctx context.Context, in *appconfig.CreateHostedConfigurationVersionInput, _ ...func(*appconfig.Options)) (*appconfig.CreateHostedConfigurationVersionOutput, error) {
+	if err := b.before(ctx, "CreateHostedConfigurationVersion", aws.ToString(in.ApplicationId), ""); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	app, p, err := b.profile(aws.ToString(in.ApplicationId), aws.ToString(in.ConfigurationProfileId))
+	if err != nil {
+		return nil, err
+	}
+
+	var latest int32
+	if len(p.Versions) > 0 {
+		latest = p.Versions[len(p.Versions)-1].Number
+	}
+	if in.LatestVersionNumber != nil && *in.LatestVersionNumber != latest {
+		return nil, conflict("latest version is %d, not %d", latest, *in.LatestVersionNumber)
+	}
+	if p.Type == featureFlagsType && !json.Valid(in.Content) {
+		return nil, badRequest("content is not valid JSON")
+	}
+
+	v := &HostedVersion{
+		Number:      latest + 1,
+		ContentType: aws.ToString(in.ContentType),
+		Description: aws.ToString(in.Description),
+		Content:     append(json.RawMessage{}, in.Content...),
+	}
+	p.Versions = append(p.Versions, v)
+	if err := b.save(); err != nil {
+		return nil, err
+	}
+
+	return &appconfig.CreateHostedConfigurationVersionOutput{
+		ApplicationId:          aws.String(app.Id),
+		ConfigurationProfileId: aws.String(p.Id),
+		Content:                v.Content,
+		ContentType:            aws.String(v.ContentType),
+		Description:            aws.String(v.Description),
+		VersionNumber:          v.Number,
+	}, nil
+}
+
+func (b *Backend) deploymentOutput(app *Application, env *Environment, d *Deployment) *appconfig.GetDeploymentOutput {
+	now := b.Now()
+	state := d.state(now)
+
+	percentage := float32(100)
+	var completedAt *time.Time
+	if state == types.DeploymentStateDeploying {
+		percentage = float32(now.Sub(d.StartedAt)) / float32(d.Duration) * 100
+	} else {
+		completedAt = aws.Time(d.StartedAt.Add(d.Duration))
+	}
+
+	var profileName string
+	for _, p := range app.Profiles {
+		if p.Id == d.ProfileId {
+			profileName = p.Name
+		}
+	}
+
+	return &appconfig.GetDeploymentOutput{
+		ApplicationId:               aws.String(app.Id),
+		EnvironmentId:               aws.String(env.Id),
+		ConfigurationProfileId:      aws.String(d.ProfileId),
+		ConfigurationName:           aws.String(profileName),
+		ConfigurationVersion:        aws.String(strconv.Itoa(int(d.Version))),
+		DeploymentNumber:            d.Number,
+		DeploymentStrategyId:        aws.String(d.StrategyId),
+		DeploymentDurationInMinutes: int32(d.Duration / time.Minute),
+		Description:                 aws.String(d.Description),
+		State:                       state,
+		PercentageComplete:          aws.Float32(percentage),
+		StartedAt:                   aws.Time(d.StartedAt),
+		CompletedAt:                 completedAt,
+	}
+}
+
+func (b *Backend) ListDeployments(ctx context.Context, in *appconfig.ListDeploymentsInput, _ ...func(*appconfig.Options)) (*appconfig.ListDeploymentsOutput, error) {
+	if err := b.before(ctx, "ListDeployments", aws.ToString(in.ApplicationId), aws.ToString(in.EnvironmentId)); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	app, env, err := b.environment(aws.ToString(in.ApplicationId), aws.ToString(in.EnvironmentId))
+	if err != nil {
+		return nil, err
+	}
+
+	// the token is how many deployments earlier pages listed
+	start := 0
+	if in.NextToken != nil {
+		start, err = strconv.Atoi(*in.NextToken)
+		if err != nil || start < 0 {
+			return nil, badRequest("invalid NextToken %q", *in.NextToken)
+		}
+	}
+
+	// most recent first, like AWS
+	out := &appconfig.ListDeploymentsOutput{}
+	for i := len(env.Deployments) - 1 - start; i >= 0; i-- {
+		if in.MaxResults != nil && len(out.Items) >= int(*in.MaxResults) {
+			out.NextToken = aws.String(strconv.Itoa(start + len(out.Items)))
+			break
+		}
+		d := b.deploymentOutput(app, env, env.Deployments[i])
+		out.Items = append(out.Items, types.DeploymentSummary{
+			ConfigurationName:           d.ConfigurationName,
+			ConfigurationVersion:        d.ConfigurationVersion,
+			DeploymentNumber:            d.DeploymentNumber,
+			DeploymentDurationInMinutes: d.DeploymentDurationInMinutes,
+			State:                       d.State,
+			PercentageComplete:          d.PercentageComplete,
+			StartedAt:                   d.StartedAt,
+			CompletedAt:                 d.CompletedAt,
+		})
+	}
+	return out, nil
+}
+
+func (b *Backend) GetDeployment(ctx context.Context, in *appconfig.GetDeploymentInput, _ ...func(*appconfig.Options)) (*appconfig.GetDeploymentOutput, error) {
+	if err := b.before(ctx, "GetDeployment", aws.ToString(in.ApplicationId), aws.ToString(in.EnvironmentId)); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	app, env, err := b.environment(aws.ToString(in.ApplicationId), aws.ToString(in.EnvironmentId))
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range env.Deployments {
+		if d.Number == aws.ToInt32(in.DeploymentNumber) {
+			return b.deploymentOutput(app, env, d), nil
+		}
+	}
+	return nil, notFound("Deployment %d not found", aws.ToInt32(in.DeploymentNumber))
+}
+
+func (b *Backend) StartDeployment(ctx context.Context, in *appconfig.StartDeploymentInput, _ ...func(*appconfig.Options)) (*appconfig.StartDeploymentOutput, error) {
+	if err := b.before(ctx, "StartDeployment", aws.ToString(in.ApplicationId), aws.ToString(in.EnvironmentId)); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	app, env, err := b.environment(aws.ToString(in.ApplicationId), aws.ToString(in.EnvironmentId))
+	if err != nil {
+		return nil, err
+	}
+	_, p, err := b.profile(app.Id, aws.ToString(in.ConfigurationProfileId))
+	if err != nil {
+		return nil, err
+	}
+	version, err := strconv.ParseInt(aws.ToString(in.ConfigurationVersion), 10, 32)
+	if err != nil || p.version(int32(version)) == nil {
+		return nil, badRequest("ConfigurationVersion %s not found", aws.ToString(in.ConfigurationVersion))
+	}
+
+	// like AWS, one deployment at a time per environment, rolling back
+	// counts
+	now := b.Now()
+	for _, d := range env.Deployments {
+		switch d.state(now) {
+		case types.DeploymentStateDeploying:
+			return nil, conflict("deployment %d is already in progress in environment %s", d.Number, env.Name)
+		case types.DeploymentStateRollingBack:
+			return nil, conflict("deployment %d is still rolling back in environment %s", d.Number, env.Name)
+		}
+	}
+
+	strategyId := aws.ToString(in.DeploymentStrategyId)
+	d := &Deployment{
+		Number:      int32(len(env.Deployments) + 1),
+		ProfileId:   p.Id,
+		Version:     int32(version),
+		StrategyId:  strategyId,
+		Description: aws.ToString(in.Description),
+		StartedAt:   now,
+		Duration:    strategyDurations[strategyId],
+	}
+	env.Deployments = append(env.Deployments, d)
+	if err := b.save(); err != nil {
+		return nil, err
+	}
+
+	// the deployment outputs only differ in name
+	out := appconfig.StartDeploymentOutput(*b.deploymentOutput(app, env, d))
+	return &out, nil
+}
+
+func (b *Backend) StopDeployment(ctx context.Context, in *appconfig.StopDeploymentInput, _ ...func(*appconfig.Options)) (*appconfig.StopDeploymentOutput, error) {
+	if err := b.before(ctx, "StopDeployment", aws.ToString(in.ApplicationId), aws.ToString(in.EnvironmentId)); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	app, env, err := b.environment(aws.ToString(in.ApplicationId), aws.ToString(in.EnvironmentId))
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range env.Deployments {
+		if d.Number != aws.ToInt32(in.DeploymentNumber) {
+			continue
+		}
+		now := b.Now()
+		if d.state(now) != types.DeploymentStateDeploying {
+			return nil, badRequest("deployment %d is not in progress", d.Number)
+		}
+		d.Stopped = true
+		d.RolledBackAt = now.Add(b.RollbackDuration)
+		if err := b.save(); err != nil {
+			return nil, err
+		}
+		out := appconfig.StopDeploymentOutput(*b.deploymentOutput(app, env, d))
+		return &out, nil
+	}
+	return nil, notFound("Deployment %d not found", aws.ToInt32(in.DeploymentNumber))
+}
+
+func (b *Backend) ListTagsForResource(ctx context.Context, in *appconfig.ListTagsForResourceInput, _ ...func(*appconfig.Options)) (*appconfig.ListTagsForResourceOutput, error) {
+	if err := b.before(ctx, "ListTagsForResource", "", ""); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the caller's account and region aren't known, any match
+	arn := aws.ToString(in.ResourceArn)
+	for _, app := range b.apps {
+		for _, env := range app.Environments {
+			if strings.HasSuffix(arn, fmt.Sprintf(":application/%s/environment/%s", app.Id, env.Id)) {
+				return &appconfig.ListTagsForResourceOutput{Tags: env.Tags}, nil
+			}
+		}
+	}
+	return &appconfig.ListTagsForResourceOutput{Tags: map[string]string{}}, nil
+}
+
+// EnvironmentArn is the ARN the local server uses for environments
+func EnvironmentArn(appId, envId string) string {
+	return fmt.Sprintf("arn:aws:appconfig:us-east-1:000000000000:application/%s/environment/%s", appId, envId)
+}
+
+// ----------------------------------------------------------------------
+// AppConfigData
+
+func (b *Backend) newToken(sess *session) string {
+	token := newId() + newId() + newId()
+	b.sessions[token] = sess
+	return token
+}
+
+func (b *Backend) StartConfigurationSession(ctx context.Context, in *appconfigdata.StartConfigurationSessionInput, _ ...func(*appconfigdata.Options)) (*appconfigdata.StartConfigurationSessionOutput, error) {
+	if err := b.before(ctx, "StartConfigurationSession", aws.ToString(in.ApplicationIdentifier), aws.ToString(in.EnvironmentIdentifier)); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	app, p, err := b.profile(aws.ToString(in.ApplicationIdentifier), aws.ToString(in.ConfigurationProfileIdentifier))
+	if err != nil {
+		return nil, err
+	}
+	_, env, err := b.environment(app.Id, aws.ToString(in.EnvironmentIdentifier))
+	if err != nil {
+		return nil, err
+	}
+
+	interval := int32(60)
+	if in.RequiredMinimumPollIntervalInSeconds != nil {
+		interval = *in.RequiredMinimumPollIntervalInSeconds
+	}
+	token := b.newToken(&session{appId: app.Id, profileId: p.Id, envId: env.Id, interval: interval})
+
+	return &appconfigdata.StartConfigurationSessionOutput{InitialConfigurationToken: aws.String(token)}, nil
+}
+
+func (b *Backend) GetLatestConfiguration(ctx context.Context, in *appconfigdata.GetLatestConfigurationInput, _ ...func(*appconfigdata.Options)) (*appconfigdata.GetLatestConfigurationOutput, error) {
+	b.mu.Lock()
+	var appRef, envRef string
+	if sess, ok := b.sessions[aws.ToString(in.ConfigurationToken)]; ok {
+		appRef, envRef = sess.appId, sess.envId
+	}
+	b.mu.Unlock()
+	if err := b.before(ctx, "GetLatestConfiguration", appRef, envRef); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	token := aws.ToString(in.ConfigurationToken)
+	sess, ok := b.sessions[token]
+	if !ok {
+		return nil, badRequest("invalid or expired configuration token")
+	}
+	// tokens can only be used once
+	delete(b.sessions, token)
+
+	app, p, err := b.profile(sess.appId, sess.profileId)
+	if err != nil {
+		return nil, err
+	}
+	_, env, err := b.environment(app.Id, sess.envId)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &appconfigdata.GetLatestConfigurationOutput{
+		NextPollIntervalInSeconds: sess.interval,
+	}
+
+	d := env.served(p.Id, b.Now())
+	if d == nil {
+		return nil, notFound("no configuration has been deployed to %s", env.Name)
+	}
+	if d.Number != sess.served {
+		v := p.version(d.Version)
+		content, err := dataPlaneContent(p, v)
+		if err != nil {
+			return nil, err
+		}
+		out.Configuration = content
+		out.ContentType = aws.String(v.ContentType)
+		sess.served = d.Number
+	}
+
+	next := *sess
+	out.NextPollConfigurationToken = aws.String(b.newToken(&next))
+	return out, nil
+}
+
+// dataPlaneContent returns what AppConfigData serves for a version. For
+// feature flags that is the value of every defined flag, without the
+// definitions.
+func dataPlaneContent(p *Profile, v *HostedVersion) ([]byte, error) {
+	if p.Type != featureFlagsType {
+		return v.Content, nil
+	}
+
+	var doc struct {
+		Flags  map[string]json.RawMessage `json:"flags"`
+		Values map[string]map[string]any  `json:"values"`
+	}
+	if err := json.Unmarshal(v.Content, &doc); err != nil {
+		return nil, errors.New("stored feature flag document is not valid JSON")
+	}
+
+	values := make(map[string]map[string]any, len(doc.Flags))
+	for name := range doc.Flags {
+		value := map[string]any{"enabled": false}
+		for k, v := range doc.Values[name] {
+			value[k] = v
+		}
+		values[name] = value
+	}
+	return json.Marshal(values)
+}
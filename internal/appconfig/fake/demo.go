@@ -0,0 +1,84 @@
+package fake
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// demo flag values per environment, used to seed a new store
+var seedFlags = map[string]map[string]map[string]bool{
+	"WebFeatureFlags": {
+		"development": {"beta_feature": true, "dark_mode": true, "new_checkout": false},
+		"staging":     {"beta_feature": false, "dark_mode": true, "new_checkout": true},
+		"production":  {"beta_feature": false, "dark_mode": false, "new_checkout": false},
+	},
+	"APIFeatureFlags": {
+		"development": {"rate_limiting": true, "graphql_api": true},
+		"staging":     {"rate_limiting": true, "graphql_api": false},
+		"production":  {"rate_limiting": true, "graphql_api": false},
+	},
+}
+
+// DemoData returns a demo application with two feature flag profiles
+// deployed to development, staging and production.
+func DemoData() []*Application {
+	started := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	app := &Application{
+		Id:          "wordle1",
+		Name:        "Wordle",
+		Description: "Demo application served by the local AppConfig server",
+	}
+	for _, envName := range []string{"development", "staging", "production"} {
+		app.Environments = append(app.Environments, &Environment{
+			Id:   envName[:3] + "0001",
+			Name: envName,
+		})
+	}
+	app.Environments[2].Tags = map[string]string{"Protected": "true"}
+
+	for i, profileName := range []string{"WebFeatureFlags", "APIFeatureFlags"} {
+		profile := &Profile{
+			Id:   []string{"webflg1", "apiflg1"}[i],
+			Name: profileName,
+			Type: featureFlagsType,
+		}
+		app.Profiles = append(app.Profiles, profile)
+
+		for _, env := range app.Environments {
+			profile.Versions = append(profile.Versions, &HostedVersion{
+				Number:      int32(len(profile.Versions) + 1),
+				ContentType: "application/json",
+				Description: "seed " + env.Name,
+				Content:     seedDocument(seedFlags[profileName][env.Name]),
+			})
+			env.Deployments = append(env.Deployments, &Deployment{
+				Number:     int32(len(env.Deployments) + 1),
+				ProfileId:  profile.Id,
+				Version:    int32(len(profile.Versions)),
+				StrategyId: "AppConfig.AllAtOnce",
+				StartedAt:  started,
+			})
+		}
+	}
+
+	return []*Application{app}
+}
+
+func seedDocument(states map[string]bool) json.RawMessage {
+	doc := map[string]any{
+		"version": "1",
+		"flags":   map[string]any{},
+		"values":  map[string]any{},
+	}
+	for name, enabled := range states {
+		doc["flags"].(map[string]any)[name] = map[string]any{"name": name}
+		doc["values"].(map[string]any)[name] = map[string]any{"enabled": enabled}
+	}
+
+	content, err := json.Marshal(doc)
+	if err != nil {
+		panic(err)
+	}
+	return content
+}
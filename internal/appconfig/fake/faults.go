@@ -0,0 +1,161 @@
+package fake
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Errors AWS returns that callers are expected to handle
+var (
+	ErrThrottling = &APIError{
+		Code:    "ThrottlingException",
+		Message: "Rate exceeded",
+		Status:  http.StatusTooManyRequests,
+	}
+	ErrAccessDenied = &APIError{
+		Code:    "AccessDeniedException",
+		Message: "User is not authorized to perform this action",
+		Status:  http.StatusForbidden,
+	}
+	ErrInternal = &APIError{
+		Code:    "InternalServerException",
+		Message: "Internal server error",
+		Status:  http.StatusInternalServerError,
+	}
+)
+
+// Fault makes matching calls fail or slow down.
+type Fault struct {
+	// Operation name, e.g. "GetLatestConfiguration". Empty matches all.
+	Operation string
+	// Environment name or id. Empty matches all calls, including calls that
+	// are not about an environment.
+	Environment string
+	// Err is returned instead of calling the operation. When nil the call
+	// goes ahead after Delay.
+	Err error
+	// Delay before the call returns. A call whose context is done before
+	// the delay is over returns the context's error, so a long delay
+	// simulates a hanging request.
+	Delay time.Duration
+	// Wait blocks the call until the channel is closed, so a test can look
+	// at the state while the call is in flight. Like Delay it gives up when
+	// the call's context is done.
+	Wait <-chan struct{}
+	// Times the fault applies before it is removed, 0 means forever
+	Times int
+}
+
+// AddFault injects a fault into subsequent calls
+func (b *Backend) AddFault(f Fault) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.faults = append(b.faults, &f)
+}
+
+// ClearFaults removes all injected faults
+func (b *Backend) ClearFaults() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.faults = nil
+}
+
+// Calls returns how often an operation was called, including failed calls
+func (b *Backend) Calls(operation string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.calls[operation]
+}
+
+// Cancelled returns how often a call of an operation gave up because its
+// context was done while a fault delayed it
+func (b *Backend) Cancelled(operation string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.cancelled[operation]
+}
+
+// Waiting returns how many calls of an operation a Wait fault is holding
+// up right now
+func (b *Backend) Waiting(operation string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.waiting[operation]
+}
+
+// before records the call and applies the first matching fault. envRef is
+// the environment the call is about, if any.
+func (b *Backend) before(ctx context.Context, operation string, appRef string, envRef string) error {
+	b.mu.Lock()
+	b.calls[operation]++
+
+	var fault *Fault
+	for i, f := range b.faults {
+		if f.Operation != "" && f.Operation != operation {
+			continue
+		}
+		if f.Environment != "" && !b.sameEnvironment(appRef, envRef, f.Environment) {
+			continue
+		}
+		fault = f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				b.faults = append(b.faults[:i], b.faults[i+1:]...)
+			}
+		}
+		break
+	}
+	b.mu.Unlock()
+
+	if fault == nil {
+		return nil
+	}
+	if fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-ctx.Done():
+			return b.cancel(ctx, operation)
+		}
+	}
+	if fault.Wait != nil {
+		b.mu.Lock()
+		b.waiting[operation]++
+		b.mu.Unlock()
+		defer func() {
+			b.mu.Lock()
+			b.waiting[operation]--
+			b.mu.Unlock()
+		}()
+		select {
+		case <-fault.Wait:
+		case <-ctx.Done():
+			return b.cancel(ctx, operation)
+		}
+	}
+	return fault.Err
+}
+
+func (b *Backend) cancel(ctx context.Context, operation string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cancelled[operation]++
+	return ctx.Err()
+}
+
+// sameEnvironment reports whether two references, names or ids, point to
+// the same environment. Must be called with b.mu held.
+func (b *Backend) sameEnvironment(appRef, ref, other string) bool {
+	if ref == "" {
+		return false
+	}
+	if ref == other {
+		return true
+	}
+	_, env, err := b.environment(appRef, ref)
+	if err != nil {
+		return false
+	}
+	return env.Id == other || env.Name == other
+}
@@ -0,0 +1,180 @@
+package fake_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig/fake"
+)
+
+// listDeployments calls an operation about an environment
+func listDeployments(ctx context.Context, b *fake.Backend, envRef string) error {
+	_, err := b.ListDeployments(ctx, &appconfig.ListDeploymentsInput{ApplicationId: aws.String("wordle1"), EnvironmentId: aws.String(envRef)})
+	return err
+}
+
+// listApplications calls an operation about no environment
+func listApplications(ctx context.Context, b *fake.Backend) error {
+	_, err := b.ListApplications(ctx, &appconfig.ListApplicationsInput{})
+	return err
+}
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault fake.Fault
+		call  func(ctx context.Context, b *fake.Backend) error
+		// errors of each call, in order
+		expected []error
+	}{
+		{
+			name:     "should fail every call of the operation",
+			fault:    fake.Fault{Operation: "ListApplications", Err: fake.ErrThrottling},
+			call:     listApplications,
+			expected: []error{fake.ErrThrottling, fake.ErrThrottling, fake.ErrThrottling},
+		},
+		{
+			name:     "should not fail another operation",
+			fault:    fake.Fault{Operation: "ListEnvironments", Err: fake.ErrThrottling},
+			call:     listApplications,
+			expected: []error{nil, nil},
+		},
+		{
+			name:     "should fail as many times as asked",
+			fault:    fake.Fault{Operation: "ListApplications", Err: fake.ErrInternal, Times: 2},
+			call:     listApplications,
+			expected: []error{fake.ErrInternal, fake.ErrInternal, nil, nil},
+		},
+		{
+			name:  "should match an environment by name when called by id",
+			fault: fake.Fault{Environment: "staging", Err: fake.ErrAccessDenied},
+			call: func(ctx context.Context, b *fake.Backend) error {
+				return listDeployments(ctx, b, "sta0001")
+			},
+			expected: []error{fake.ErrAccessDenied},
+		},
+		{
+			name:  "should match an environment by id when called by name",
+			fault: fake.Fault{Environment: "sta0001", Err: fake.ErrAccessDenied},
+			call: func(ctx context.Context, b *fake.Backend) error {
+				return listDeployments(ctx, b, "staging")
+			},
+			expected: []error{fake.ErrAccessDenied},
+		},
+		{
+			name:  "should not fail calls about another environment",
+			fault: fake.Fault{Environment: "staging", Err: fake.ErrAccessDenied},
+			call: func(ctx context.Context, b *fake.Backend) error {
+				return listDeployments(ctx, b, "pro0001")
+			},
+			expected: []error{nil},
+		},
+		{
+			name:     "should not fail calls about no environment",
+			fault:    fake.Fault{Environment: "staging", Err: fake.ErrAccessDenied},
+			call:     listApplications,
+			expected: []error{nil},
+		},
+		{
+			name:     "should let a delayed call through",
+			fault:    fake.Fault{Operation: "ListApplications", Delay: time.Millisecond},
+			call:     listApplications,
+			expected: []error{nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			b := fake.NewBackend(fake.DemoData())
+			b.AddFault(tt.fault)
+
+			for i, expected := range tt.expected {
+				if err := tt.call(ctx, b); !errors.Is(err, expected) {
+					t.Errorf("call %d: expected %v, got %v", i, expected, err)
+				}
+			}
+			// failed calls count too
+			calls := b.Calls("ListApplications") + b.Calls("ListDeployments")
+			if calls != len(tt.expected) {
+				t.Errorf("expected %d calls, got %d", len(tt.expected), calls)
+			}
+			if cancelled := b.Cancelled("ListApplications") + b.Cancelled("ListDeployments"); cancelled != 0 {
+				t.Errorf("expected no cancelled calls, got %d", cancelled)
+			}
+		})
+	}
+}
+
+func TestFaultsFirstMatchApplies(t *testing.T) {
+	ctx := context.Background()
+	b := fake.NewBackend(fake.DemoData())
+	b.AddFault(fake.Fault{Operation: "ListApplications", Err: fake.ErrThrottling, Times: 1})
+	b.AddFault(fake.Fault{Err: fake.ErrInternal})
+
+	for i, expected := range []error{fake.ErrThrottling, fake.ErrInternal} {
+		if err := listApplications(ctx, b); !errors.Is(err, expected) {
+			t.Errorf("call %d: expected %v, got %v", i, expected, err)
+		}
+	}
+
+	b.ClearFaults()
+	if err := listApplications(ctx, b); err != nil {
+		t.Errorf("expected no fault after ClearFaults, got %v", err)
+	}
+	if calls := b.Calls("ListApplications"); calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestFaultsCancelled(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault fake.Fault
+	}{
+		{name: "should give up a delay when the context is done", fault: fake.Fault{Delay: time.Hour}},
+		{name: "should give up waiting when the context is done", fault: fake.Fault{Wait: make(chan struct{})}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := fake.NewBackend(fake.DemoData())
+			b.AddFault(tt.fault)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			if err := listApplications(ctx, b); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected the deadline to be exceeded, got %v", err)
+			}
+			if calls, cancelled := b.Calls("ListApplications"), b.Cancelled("ListApplications"); calls != 1 || cancelled != 1 {
+				t.Errorf("expected 1 call and 1 cancelled, got %d and %d", calls, cancelled)
+			}
+		})
+	}
+}
+
+func TestFaultsWait(t *testing.T) {
+	b := fake.NewBackend(fake.DemoData())
+	release := make(chan struct{})
+	b.AddFault(fake.Fault{Operation: "ListApplications", Err: fake.ErrInternal, Wait: release})
+
+	done := make(chan error)
+	go func() { done <- listApplications(context.Background(), b) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected the call to wait, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; !errors.Is(err, fake.ErrInternal) {
+		t.Errorf("expected the fault's error once released, got %v", err)
+	}
+	if cancelled := b.Cancelled("ListApplications"); cancelled != 0 {
+		t.Errorf("expected no cancelled calls, got %d", cancelled)
+	}
+}
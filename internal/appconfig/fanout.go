@@ -0,0 +1,107 @@
+package appconfig
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultMaxConcurrency is how many environments are fetched at once
+const DefaultMaxConcurrency = 4
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithMaxConcurrency limits how many flag fetches run at once across all
+// callers of the Client. Values below 1 are ignored.
+func WithMaxConcurrency(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.fanout.slots = make(chan struct{}, n)
+		}
+	}
+}
+
+// fanout bounds and coalesces flag fetches. Each one starts a data session
+// and is billed, so callers asking for the same environment at the same
+// time share one fetch.
+type fanout struct {
+	slots chan struct{}
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is a fetch shared by every caller waiting on it
+type flight struct {
+	done    chan struct{}
+	flags   Flags
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+func newFanout() *fanout {
+	return &fanout{
+		slots:   make(chan struct{}, DefaultMaxConcurrency),
+		flights: make(map[string]*flight),
+	}
+}
+
+// fetchFlags returns the flags of one environment, joining a fetch of the
+// same environment that is already in flight. The fetch is only cancelled
+// once every caller waiting on it has given up.
+func (c *Client) fetchFlags(ctx context.Context, appId, configId, envId string) (Flags, error) {
+	f := c.fanout
+	key := appId + ":" + configId + ":" + envId
+
+	f.mu.Lock()
+	fl, ok := f.flights[key]
+	if !ok {
+		// detached from the first caller, it may leave before the others
+		flightCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		fl = &flight{done: make(chan struct{}), cancel: cancel}
+		f.flights[key] = fl
+		go func() {
+			defer cancel()
+			fl.flags, fl.err = c.fetchFlagsLimited(flightCtx, appId, configId, envId)
+
+			f.mu.Lock()
+			if f.flights[key] == fl {
+				delete(f.flights, key)
+			}
+			f.mu.Unlock()
+			close(fl.done)
+		}()
+	}
+	fl.waiters++
+	f.mu.Unlock()
+
+	select {
+	case <-fl.done:
+		return fl.flags, fl.err
+	case <-ctx.Done():
+		f.mu.Lock()
+		fl.waiters--
+		if fl.waiters == 0 {
+			// nobody is left to use the result, later callers start afresh
+			fl.cancel()
+			if f.flights[key] == fl {
+				delete(f.flights, key)
+			}
+		}
+		f.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// fetchFlagsLimited waits for a free slot before fetching
+func (c *Client) fetchFlagsLimited(ctx context.Context, appId, configId, envId string) (Flags, error) {
+	select {
+	case c.fanout.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-c.fanout.slots }()
+
+	return c.GetLatestFlagConfig(ctx, appId, configId, envId, 60)
+}
@@ -0,0 +1,189 @@
+package appconfig_test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/appconfigdata"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig/fake"
+)
+
+// countingData records how many sessions are being started at once
+type countingData struct {
+	*fake.Backend
+	inFlight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (d *countingData) StartConfigurationSession(ctx context.Context, in *appconfigdata.StartConfigurationSessionInput, optFns ...func(*appconfigdata.Options)) (*appconfigdata.StartConfigurationSessionOutput, error) {
+	n := d.inFlight.Add(1)
+	defer d.inFlight.Add(-1)
+	for {
+		peak := d.peak.Load()
+		if n <= peak || d.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	return d.Backend.StartConfigurationSession(ctx, in, optFns...)
+}
+
+func TestGetFlagsConcurrencyLimit(t *testing.T) {
+	apps := fake.DemoData()
+	for i := 0; i < 17; i++ {
+		apps[0].Environments = append(apps[0].Environments, &fake.Environment{
+			Id:   fmt.Sprintf("ext%04d", i),
+			Name: fmt.Sprintf("extra-%d", i),
+		})
+	}
+
+	for _, limit := range []int{1, 3, 8} {
+		t.Run(fmt.Sprintf("limit %d", limit), func(t *testing.T) {
+			backend := fake.NewBackend(apps)
+			data := &countingData{Backend: backend}
+			client := appconfig.NewWithClients(backend, data, appconfig.WithMaxConcurrency(limit))
+
+			results, err := client.GetFlags(context.Background(), "wordle1", "webflg1")
+			if err != nil {
+				t.Fatalf("GetFlags: %v", err)
+			}
+			if len(results) != 20 {
+				t.Fatalf("expected 20 results, got %d", len(results))
+			}
+			if peak := data.peak.Load(); peak != int32(limit) {
+				t.Errorf("expected at most %d fetches at once, peak was %d", limit, peak)
+			}
+			if calls := backend.Calls("StartConfigurationSession"); calls != 20 {
+				t.Errorf("expected one session per environment, got %d", calls)
+			}
+		})
+	}
+}
+
+func TestStreamFlagsBoundsGoroutines(t *testing.T) {
+	apps := fake.DemoData()
+	for i := 0; i < 97; i++ {
+		apps[0].Environments = append(apps[0].Environments, &fake.Environment{
+			Id:   fmt.Sprintf("ext%04d", i),
+			Name: fmt.Sprintf("extra-%d", i),
+		})
+	}
+	backend := fake.NewBackend(apps)
+	client := appconfig.NewWithClients(backend, backend, appconfig.WithMaxConcurrency(2))
+	envs, err := client.ListAppEnvironments(context.Background(), "wordle1")
+	if err != nil {
+		t.Fatalf("ListAppEnvironments: %v", err)
+	}
+
+	release := make(chan struct{})
+	backend.AddFault(fake.Fault{Operation: "StartConfigurationSession", Wait: release})
+	before := runtime.NumGoroutine()
+	results := client.StreamFlags(context.Background(), "wordle1", "webflg1", envs)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for backend.Waiting("StartConfigurationSession") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 2 fetches to start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// 2 workers and their fetches, and the goroutine closing the results
+	if started := runtime.NumGoroutine() - before; started > 5 {
+		t.Errorf("expected at most 5 goroutines for 100 environments, %d were started", started)
+	}
+
+	close(release)
+	n := 0
+	for range results {
+		n++
+	}
+	if n != 100 {
+		t.Errorf("expected 100 results, got %d", n)
+	}
+}
+
+func TestGetFlagsCoalescesConcurrentCalls(t *testing.T) {
+	client, backend := newFakeClient()
+	release := make(chan struct{})
+	backend.AddFault(fake.Fault{Operation: "StartConfigurationSession", Wait: release})
+
+	var wg sync.WaitGroup
+	results := make([][]appconfig.Result, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = client.GetFlags(context.Background(), "wordle1", "webflg1")
+		}(i)
+		// the first call must be in flight before the second one starts
+		waitFor(t, func() bool { return backend.Calls("ListEnvironments") == i+1 })
+	}
+	waitFor(t, func() bool { return backend.Calls("StartConfigurationSession") == 3 })
+	// give the second call time to join the fetches in flight
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls := backend.Calls("StartConfigurationSession"); calls != 3 {
+		t.Errorf("expected both calls to share 3 sessions, got %d", calls)
+	}
+	for i, result := range results {
+		if len(result) != 3 || result[2].Err != nil || result[2].Flags == nil {
+			t.Errorf("call %d: unexpected results %+v", i, result)
+		}
+	}
+}
+
+func TestGetFlagsCancelledCallerDoesNotCancelOthers(t *testing.T) {
+	client, backend := newFakeClient()
+	release := make(chan struct{})
+	backend.AddFault(fake.Fault{Operation: "StartConfigurationSession", Environment: "production", Wait: release})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan []appconfig.Result)
+	go func() {
+		results, _ := client.GetFlags(ctx, "wordle1", "webflg1")
+		first <- results
+	}()
+	waitFor(t, func() bool { return backend.Calls("StartConfigurationSession") == 3 })
+
+	second := make(chan []appconfig.Result)
+	go func() {
+		results, _ := client.GetFlags(context.Background(), "wordle1", "webflg1")
+		second <- results
+	}()
+	waitFor(t, func() bool { return backend.Calls("ListEnvironments") == 2 })
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if results := <-first; results[2].Err != context.Canceled {
+		t.Errorf("expected the cancelled caller to give up, got %v", results[2].Err)
+	}
+
+	close(release)
+	if results := <-second; results[2].Err != nil {
+		t.Errorf("expected the shared fetch to finish for the other caller, got %v", results[2].Err)
+	}
+	// development and staging come from the sessions of the first call
+	if calls := backend.Calls("GetLatestConfiguration"); calls != 3 {
+		t.Errorf("expected the second caller to join production and reuse the rest, got %d polls", calls)
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
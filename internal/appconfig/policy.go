@@ -0,0 +1,61 @@
+package appconfig
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// CallPolicy bounds how long a single AWS call may take and how it is
+// retried. Apply it to the aws.Config before passing it to New.
+type CallPolicy struct {
+	// Timeout of one call including its retries, 0 means no timeout
+	Timeout time.Duration
+	// MaxAttempts per call, including the first one
+	MaxAttempts int
+	// MaxBackoff caps the wait between two attempts
+	MaxBackoff time.Duration
+}
+
+var DefaultCallPolicy = CallPolicy{
+	Timeout:     15 * time.Second,
+	MaxAttempts: 5,
+	MaxBackoff:  10 * time.Second,
+}
+
+// Apply sets the policy on cfg. Retries use the SDK's adaptive mode, which
+// backs off and rate limits the client once AWS starts throttling.
+func (p CallPolicy) Apply(cfg *aws.Config) {
+	cfg.Retryer = func() aws.Retryer {
+		return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+			o.StandardOptions = append(o.StandardOptions, func(so *retry.StandardOptions) {
+				if p.MaxAttempts > 0 {
+					so.MaxAttempts = p.MaxAttempts
+				}
+				if p.MaxBackoff > 0 {
+					so.MaxBackoff = p.MaxBackoff
+				}
+			})
+		})
+	}
+
+	if p.Timeout > 0 {
+		cfg.APIOptions = append(cfg.APIOptions, callTimeout(p.Timeout))
+	}
+}
+
+// callTimeout runs first in every operation, so the deadline covers
+// retries, backoff and reading the response.
+func callTimeout(timeout time.Duration) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CallTimeout",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				ctx, cancel := context.WithTimeout(ctx, timeout)
+				defer cancel()
+				return next.HandleInitialize(ctx, in)
+			}), middleware.Before)
+	}
+}
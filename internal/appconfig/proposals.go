@@ -0,0 +1,258 @@
+package appconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/appconfig"
+)
+
+var (
+	ErrNotProposal   = errors.New("version is not a proposal")
+	ErrSelfApproval  = errors.New("a proposal can't be approved by who proposed it")
+	ErrStaleProposal = errors.New("another version was deployed since it was proposed, propose the change again")
+	ErrDeployed      = errors.New("proposal is already deployed")
+	// versions described like a proposal could name anyone as the proposer
+	ErrReservedDescription = fmt.Errorf("descriptions starting with %q are reserved for proposals", proposalPrefix)
+)
+
+type ProposalState string
+
+const (
+	// waiting for another user to approve it
+	ProposalPending ProposalState = "PENDING"
+	// approved and deployed
+	ProposalDeployed ProposalState = "DEPLOYED"
+	// another version was deployed to the environment since
+	ProposalStale ProposalState = "STALE"
+)
+
+// Proposal is a hosted version that is only deployed to its environment
+// once a second user approves it. Who proposed it, for which environment
+// and on top of which version is kept in its description.
+//
+// AppConfig doesn't record who created a version, so the proposer is only
+// what the description says. lazyflags refuses to write such descriptions
+// any other way, see IsReservedDescription, but anyone allowed to create
+// hosted versions through the AWS API can name someone else. The approval
+// keeps honest users honest, IAM decides who can deploy.
+type Proposal struct {
+	Version int32
+	EnvId   string
+	// version deployed to the environment when it was proposed, 0 for none
+	BaseVersion int32
+	// caller ARN of the user who proposed it
+	ProposedBy string
+	Summary    string
+	State      ProposalState
+}
+
+// proposalPrefix starts the description of every proposal, versions not
+// made by propose can't have it
+const proposalPrefix = "lazyflags proposal"
+
+var proposalDescription = regexp.MustCompile(`^` + proposalPrefix + ` for (\S+) on version (\d+) by (\S+): (.*)$`)
+
+// IsReservedDescription reports whether a version description is reserved
+// for proposals
+func IsReservedDescription(description string) bool {
+	return strings.HasPrefix(description, proposalPrefix)
+}
+
+func (p Proposal) description() string {
+	return fmt.Sprintf(proposalPrefix+" for %s on version %d by %s: %s", p.EnvId, p.BaseVersion, p.ProposedBy, p.Summary)
+}
+
+func parseProposal(version int32, description string) (Proposal, bool) {
+	match := proposalDescription.FindStringSubmatch(description)
+	if match == nil {
+		return Proposal{}, false
+	}
+	base, err := strconv.ParseInt(match[2], 10, 32)
+	if err != nil {
+		return Proposal{}, false
+	}
+	return Proposal{
+		Version:     version,
+		EnvId:       match[1],
+		BaseVersion: int32(base),
+		ProposedBy:  match[3],
+		Summary:     match[4],
+	}, true
+}
+
+// WithCaller sets the ARN of the caller, as returned by GetCallerIdentity.
+// Proposals are refused without one.
+func WithCaller(arn string) Option {
+	return func(c *Client) {
+		c.caller = arn
+	}
+}
+
+// ProposeFlag stores the version deployed to an environment with a flag
+// turned on or off as a proposal, nothing is deployed
+func (c *Client) ProposeFlag(ctx context.Context, appId, configId, envId, flagName string, enabled bool) (Proposal, error) {
+	baseVersion, base, doc, err := c.toggledDocument(ctx, appId, configId, envId, flagName, enabled)
+	if err != nil {
+		return Proposal{}, err
+	}
+	return c.propose(ctx, appId, configId, envId, baseVersion, base, doc, toggleSummary(flagName, enabled))
+}
+
+// Propose stores doc as a proposal for an environment on top of the version
+// deployed to it, nothing is deployed
+func (c *Client) Propose(ctx context.Context, appId, configId, envId string, doc *FlagDocument, summary string) (Proposal, error) {
+	deployed, err := c.DeployedVersion(ctx, appId, configId, envId)
+	if err != nil && !errors.Is(err, ErrNoDeployment) {
+		return Proposal{}, err
+	}
+	var base *FlagDocument
+	if deployed.Version > 0 {
+		if base, err = c.GetFlagDocument(ctx, appId, configId, deployed.Version); err != nil {
+			return Proposal{}, err
+		}
+	}
+	return c.propose(ctx, appId, configId, envId, deployed.Version, base, doc, summary)
+}
+
+func (c *Client) propose(ctx context.Context, appId, configId, envId string, baseVersion int32, base, doc *FlagDocument, summary string) (Proposal, error) {
+	if c.caller == "" {
+		return Proposal{}, errors.New("failed to propose: the caller's identity is unknown")
+	}
+
+	if err := c.checkChange(ctx, Change{AppId: appId, ConfigId: configId, EnvId: envId, Changes: DiffDocuments(base, doc)}); err != nil {
+		return Proposal{}, err
+	}
+
+	p := Proposal{EnvId: envId, BaseVersion: baseVersion, ProposedBy: c.caller, Summary: summary, State: ProposalPending}
+	if base == nil {
+		base = &FlagDocument{}
+	}
+	version, err := c.createFlagVersion(ctx, appId, configId, base, doc, p.description(), 0)
+	if err != nil {
+		return Proposal{}, err
+	}
+	p.Version = version
+	return p, nil
+}
+
+// ListProposals returns the proposals among the recent versions of a
+// configuration profile, most recent first
+func (c *Client) ListProposals(ctx context.Context, appId, configId string) ([]Proposal, error) {
+	versions, err := c.configClient.ListHostedConfigurationVersions(ctx, &appconfig.ListHostedConfigurationVersionsInput{
+		ApplicationId:          &appId,
+		ConfigurationProfileId: &configId,
+		MaxResults:             aws.Int32(50),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list hosted configuration versions: %w", err)
+	}
+
+	// every proposal of an environment is compared with the same deployments
+	deployments := map[string][]Deployment{}
+	var proposals []Proposal
+	for _, v := range versions.Items {
+		p, ok := parseProposal(v.VersionNumber, aws.ToString(v.Description))
+		if !ok {
+			continue
+		}
+		if _, ok := deployments[p.EnvId]; !ok {
+			if deployments[p.EnvId], err = c.deployments(ctx, appId, configId, p.EnvId); err != nil {
+				return nil, err
+			}
+		}
+		p.State = proposalState(p, deployments[p.EnvId])
+		proposals = append(proposals, p)
+	}
+	return proposals, nil
+}
+
+// GetProposal returns a proposal by its version number
+func (c *Client) GetProposal(ctx context.Context, appId, configId string, version int32) (Proposal, error) {
+	res, err := c.configClient.GetHostedConfigurationVersion(ctx, &appconfig.GetHostedConfigurationVersionInput{
+		ApplicationId:          &appId,
+		ConfigurationProfileId: &configId,
+		VersionNumber:          &version,
+	})
+	if err != nil {
+		return Proposal{}, fmt.Errorf("failed to get hosted configuration version %d: %w", version, err)
+	}
+	p, ok := parseProposal(version, aws.ToString(res.Description))
+	if !ok {
+		return Proposal{}, fmt.Errorf("version %d: %w", version, ErrNotProposal)
+	}
+
+	deployments, err := c.deployments(ctx, appId, configId, p.EnvId)
+	if err != nil {
+		return Proposal{}, err
+	}
+	p.State = proposalState(p, deployments)
+	return p, nil
+}
+
+func proposalState(p Proposal, deployments []Deployment) ProposalState {
+	for _, d := range deployments {
+		if d.Version == p.Version {
+			return ProposalDeployed
+		}
+	}
+	var deployed int32
+	if i := liveIndex(deployments); i >= 0 {
+		deployed = deployments[i].Version
+	}
+	if deployed != p.BaseVersion {
+		return ProposalStale
+	}
+	return ProposalPending
+}
+
+// ProposalChanges compares a proposal with the version it was proposed on
+func (c *Client) ProposalChanges(ctx context.Context, appId, configId string, p Proposal) (ChangeSet, error) {
+	var base *FlagDocument
+	if p.BaseVersion > 0 {
+		var err error
+		if base, err = c.GetFlagDocument(ctx, appId, configId, p.BaseVersion); err != nil {
+			return nil, err
+		}
+	}
+	proposed, err := c.GetFlagDocument(ctx, appId, configId, p.Version)
+	if err != nil {
+		return nil, err
+	}
+	return DiffDocuments(base, proposed), nil
+}
+
+// Approve deploys a pending proposal. The caller's ARN must not be the one
+// that proposed it, and nothing else may have been deployed to its
+// environment since. The ARN of an assumed role includes the session name,
+// under SSO the user's, so users sharing a role approve each other.
+// The deployment is checked like any other, see WithCheck.
+func (c *Client) Approve(ctx context.Context, appId, configId string, version int32, strategyId string) (Proposal, Deployment, error) {
+	p, err := c.GetProposal(ctx, appId, configId, version)
+	if err != nil {
+		return Proposal{}, Deployment{}, err
+	}
+
+	switch {
+	case c.caller == "":
+		return p, Deployment{}, errors.New("failed to approve: the caller's identity is unknown")
+	case c.caller == p.ProposedBy:
+		return p, Deployment{}, ErrSelfApproval
+	case p.State == ProposalDeployed:
+		return p, Deployment{}, ErrDeployed
+	case p.State == ProposalStale:
+		return p, Deployment{}, ErrStaleProposal
+	}
+
+	deployment, err := c.StartDeployment(ctx, appId, configId, p.EnvId, p.Version, strategyId)
+	if err != nil {
+		return p, Deployment{}, err
+	}
+	p.State = ProposalDeployed
+	return p, deployment, nil
+}
@@ -0,0 +1,150 @@
+package appconfig_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig/fake"
+)
+
+const (
+	alice = "arn:aws:sts::123456789012:assumed-role/Engineer/alice"
+	bob   = "arn:aws:sts::123456789012:assumed-role/Reviewer/bob"
+)
+
+func TestApprove(t *testing.T) {
+	tests := []struct {
+		name     string
+		approver string
+		// deployed to production between proposing and approving
+		deployedSince bool
+		expectedErr   error
+	}{
+		{name: "should deploy when another user approves", approver: bob},
+		{name: "should refuse self-approval", approver: alice, expectedErr: appconfig.ErrSelfApproval},
+		{name: "should allow another user of the same role to approve", approver: "arn:aws:sts::123456789012:assumed-role/Engineer/bob"},
+		{name: "should allow an IAM user to approve a role's proposal", approver: "arn:aws:iam::123456789012:user/Engineer"},
+		{name: "should refuse a stale proposal", approver: bob, deployedSince: true, expectedErr: appconfig.ErrStaleProposal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			backend := fake.NewBackend(fake.DemoData())
+			proposer := appconfig.NewWithClients(backend, backend, appconfig.WithCaller(alice))
+			approver := appconfig.NewWithClients(backend, backend, appconfig.WithCaller(tt.approver))
+
+			proposal, err := proposer.ProposeFlag(ctx, "wordle1", "webflg1", "pro0001", "dark_mode", true)
+			if err != nil {
+				t.Fatalf("ProposeFlag: %v", err)
+			}
+			if calls := backend.Calls("StartDeployment"); calls != 0 {
+				t.Fatalf("expected a proposal not to be deployed, got %d deployments", calls)
+			}
+			if tt.deployedSince {
+				if _, err := proposer.SetFlag(ctx, "wordle1", "webflg1", "pro0001", "beta_feature", true, ""); err != nil {
+					t.Fatalf("SetFlag: %v", err)
+				}
+			}
+
+			_, _, err = approver.Approve(ctx, "wordle1", "webflg1", proposal.Version, "")
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Approve: %v, expected %v", err, tt.expectedErr)
+			}
+
+			deployed, err := approver.DeployedVersion(ctx, "wordle1", "webflg1", "pro0001")
+			if err != nil {
+				t.Fatalf("DeployedVersion: %v", err)
+			}
+			if approved := deployed.Version == proposal.Version; approved != (tt.expectedErr == nil) {
+				t.Errorf("deployed version %d, proposal %d", deployed.Version, proposal.Version)
+			}
+		})
+	}
+}
+
+func TestListProposals(t *testing.T) {
+	ctx := context.Background()
+	backend := fake.NewBackend(fake.DemoData())
+	proposer := appconfig.NewWithClients(backend, backend, appconfig.WithCaller(alice))
+	approver := appconfig.NewWithClients(backend, backend, appconfig.WithCaller(bob))
+
+	approved, err := proposer.ProposeFlag(ctx, "wordle1", "webflg1", "sta0001", "beta_feature", true)
+	if err != nil {
+		t.Fatalf("ProposeFlag: %v", err)
+	}
+	if _, _, err := approver.Approve(ctx, "wordle1", "webflg1", approved.Version, ""); err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	pending, err := proposer.ProposeFlag(ctx, "wordle1", "webflg1", "pro0001", "dark_mode", true)
+	if err != nil {
+		t.Fatalf("ProposeFlag: %v", err)
+	}
+
+	proposals, err := approver.ListProposals(ctx, "wordle1", "webflg1")
+	if err != nil {
+		t.Fatalf("ListProposals: %v", err)
+	}
+	if len(proposals) != 2 {
+		t.Fatalf("expected 2 proposals, got %+v", proposals)
+	}
+	expected := []appconfig.Proposal{
+		{Version: pending.Version, EnvId: "pro0001", BaseVersion: 3, ProposedBy: alice, Summary: "turn dark_mode on", State: appconfig.ProposalPending},
+		{Version: approved.Version, EnvId: "sta0001", BaseVersion: 2, ProposedBy: alice, Summary: "turn beta_feature on", State: appconfig.ProposalDeployed},
+	}
+	for i := range expected {
+		if proposals[i] != expected[i] {
+			t.Errorf("proposal %d = %+v, expected %+v", i, proposals[i], expected[i])
+		}
+	}
+
+	changes, err := approver.ProposalChanges(ctx, "wordle1", "webflg1", proposals[0])
+	if err != nil {
+		t.Fatalf("ProposalChanges: %v", err)
+	}
+	if got := changes.String(); got != "~ dark_mode\n    enabled: off -> on\n" {
+		t.Errorf("changes = %q", got)
+	}
+}
+
+func TestCreateFlagVersionReservedDescription(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		expectedErr error
+	}{
+		{name: "should create a version with any other description", description: "lazyflags: import flags.yaml"},
+		{
+			name:        "should refuse a description forging a proposal",
+			description: "lazyflags proposal for pro0001 on version 3 by " + bob + ": turn dark_mode on",
+			expectedErr: appconfig.ErrReservedDescription,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			backend := fake.NewBackend(fake.DemoData())
+			client := appconfig.NewWithClients(backend, backend, appconfig.WithCaller(alice))
+
+			doc, err := client.GetFlagDocument(ctx, "wordle1", "webflg1", 3)
+			if err != nil {
+				t.Fatalf("GetFlagDocument: %v", err)
+			}
+			_, err = client.CreateFlagVersion(ctx, "wordle1", "webflg1", doc, tt.description, 0)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("CreateFlagVersion: %v, expected %v", err, tt.expectedErr)
+			}
+
+			proposals, err := client.ListProposals(ctx, "wordle1", "webflg1")
+			if err != nil {
+				t.Fatalf("ListProposals: %v", err)
+			}
+			if len(proposals) != 0 {
+				t.Errorf("expected no proposals, got %+v", proposals)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package appconfig
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithClock replaces time.Now for deciding when a session may poll again
+func WithClock(now func() time.Time) Option {
+	return func(c *Client) {
+		c.sessions.now = now
+	}
+}
+
+// dataSession is an AppConfigData session of one app/profile/env. Its
+// token can be used once and is replaced by the next poll token, polling
+// returns an empty body when the configuration hasn't changed.
+type dataSession struct {
+	// polls of the same session must not overlap, tokens are single use
+	mu       sync.Mutex
+	token    string
+	flags    Flags
+	nextPoll time.Time
+}
+
+type sessionStore struct {
+	now func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*dataSession
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{
+		now:      time.Now,
+		sessions: make(map[string]*dataSession),
+	}
+}
+
+func sessionKey(appId, configId, envId string) string {
+	return appId + ":" + configId + ":" + envId
+}
+
+func (s *sessionStore) get(key string) *dataSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[key]
+	if !ok {
+		sess = &dataSession{}
+		s.sessions[key] = sess
+	}
+	return sess
+}
+
+// forget drops a session, the next fetch starts a new one
+func (s *sessionStore) forget(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, key)
+}
+
+// forgetPrefix drops every session whose key starts with prefix
+func (s *sessionStore) forgetPrefix(prefix string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.sessions {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.sessions, key)
+		}
+	}
+}
+
+// ForgetSessions drops the sessions of every environment of a configuration
+// profile, the next fetch starts new ones and returns what is deployed
+// right now rather than the flags of the last poll
+func (c *Client) ForgetSessions(appId, configId string) {
+	c.sessions.forgetPrefix(sessionKey(appId, configId, ""))
+}
@@ -0,0 +1,128 @@
+package appconfig_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig/fake"
+)
+
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestGetLatestFlagConfigReusesSession(t *testing.T) {
+	backend := fake.NewBackend(fake.DemoData())
+	clock := &testClock{now: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)}
+	client := appconfig.NewWithClients(backend, backend, appconfig.WithClock(clock.Now))
+	ctx := context.Background()
+
+	get := func() appconfig.Flags {
+		t.Helper()
+		flags, err := client.GetLatestFlagConfig(ctx, "wordle1", "webflg1", "pro0001", 60)
+		if err != nil {
+			t.Fatalf("GetLatestFlagConfig: %v", err)
+		}
+		return flags
+	}
+	expectCalls := func(sessions, polls int) {
+		t.Helper()
+		if calls := backend.Calls("StartConfigurationSession"); calls != sessions {
+			t.Errorf("expected %d sessions, got %d", sessions, calls)
+		}
+		if calls := backend.Calls("GetLatestConfiguration"); calls != polls {
+			t.Errorf("expected %d polls, got %d", polls, calls)
+		}
+	}
+
+	if get()["dark_mode"].Enabled {
+		t.Fatalf("expected dark_mode to be off in production")
+	}
+	expectCalls(1, 1)
+
+	// within the poll interval AWS isn't asked again
+	clock.Advance(30 * time.Second)
+	get()
+	expectCalls(1, 1)
+
+	// after it the session is polled with its next token, nothing changed
+	clock.Advance(31 * time.Second)
+	if get()["dark_mode"].Enabled {
+		t.Errorf("expected dark_mode to still be off")
+	}
+	expectCalls(1, 2)
+
+	// a deployment made elsewhere shows up on the next poll
+	other := appconfig.NewWithClients(backend, backend)
+	if _, err := other.SetFlag(ctx, "wordle1", "webflg1", "pro0001", "dark_mode", true, ""); err != nil {
+		t.Fatalf("SetFlag: %v", err)
+	}
+	clock.Advance(61 * time.Second)
+	if !get()["dark_mode"].Enabled {
+		t.Errorf("expected the poll to return the new deployment")
+	}
+	expectCalls(1, 3)
+
+	// a deployment made through the client starts a new session right away
+	if _, err := client.SetFlag(ctx, "wordle1", "webflg1", "pro0001", "dark_mode", false, ""); err != nil {
+		t.Fatalf("SetFlag: %v", err)
+	}
+	if get()["dark_mode"].Enabled {
+		t.Errorf("expected the new session to return the new deployment")
+	}
+	expectCalls(2, 4)
+
+	// forgotten sessions don't wait for the poll interval either
+	if _, err := other.SetFlag(ctx, "wordle1", "webflg1", "pro0001", "dark_mode", true, ""); err != nil {
+		t.Fatalf("SetFlag: %v", err)
+	}
+	client.ForgetSessions("wordle1", "webflg1")
+	if !get()["dark_mode"].Enabled {
+		t.Errorf("expected a refresh to return the new deployment")
+	}
+	expectCalls(3, 5)
+}
+
+func TestGetLatestFlagConfigRestartsFailedSession(t *testing.T) {
+	backend := fake.NewBackend(fake.DemoData())
+	clock := &testClock{now: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)}
+	client := appconfig.NewWithClients(backend, backend, appconfig.WithClock(clock.Now))
+	ctx := context.Background()
+
+	if _, err := client.GetLatestFlagConfig(ctx, "wordle1", "webflg1", "pro0001", 60); err != nil {
+		t.Fatalf("GetLatestFlagConfig: %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	backend.AddFault(fake.Fault{Operation: "GetLatestConfiguration", Err: fake.ErrInternal, Times: 1})
+	if _, err := client.GetLatestFlagConfig(ctx, "wordle1", "webflg1", "pro0001", 60); err == nil {
+		t.Fatalf("expected the poll to fail")
+	}
+
+	flags, err := client.GetLatestFlagConfig(ctx, "wordle1", "webflg1", "pro0001", 60)
+	if err != nil {
+		t.Fatalf("GetLatestFlagConfig: %v", err)
+	}
+	if len(flags) != 3 {
+		t.Errorf("expected the new session to return all flags, got %v", flags)
+	}
+	if calls := backend.Calls("StartConfigurationSession"); calls != 2 {
+		t.Errorf("expected a new session after the failed poll, got %d sessions", calls)
+	}
+}
@@ -0,0 +1,52 @@
+package appconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/appconfig"
+)
+
+// WithAccount sets the AWS account of the caller, the ARNs of its
+// environments are built with it
+func WithAccount(account string) Option {
+	return func(c *Client) {
+		c.account = account
+	}
+}
+
+func withRegion(region string) Option {
+	return func(c *Client) {
+		c.region = region
+	}
+}
+
+// EnvironmentTags returns the tags of an environment. AWS only looks tags up
+// by ARN, which needs the account of WithAccount.
+func (c *Client) EnvironmentTags(ctx context.Context, appId, envId string) (map[string]string, error) {
+	if c.account == "" {
+		return nil, errors.New("failed to list environment tags: the AWS account is unknown")
+	}
+
+	out, err := c.configClient.ListTagsForResource(ctx, &appconfig.ListTagsForResourceInput{
+		ResourceArn: aws.String(environmentArn(c.region, c.account, appId, envId)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list environment tags: %w", err)
+	}
+	return out.Tags, nil
+}
+
+func environmentArn(region, account, appId, envId string) string {
+	partition := "aws"
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		partition = "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		partition = "aws-us-gov"
+	}
+	return fmt.Sprintf("arn:%s:appconfig:%s:%s:application/%s/environment/%s", partition, region, account, appId, envId)
+}
@@ -0,0 +1,172 @@
+package appconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/appconfig"
+	"github.com/aws/aws-sdk-go-v2/service/appconfig/types"
+)
+
+var (
+	ErrNothingToUndo = errors.New("no other version was deployed to the environment before")
+	ErrStaleUndo     = errors.New("another deployment started since the undo was planned, review it again")
+)
+
+// UndoPlan redeploys the version that was live in an environment before
+// its last deployment. The deployments AppConfig lists per environment are
+// the history, stopped and rolled back ones never count as live.
+type UndoPlan struct {
+	EnvId string
+	// last deployment, stopped first while it's still rolling out
+	Current Deployment
+	// last completed deployment of another version before it
+	Previous Deployment
+	// what redeploying the previous version changes, the reverse of what
+	// the current deployment changed
+	Changes ChangeSet
+}
+
+// InProgress reports whether the deployment undone is still rolling out
+// or baking
+func (p UndoPlan) InProgress() bool {
+	return p.Current.State == types.DeploymentStateDeploying || p.Current.State == types.DeploymentStateBaking
+}
+
+// PlanUndo finds the version to redeploy to undo the last deployment to an
+// environment, nothing is changed
+func (c *Client) PlanUndo(ctx context.Context, appId, configId, envId string) (UndoPlan, error) {
+	deployments, err := c.deployments(ctx, appId, configId, envId)
+	if err != nil {
+		return UndoPlan{}, err
+	}
+	current, previous, ok := undoDeployments(deployments)
+	if !ok {
+		return UndoPlan{}, ErrNothingToUndo
+	}
+
+	currentDoc, err := c.GetFlagDocument(ctx, appId, configId, current.Version)
+	if err != nil {
+		return UndoPlan{}, err
+	}
+	previousDoc, err := c.GetFlagDocument(ctx, appId, configId, previous.Version)
+	if err != nil {
+		return UndoPlan{}, err
+	}
+	return UndoPlan{
+		EnvId:    envId,
+		Current:  current,
+		Previous: previous,
+		Changes:  DiffDocuments(currentDoc, previousDoc),
+	}, nil
+}
+
+// undoDeployments picks the live deployment and the completed deployment
+// of another version before it
+func undoDeployments(deployments []Deployment) (current, previous Deployment, ok bool) {
+	i := liveIndex(deployments)
+	if i < 0 {
+		return Deployment{}, Deployment{}, false
+	}
+	current = deployments[i]
+	for _, d := range deployments[i+1:] {
+		if d.State == types.DeploymentStateComplete && d.Version != current.Version {
+			return current, d, true
+		}
+	}
+	return Deployment{}, Deployment{}, false
+}
+
+// Undo carries out a plan: the current deployment is stopped if it's still
+// rolling out and the previous version is deployed again once AppConfig
+// has rolled it back, an environment takes one deployment at a time. The plan is
+// refused once another deployment started, so what was reviewed is what
+// gets undone.
+func (c *Client) Undo(ctx context.Context, appId, configId string, plan UndoPlan, strategyId string) (Deployment, error) {
+	deployments, err := c.deployments(ctx, appId, configId, plan.EnvId)
+	if err != nil {
+		return Deployment{}, err
+	}
+	current, _, ok := undoDeployments(deployments)
+	if !ok || current.Number != plan.Current.Number {
+		return Deployment{}, ErrStaleUndo
+	}
+	if err := c.checkChange(ctx, Change{AppId: appId, ConfigId: configId, EnvId: plan.EnvId, Changes: plan.Changes}); err != nil {
+		return Deployment{}, err
+	}
+
+	if plan.InProgress() {
+		if _, err := c.StopDeployment(ctx, appId, configId, plan.EnvId, current); err != nil {
+			return Deployment{}, err
+		}
+		if err := c.waitRolledBack(ctx, appId, plan.EnvId, current.Number); err != nil {
+			return Deployment{}, err
+		}
+	}
+	description := fmt.Sprintf("lazyflags: undo deployment %d", current.Number)
+	return c.startDeployment(ctx, appId, configId, plan.EnvId, plan.Previous.Version, strategyId, description, plan.Changes)
+}
+
+// how often waitRolledBack asks about a rollback, doubling up to
+// maxRollbackPoll
+const (
+	rollbackPoll    = 100 * time.Millisecond
+	maxRollbackPoll = 5 * time.Second
+)
+
+// waitRolledBack waits until a stopped deployment is no longer rolling
+// back, StartDeployment fails with a ConflictException until then
+func (c *Client) waitRolledBack(ctx context.Context, appId, envId string, number int32) error {
+	wait := rollbackPoll
+	for {
+		res, err := c.configClient.GetDeployment(ctx, &appconfig.GetDeploymentInput{
+			ApplicationId:    &appId,
+			EnvironmentId:    &envId,
+			DeploymentNumber: &number,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get deployment %d: %w", number, err)
+		}
+		if res.State != types.DeploymentStateRollingBack {
+			return nil
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return fmt.Errorf("deployment %d is still rolling back: %w", number, ctx.Err())
+		}
+		wait = min(2*wait, maxRollbackPoll)
+	}
+}
+
+// StopDeployment stops a deployment that is still rolling out, AppConfig
+// rolls the environment back to the version deployed before it
+func (c *Client) StopDeployment(ctx context.Context, appId, configId, envId string, deployment Deployment) (Deployment, error) {
+	res, err := c.configClient.StopDeployment(ctx, &appconfig.StopDeploymentInput{
+		ApplicationId:    &appId,
+		EnvironmentId:    &envId,
+		DeploymentNumber: &deployment.Number,
+	})
+	if err != nil {
+		return Deployment{}, fmt.Errorf("failed to stop deployment %d: %w", deployment.Number, err)
+	}
+	c.sessions.forget(sessionKey(appId, configId, envId))
+
+	c.emit(Event{
+		Action:     ActionStopDeployment,
+		AppId:      appId,
+		ConfigId:   configId,
+		EnvId:      envId,
+		Version:    deployment.Version,
+		Deployment: deployment.Number,
+	})
+	return Deployment{
+		Number:    res.DeploymentNumber,
+		Version:   deployment.Version,
+		State:     res.State,
+		StartedAt: res.StartedAt,
+	}, nil
+}
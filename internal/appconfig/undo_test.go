@@ -0,0 +1,139 @@
+package appconfig_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig/fake"
+)
+
+func TestUndo(t *testing.T) {
+	tests := []struct {
+		name string
+		// strategy of the bad toggle, empty for none
+		strategy string
+		// deployed between planning and undoing
+		deployedSince bool
+		// how long the stopped deployment rolls back, and how long the
+		// undo may take
+		rollback        time.Duration
+		timeout         time.Duration
+		expectedPlanErr error
+		expectedErr     error
+		expectedStops   int
+		expectedActions []appconfig.Action
+	}{
+		{name: "should have nothing to undo without an earlier version", expectedPlanErr: appconfig.ErrNothingToUndo},
+		{
+			name:            "should redeploy the previous version",
+			strategy:        "AppConfig.AllAtOnce",
+			expectedActions: []appconfig.Action{appconfig.ActionStartDeployment},
+		},
+		{
+			name:            "should stop a deployment still rolling out first",
+			strategy:        "AppConfig.Linear50PercentEvery30Seconds",
+			expectedStops:   1,
+			expectedActions: []appconfig.Action{appconfig.ActionStopDeployment, appconfig.ActionStartDeployment},
+		},
+		{
+			name:            "should wait for the stopped deployment to roll back",
+			strategy:        "AppConfig.Linear50PercentEvery30Seconds",
+			rollback:        300 * time.Millisecond,
+			expectedStops:   1,
+			expectedActions: []appconfig.Action{appconfig.ActionStopDeployment, appconfig.ActionStartDeployment},
+		},
+		{
+			name:            "should give up waiting for a rollback once cancelled",
+			strategy:        "AppConfig.Linear50PercentEvery30Seconds",
+			rollback:        time.Hour,
+			timeout:         300 * time.Millisecond,
+			expectedErr:     context.DeadlineExceeded,
+			expectedStops:   1,
+			expectedActions: []appconfig.Action{appconfig.ActionStopDeployment},
+		},
+		{
+			name:          "should refuse a plan another deployment overtook",
+			strategy:      "AppConfig.AllAtOnce",
+			deployedSince: true,
+			expectedErr:   appconfig.ErrStaleUndo,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			backend := fake.NewBackend(fake.DemoData())
+			backend.RollbackDuration = tt.rollback
+			var events []appconfig.Event
+			client := appconfig.NewWithClients(backend, backend, appconfig.WithAudit(func(e appconfig.Event) { events = append(events, e) }))
+
+			if tt.strategy != "" {
+				if _, err := client.SetFlag(ctx, "wordle1", "webflg1", "pro0001", "dark_mode", true, tt.strategy); err != nil {
+					t.Fatalf("SetFlag: %v", err)
+				}
+			}
+
+			plan, err := client.PlanUndo(ctx, "wordle1", "webflg1", "pro0001")
+			if !errors.Is(err, tt.expectedPlanErr) {
+				t.Fatalf("PlanUndo: %v, expected %v", err, tt.expectedPlanErr)
+			}
+			if err != nil {
+				return
+			}
+			if plan.Previous.Version != 3 {
+				t.Errorf("expected to undo back to version 3, got %d", plan.Previous.Version)
+			}
+			if plan.InProgress() != (tt.expectedStops > 0) {
+				t.Errorf("expected in progress %v, got state %s", tt.expectedStops > 0, plan.Current.State)
+			}
+			if len(plan.Changes) != 1 || plan.Changes[0].Flag != "dark_mode" || plan.Changes[0].Fields[0].After != false {
+				t.Errorf("expected dark_mode to be turned off again, got %+v", plan.Changes)
+			}
+
+			if tt.deployedSince {
+				if _, err := client.SetFlag(ctx, "wordle1", "webflg1", "pro0001", "beta_feature", true, ""); err != nil {
+					t.Fatalf("SetFlag: %v", err)
+				}
+			}
+
+			events = nil
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			_, err = client.Undo(ctx, "wordle1", "webflg1", plan, "")
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Undo: %v, expected %v", err, tt.expectedErr)
+			}
+			if calls := backend.Calls("StopDeployment"); calls != tt.expectedStops {
+				t.Errorf("expected %d stopped deployments, got %d", tt.expectedStops, calls)
+			}
+			if len(events) != len(tt.expectedActions) {
+				t.Fatalf("expected events %v, got %+v", tt.expectedActions, events)
+			}
+			for i, e := range events {
+				if e.Action != tt.expectedActions[i] {
+					t.Errorf("event %d: expected %s, got %s", i, tt.expectedActions[i], e.Action)
+				}
+			}
+			if err != nil {
+				return
+			}
+			if tt.expectedStops > 0 && backend.Calls("GetDeployment") == 0 {
+				t.Errorf("expected the rollback to be waited for")
+			}
+
+			deployed, err := client.DeployedVersion(ctx, "wordle1", "webflg1", "pro0001")
+			if err != nil {
+				t.Fatalf("DeployedVersion: %v", err)
+			}
+			if deployed.Version != 3 {
+				t.Errorf("expected version 3 to be deployed again, got %d", deployed.Version)
+			}
+		})
+	}
+}
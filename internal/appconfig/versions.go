@@ -0,0 +1,396 @@
+package appconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/appconfig"
+	"github.com/aws/aws-sdk-go-v2/service/appconfig/types"
+)
+
+const (
+	// predefined strategy that rolls out to every target immediately
+	DefaultDeploymentStrategy = "AppConfig.AllAtOnce"
+
+	flagDocumentContentType = "application/json"
+)
+
+var ErrNoDeployment = errors.New("no deployment found")
+
+type Deployment struct {
+	Number    int32
+	Version   int32
+	State     types.DeploymentState
+	StartedAt *time.Time
+}
+
+// DeployedVersion returns the most recent live deployment of a
+// configuration profile to an environment, see IsLive. Stopped and rolled
+// back deployments are skipped, their version isn't what clients receive.
+func (c *Client) DeployedVersion(ctx context.Context, appId, configId, envId string) (Deployment, error) {
+	deployments, err := c.deployments(ctx, appId, configId, envId)
+	if err != nil {
+		return Deployment{}, err
+	}
+	i := liveIndex(deployments)
+	if i < 0 {
+		return Deployment{}, ErrNoDeployment
+	}
+	return deployments[i], nil
+}
+
+// IsLive reports whether clients receive the deployment's version: it is
+// rolling out, baking or complete
+func (d Deployment) IsLive() bool {
+	switch d.State {
+	case types.DeploymentStateDeploying, types.DeploymentStateBaking, types.DeploymentStateComplete:
+		return true
+	}
+	return false
+}
+
+// liveIndex returns the index of the most recent live deployment, -1 for
+// none
+func liveIndex(deployments []Deployment) int {
+	for i, d := range deployments {
+		if d.IsLive() {
+			return i
+		}
+	}
+	return -1
+}
+
+// deployments returns the deployments of a configuration profile to an
+// environment, most recent first
+func (c *Client) deployments(ctx context.Context, appId, configId, envId string) ([]Deployment, error) {
+	profile, err := c.configClient.GetConfigurationProfile(ctx, &appconfig.GetConfigurationProfileInput{
+		ApplicationId:          &appId,
+		ConfigurationProfileId: &configId,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get configuration profile: %w", err)
+	}
+
+	in := &appconfig.ListDeploymentsInput{
+		ApplicationId: &appId,
+		EnvironmentId: &envId,
+		MaxResults:    aws.Int32(50),
+	}
+	var deployments []Deployment
+	for {
+		out, err := c.configClient.ListDeployments(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list deployments: %w", err)
+		}
+
+		// deployments are listed most recent first
+		for _, d := range out.Items {
+			// summaries only name the profile, another or a deleted profile
+			// may have the same name
+			if aws.ToString(d.ConfigurationName) != aws.ToString(profile.Name) {
+				continue
+			}
+			profileId, err := c.deploymentProfile(ctx, appId, envId, d.DeploymentNumber)
+			if err != nil {
+				return nil, err
+			}
+			if profileId != aws.ToString(profile.Id) {
+				continue
+			}
+
+			version, err := strconv.ParseInt(aws.ToString(d.ConfigurationVersion), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected configuration version %q: %w", aws.ToString(d.ConfigurationVersion), err)
+			}
+			deployments = append(deployments, Deployment{
+				Number:    d.DeploymentNumber,
+				Version:   int32(version),
+				State:     d.State,
+				StartedAt: d.StartedAt,
+			})
+		}
+
+		if out.NextToken == nil {
+			return deployments, nil
+		}
+		in.NextToken = out.NextToken
+	}
+}
+
+// deploymentProfile returns the id of the profile a deployment deployed.
+// It never changes, so it's only looked up once per Client.
+func (c *Client) deploymentProfile(ctx context.Context, appId, envId string, number int32) (string, error) {
+	key := fmt.Sprintf("%s:%s:%d", appId, envId, number)
+	if id, ok := c.deploymentProfiles.get(key); ok {
+		return id, nil
+	}
+
+	out, err := c.configClient.GetDeployment(ctx, &appconfig.GetDeploymentInput{
+		ApplicationId:    &appId,
+		EnvironmentId:    &envId,
+		DeploymentNumber: &number,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get deployment %d: %w", number, err)
+	}
+	id := aws.ToString(out.ConfigurationProfileId)
+	c.deploymentProfiles.set(key, id)
+	return id, nil
+}
+
+// profileIds maps deployments to the id of their profile
+type profileIds struct {
+	mu  sync.Mutex
+	ids map[string]string
+}
+
+func newProfileIds() *profileIds {
+	return &profileIds{ids: make(map[string]string)}
+}
+
+func (p *profileIds) get(key string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id, ok := p.ids[key]
+	return id, ok
+}
+
+func (p *profileIds) set(key string, id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ids[key] = id
+}
+
+// LatestVersion returns the number of the most recently created hosted
+// configuration version, or 0 when the profile has none.
+func (c *Client) LatestVersion(ctx context.Context, appId, configId string) (int32, error) {
+	versions, err := c.configClient.ListHostedConfigurationVersions(ctx, &appconfig.ListHostedConfigurationVersionsInput{
+		ApplicationId:          &appId,
+		ConfigurationProfileId: &configId,
+		MaxResults:             aws.Int32(1),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list hosted configuration versions: %w", err)
+	}
+
+	var latest int32
+	for _, v := range versions.Items {
+		latest = max(latest, v.VersionNumber)
+	}
+	return latest, nil
+}
+
+func (c *Client) GetFlagDocument(ctx context.Context, appId, configId string, version int32) (*FlagDocument, error) {
+	res, err := c.configClient.GetHostedConfigurationVersion(ctx, &appconfig.GetHostedConfigurationVersionInput{
+		ApplicationId:          &appId,
+		ConfigurationProfileId: &configId,
+		VersionNumber:          &version,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hosted configuration version %d: %w", version, err)
+	}
+
+	return ParseFlagDocument(res.Content)
+}
+
+// CreateFlagVersion stores doc as a new hosted configuration version.
+// The version is not deployed anywhere until StartDeployment is called.
+// When latestVersion is set the call fails if another version was created
+// since, so concurrent edits don't silently overwrite each other. Proposals
+// are only made by Propose, descriptions like theirs are refused.
+func (c *Client) CreateFlagVersion(ctx context.Context, appId, configId string, doc *FlagDocument, description string, latestVersion int32) (int32, error) {
+	if IsReservedDescription(description) {
+		return 0, ErrReservedDescription
+	}
+	if c.check != nil {
+		changes, err := c.latestChanges(ctx, appId, configId, doc, latestVersion)
+		if err != nil {
+			return 0, err
+		}
+		if err := c.checkChange(ctx, Change{AppId: appId, ConfigId: configId, Changes: changes}); err != nil {
+			return 0, err
+		}
+	}
+	return c.createFlagVersion(ctx, appId, configId, nil, doc, description, latestVersion)
+}
+
+// createFlagVersion creates a version made from base, nil when it isn't
+// known and the version is compared with the one before it
+func (c *Client) createFlagVersion(ctx context.Context, appId, configId string, base, doc *FlagDocument, description string, latestVersion int32) (int32, error) {
+	content, err := doc.Marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	var latestVersionNumber *int32
+	if latestVersion > 0 {
+		latestVersionNumber = &latestVersion
+	}
+
+	res, err := c.configClient.CreateHostedConfigurationVersion(ctx, &appconfig.CreateHostedConfigurationVersionInput{
+		ApplicationId:          &appId,
+		ConfigurationProfileId: &configId,
+		Content:                content,
+		ContentType:            aws.String(flagDocumentContentType),
+		Description:            aws.String(description),
+		LatestVersionNumber:    latestVersionNumber,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create hosted configuration version: %w", err)
+	}
+
+	if c.audit != nil {
+		changes := DiffDocuments(base, doc)
+		if base == nil {
+			changes = c.versionChanges(ctx, appId, configId, res.VersionNumber, doc)
+		}
+		c.emit(Event{
+			Action:      ActionCreateVersion,
+			AppId:       appId,
+			ConfigId:    configId,
+			Version:     res.VersionNumber,
+			Description: description,
+			Changes:     changes,
+		})
+	}
+	return res.VersionNumber, nil
+}
+
+func (c *Client) StartDeployment(ctx context.Context, appId, configId, envId string, version int32, strategyId string) (Deployment, error) {
+	var changes ChangeSet
+	if c.audit != nil || c.check != nil {
+		var err error
+		// the audit makes do without changes, a check can't
+		if changes, err = c.deploymentChanges(ctx, appId, configId, envId, version); err != nil && c.check != nil {
+			return Deployment{}, err
+		}
+	}
+	if err := c.checkChange(ctx, Change{AppId: appId, ConfigId: configId, EnvId: envId, Changes: changes}); err != nil {
+		return Deployment{}, err
+	}
+	return c.startDeployment(ctx, appId, configId, envId, version, strategyId, "", changes)
+}
+
+// startDeployment deploys a version whose changes are already known, the
+// description is optional
+func (c *Client) startDeployment(ctx context.Context, appId, configId, envId string, version int32, strategyId, description string, changes ChangeSet) (Deployment, error) {
+	if strategyId == "" {
+		strategyId = DefaultDeploymentStrategy
+	}
+
+	input := &appconfig.StartDeploymentInput{
+		ApplicationId:          &appId,
+		ConfigurationProfileId: &configId,
+		EnvironmentId:          &envId,
+		ConfigurationVersion:   aws.String(strconv.Itoa(int(version))),
+		DeploymentStrategyId:   &strategyId,
+	}
+	if description != "" {
+		input.Description = &description
+	}
+	res, err := c.configClient.StartDeployment(ctx, input)
+	if err != nil {
+		return Deployment{}, fmt.Errorf("failed to start deployment: %w", err)
+	}
+	// a new session sees the deployment right away instead of after the
+	// poll interval of the current one
+	c.sessions.forget(sessionKey(appId, configId, envId))
+
+	c.emit(Event{
+		Action:      ActionStartDeployment,
+		AppId:       appId,
+		ConfigId:    configId,
+		EnvId:       envId,
+		Version:     version,
+		Deployment:  res.DeploymentNumber,
+		Description: description,
+		Changes:     changes,
+	})
+	return Deployment{
+		Number:    res.DeploymentNumber,
+		Version:   version,
+		State:     res.State,
+		StartedAt: res.StartedAt,
+	}, nil
+}
+
+// SetFlag toggles a single flag in one environment. The version currently
+// deployed to the environment is used as the base, so changes made to
+// other environments are not carried over.
+func (c *Client) SetFlag(ctx context.Context, appId, configId, envId, flagName string, enabled bool, strategyId string) (Deployment, error) {
+	_, base, doc, err := c.toggledDocument(ctx, appId, configId, envId, flagName, enabled)
+	if err != nil {
+		return Deployment{}, err
+	}
+	return c.deployDocument(ctx, appId, configId, envId, base, doc, "lazyflags: "+toggleSummary(flagName, enabled), strategyId)
+}
+
+// DeployDocument stores doc as a new version and deploys it to one
+// environment, its changes are those compared with the version deployed
+// there rather than with the latest version
+func (c *Client) DeployDocument(ctx context.Context, appId, configId, envId string, doc *FlagDocument, description, strategyId string) (Deployment, error) {
+	base, err := c.deployedDocument(ctx, appId, configId, envId)
+	if err != nil {
+		return Deployment{}, err
+	}
+	return c.deployDocument(ctx, appId, configId, envId, base, doc, description, strategyId)
+}
+
+func (c *Client) deployDocument(ctx context.Context, appId, configId, envId string, base, doc *FlagDocument, description, strategyId string) (Deployment, error) {
+	changes := DiffDocuments(base, doc)
+	if err := c.checkChange(ctx, Change{AppId: appId, ConfigId: configId, EnvId: envId, Changes: changes}); err != nil {
+		return Deployment{}, err
+	}
+
+	version, err := c.createFlagVersion(ctx, appId, configId, base, doc, description, 0)
+	if err != nil {
+		return Deployment{}, err
+	}
+	return c.startDeployment(ctx, appId, configId, envId, version, strategyId, "", changes)
+}
+
+// deployedDocument returns the document of the version deployed to an
+// environment, nil when nothing is
+func (c *Client) deployedDocument(ctx context.Context, appId, configId, envId string) (*FlagDocument, error) {
+	deployed, err := c.DeployedVersion(ctx, appId, configId, envId)
+	if errors.Is(err, ErrNoDeployment) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.GetFlagDocument(ctx, appId, configId, deployed.Version)
+}
+
+// toggledDocument returns the version deployed to an environment, its
+// document and a copy with a flag turned on or off
+func (c *Client) toggledDocument(ctx context.Context, appId, configId, envId, flagName string, enabled bool) (int32, *FlagDocument, *FlagDocument, error) {
+	deployed, err := c.DeployedVersion(ctx, appId, configId, envId)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	base, err := c.GetFlagDocument(ctx, appId, configId, deployed.Version)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	doc := base.Clone()
+	if err := doc.SetEnabled(flagName, enabled); err != nil {
+		return 0, nil, nil, err
+	}
+	return deployed.Version, base, doc, nil
+}
+
+func toggleSummary(flagName string, enabled bool) string {
+	state := "off"
+	if enabled {
+		state = "on"
+	}
+	return fmt.Sprintf("turn %s %s", flagName, state)
+}
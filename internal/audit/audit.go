@@ -0,0 +1,264 @@
+// Package audit keeps a local log of every change made through lazyflags,
+// audit.jsonl in the LazyFlags directory of os.UserConfigDir. Each line is
+// one JSON Record, lines are only ever appended.
+package audit
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+)
+
+const (
+	filename = "audit.jsonl"
+	dir      = "LazyFlags"
+)
+
+// Record is a version created or a deployment started or stopped
+type Record struct {
+	Time time.Time `json:"time"`
+	// caller ARN of who made the change
+	Actor   string           `json:"actor"`
+	Account string           `json:"account,omitempty"`
+	Region  string           `json:"region,omitempty"`
+	Action  appconfig.Action `json:"action"`
+	App     string           `json:"app"`
+	Profile string           `json:"profile"`
+	// empty for versions, they aren't made for an environment
+	Env         string              `json:"env,omitempty"`
+	Version     int32               `json:"version,omitempty"`
+	Deployment  int32               `json:"deployment,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Changes     appconfig.ChangeSet `json:"changes,omitempty"`
+}
+
+// FromEvent records a change reported by the appconfig Client
+func FromEvent(e appconfig.Event) Record {
+	return Record{
+		Time:        e.Time.UTC(),
+		Actor:       e.Caller,
+		Account:     e.Account,
+		Region:      e.Region,
+		Action:      e.Action,
+		App:         e.AppId,
+		Profile:     e.ConfigId,
+		Env:         e.EnvId,
+		Version:     e.Version,
+		Deployment:  e.Deployment,
+		Description: e.Description,
+		Changes:     e.Changes,
+	}
+}
+
+// Log is an audit log file
+type Log struct {
+	path string
+	mu   sync.Mutex
+	// the last record Record couldn't write, see Failure
+	failure error
+}
+
+// DefaultPath returns where the audit log is kept
+func DefaultPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, dir, filename), nil
+}
+
+// Open returns the log at path, the file is created with the first record
+func Open(path string) *Log {
+	return &Log{path: path}
+}
+
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Append adds a record to the end of the log. The file is only readable by
+// the user, records hold ARNs and flag values.
+func (l *Log) Append(r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	// one write per record, so processes appending at once don't interleave
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Record appends a change reported by the appconfig Client, see
+// appconfig.WithAudit. The change is already made, so a failure isn't
+// returned but kept for Failure.
+func (l *Log) Record(e appconfig.Event) {
+	if err := l.Append(FromEvent(e)); err != nil {
+		l.mu.Lock()
+		l.failure = err
+		l.mu.Unlock()
+	}
+}
+
+// Failure returns the error of the last change Record couldn't write, and
+// forgets it. It is nil when every change since the last call was written.
+// Whoever made the change reports it, e.g. as a warning or in the status of
+// a TUI view.
+func (l *Log) Failure() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.failure
+	l.failure = nil
+	return err
+}
+
+// Read returns every record in the log, oldest first. A missing file is an
+// empty log. Lines that aren't a record, e.g. the end of a write cut short,
+// are skipped and described in warnings so one bad line doesn't hide the
+// rest of the log.
+func (l *Log) Read() (records []Record, warnings []string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	return decode(f)
+}
+
+func decode(r io.Reader) ([]Record, []string, error) {
+	var records []Record
+	var warnings []string
+	scanner := bufio.NewScanner(r)
+	// change sets of large documents make long lines
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			warnings = append(warnings, fmt.Sprintf("audit log line %d skipped: %v", n, err))
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, warnings, scanner.Err()
+}
+
+// Filter selects records, zero fields match everything
+type Filter struct {
+	App     string
+	Profile string
+	Since   time.Time
+}
+
+func (f Filter) Match(r Record) bool {
+	return (f.App == "" || r.App == f.App) &&
+		(f.Profile == "" || r.Profile == f.Profile) &&
+		!r.Time.Before(f.Since)
+}
+
+// Select returns the records matching f, in their order
+func Select(records []Record, f Filter) []Record {
+	var selected []Record
+	for _, r := range records {
+		if f.Match(r) {
+			selected = append(selected, r)
+		}
+	}
+	return selected
+}
+
+// Summary describes a record on one line, envName names its environment
+func (r Record) Summary(envName string) string {
+	switch r.Action {
+	case appconfig.ActionCreateVersion:
+		return fmt.Sprintf("created version %d%s", r.Version, changeCounts(r.Changes))
+	case appconfig.ActionStartDeployment:
+		return fmt.Sprintf("deployed version %d to %s (deployment %d)%s", r.Version, envName, r.Deployment, changeCounts(r.Changes))
+	case appconfig.ActionStopDeployment:
+		return fmt.Sprintf("stopped deployment %d of version %d to %s", r.Deployment, r.Version, envName)
+	}
+	return string(r.Action)
+}
+
+func changeCounts(changes appconfig.ChangeSet) string {
+	if len(changes) == 0 {
+		return ""
+	}
+	created, updated, deleted := changes.Counts()
+	return fmt.Sprintf(", +%d ~%d -%d", created, updated, deleted)
+}
+
+// WriteJSONL writes records in the format of the log
+func WriteJSONL(w io.Writer, records []Record) error {
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCSV writes one row per record, the changes as the text of a diff
+func WriteCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "actor", "account", "region", "action", "app", "profile", "env", "version", "deployment", "description", "changes"})
+	for _, r := range records {
+		cw.Write([]string{
+			r.Time.Format(time.RFC3339),
+			r.Actor,
+			r.Account,
+			r.Region,
+			string(r.Action),
+			r.App,
+			r.Profile,
+			r.Env,
+			optionalNumber(r.Version),
+			optionalNumber(r.Deployment),
+			r.Description,
+			strings.TrimSuffix(r.Changes.String(), "\n"),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func optionalNumber(n int32) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(n))
+}
@@ -0,0 +1,230 @@
+package audit_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/audit"
+)
+
+var testRecords = []audit.Record{
+	{
+		Time:    time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC),
+		Actor:   "arn:aws:sts::123456789012:assumed-role/Engineer/alice",
+		Action:  appconfig.ActionCreateVersion,
+		App:     "wordle1",
+		Profile: "webflg1",
+		Version: 4,
+		Changes: appconfig.ChangeSet{{
+			Flag:   "dark_mode",
+			Kind:   appconfig.ChangeUpdate,
+			Fields: []appconfig.FieldChange{{Field: "enabled", Before: false, After: true}},
+		}},
+	},
+	{
+		Time:       time.Date(2025, 6, 1, 12, 1, 0, 0, time.UTC),
+		Actor:      "arn:aws:sts::123456789012:assumed-role/Engineer/alice",
+		Action:     appconfig.ActionStartDeployment,
+		App:        "wordle1",
+		Profile:    "webflg1",
+		Env:        "pro0001",
+		Version:    4,
+		Deployment: 2,
+	},
+	{
+		Time:    time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC),
+		Actor:   "local/bob",
+		Action:  appconfig.ActionCreateVersion,
+		App:     "wordle1",
+		Profile: "mobflg1",
+		Version: 2,
+	},
+}
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "LazyFlags", "audit.jsonl")
+	log := audit.Open(path)
+
+	empty, warnings, err := log.Read()
+	if err != nil || len(empty) != 0 || len(warnings) != 0 {
+		t.Fatalf("expected a missing log to be empty, got %v, %v, %v", empty, warnings, err)
+	}
+
+	for _, r := range testRecords {
+		if err := log.Append(r); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("expected the log to be 0600, got %o", perm)
+	}
+
+	// reopened like by the next run
+	records, warnings, err := audit.Open(path).Read()
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("Read: %v, %v", warnings, err)
+	}
+	if !reflect.DeepEqual(records, testRecords) {
+		t.Errorf("expected %+v, got %+v", testRecords, records)
+	}
+}
+
+func TestLogSkipsBadLines(t *testing.T) {
+	tests := []struct {
+		name             string
+		lines            []string
+		expected         []audit.Record
+		expectedWarnings []string
+	}{
+		{
+			name:     "should read a log of records",
+			lines:    []string{record(t, testRecords[0]), "", record(t, testRecords[1])},
+			expected: testRecords[:2],
+		},
+		{
+			name:             "should skip the end of a write cut short",
+			lines:            []string{record(t, testRecords[0]), record(t, testRecords[1])[:20]},
+			expected:         testRecords[:1],
+			expectedWarnings: []string{"audit log line 2 skipped: unexpected end of JSON input"},
+		},
+		{
+			name:             "should read the records after a malformed line",
+			lines:            []string{"not json", record(t, testRecords[1]), `{"time": 5}`, record(t, testRecords[2])},
+			expected:         testRecords[1:],
+			expectedWarnings: []string{"audit log line 1 skipped", "audit log line 3 skipped"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+			if err := os.WriteFile(path, []byte(strings.Join(tt.lines, "\n")), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			records, warnings, err := audit.Open(path).Read()
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if !reflect.DeepEqual(records, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, records)
+			}
+			if len(warnings) != len(tt.expectedWarnings) {
+				t.Fatalf("expected warnings %q, got %q", tt.expectedWarnings, warnings)
+			}
+			for i, warning := range warnings {
+				if !strings.HasPrefix(warning, tt.expectedWarnings[i]) {
+					t.Errorf("expected warning %q, got %q", tt.expectedWarnings[i], warning)
+				}
+			}
+		})
+	}
+}
+
+func record(t *testing.T, r audit.Record) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := audit.WriteJSONL(&buf, []audit.Record{r}); err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func TestLogRecord(t *testing.T) {
+	log := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	log.Record(appconfig.Event{
+		Time:       time.Date(2025, 6, 1, 22, 0, 0, 0, time.FixedZone("AEST", 10*60*60)),
+		Caller:     "local/alice",
+		Account:    "local",
+		Action:     appconfig.ActionStartDeployment,
+		AppId:      "wordle1",
+		ConfigId:   "webflg1",
+		EnvId:      "pro0001",
+		Version:    4,
+		Deployment: 2,
+	})
+
+	records, _, err := log.Read()
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	expected := []audit.Record{{
+		Time:       time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC),
+		Actor:      "local/alice",
+		Account:    "local",
+		Action:     appconfig.ActionStartDeployment,
+		App:        "wordle1",
+		Profile:    "webflg1",
+		Env:        "pro0001",
+		Version:    4,
+		Deployment: 2,
+	}}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected %+v, got %+v", expected, records)
+	}
+}
+
+func TestLogRecordFailure(t *testing.T) {
+	dir := t.TempDir()
+	// the log can't be opened for writing
+	log := audit.Open(dir)
+
+	log.Record(appconfig.Event{Action: appconfig.ActionStartDeployment, AppId: "wordle1"})
+	if err := log.Failure(); err == nil {
+		t.Fatalf("expected the failed write to be kept")
+	}
+	if err := log.Failure(); err != nil {
+		t.Errorf("expected the failure to be forgotten once returned, got %v", err)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   audit.Filter
+		expected []audit.Record
+	}{
+		{name: "should match everything without a filter", expected: testRecords},
+		{name: "should match a profile", filter: audit.Filter{App: "wordle1", Profile: "webflg1"}, expected: testRecords[:2]},
+		{name: "should match records since a time", filter: audit.Filter{Since: time.Date(2025, 6, 1, 12, 1, 0, 0, time.UTC)}, expected: testRecords[1:]},
+		{name: "should match nothing of another app", filter: audit.Filter{App: "other01"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if selected := audit.Select(testRecords, tt.filter); !reflect.DeepEqual(selected, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, selected)
+			}
+		})
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := audit.WriteCSV(&buf, testRecords[:1]); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("expected valid CSV: %v", err)
+	}
+	expected := [][]string{
+		{"time", "actor", "account", "region", "action", "app", "profile", "env", "version", "deployment", "description", "changes"},
+		{"2025-06-01T12:00:00Z", "arn:aws:sts::123456789012:assumed-role/Engineer/alice", "", "", "create_version", "wordle1", "webflg1", "", "4", "", "", strings.TrimSuffix(testRecords[0].Changes.String(), "\n")},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected %q, got %q", expected, rows)
+	}
+}
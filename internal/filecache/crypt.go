@@ -0,0 +1,105 @@
+package filecache
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+const (
+	keySize  = 32
+	saltSize = 16
+	// OWASP's recommendation for PBKDF2-HMAC-SHA256
+	pbkdf2Iterations = 600_000
+)
+
+// ErrSealed is returned for a file that was encrypted with another key or
+// while the cache has none, e.g. after a mistyped passphrase or with the
+// encryption setting dropped. The file is never overwritten, Clear removes
+// it.
+var ErrSealed = errors.New("cache file can't be decrypted with the current key")
+
+// crypt encrypts the entries of the file with AES-256-GCM. The key is
+// either given or derived from a passphrase with a salt kept in the file.
+type crypt struct {
+	key        []byte
+	passphrase string
+	// salt the key was derived with, nil for a given key
+	salt []byte
+}
+
+// deriveKey derives the key of a passphrase for salt, unless it already is
+func (c *crypt) deriveKey(salt []byte) error {
+	if c.passphrase == "" || (c.key != nil && bytes.Equal(salt, c.salt)) {
+		return nil
+	}
+	key, err := pbkdf2.Key(sha256.New, c.passphrase, salt, pbkdf2Iterations, keySize)
+	if err != nil {
+		return err
+	}
+	c.key, c.salt = key, salt
+	return nil
+}
+
+// seal encrypts plain and returns it with the salt of the key, the nonce
+// goes first
+func (c *crypt) seal(plain []byte) (sealed []byte, salt []byte, err error) {
+	if c.passphrase != "" && c.salt == nil {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, nil, err
+		}
+		if err := c.deriveKey(salt); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	gcm, err := c.aead()
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), c.salt, nil
+}
+
+func (c *crypt) open(sealed []byte, salt []byte) ([]byte, error) {
+	if c.passphrase != "" && salt == nil {
+		return nil, ErrSealed
+	}
+	if err := c.deriveKey(salt); err != nil {
+		return nil, err
+	}
+
+	gcm, err := c.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrSealed
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrSealed
+	}
+	return plain, nil
+}
+
+func (c *crypt) aead() (cipher.AEAD, error) {
+	if len(c.key) != keySize {
+		return nil, fmt.Errorf("cache key must be %d bytes, got %d", keySize, len(c.key))
+	}
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
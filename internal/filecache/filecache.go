@@ -2,30 +2,161 @@ package filecache
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
 	"time"
-
-	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
 )
 
 const (
 	filename = ".cache"
-	dir = "LazyFlags"
-	ttl = time.Minute
+	dir      = "LazyFlags"
+
+	// version of the cache file format, bump it when the format changes.
+	// Version 3 couldn't be encrypted, version 2 only held flags, entries
+	// of version 0 and 1 have no identity and are never served.
+	version = 4
 )
 
+// ErrNewerVersion is returned when the cache file was written by a newer
+// lazyflags. The file is left alone and the cache only lives in memory.
+var ErrNewerVersion = errors.New("cache file was written by a newer version of lazyflags")
+
+var errCorrupt = errors.New("cache file is corrupt")
+
 type cache struct {
-	Value   []appconfig.Result `json:"value"`
-	Expires time.Time          `json:"expires"`
+	// kind and key the entry was added with and who added it, the file is
+	// keyed by all three
+	Kind     string          `json:"kind"`
+	Key      string          `json:"key"`
+	Identity Identity        `json:"identity"`
+	Value    json.RawMessage `json:"value"`
+	Expires  time.Time       `json:"expires"`
+	// when the value was added, zero in cache files of older versions
+	Cached time.Time `json:"cached"`
+}
+
+// Identity is the AWS account and region values were fetched from. Entries
+// are only served to the identity that added them, accounts may share ids.
+type Identity struct {
+	Account string `json:"account"`
+	Region  string `json:"region"`
+	// AppConfig endpoint other than AWS, e.g. the local server
+	Endpoint string `json:"endpoint,omitempty"`
+	// AWS profile the identity was resolved with, profiles of the same
+	// account share their entries
+	Profile string `json:"profile,omitempty"`
+}
+
+func (id Identity) IsZero() bool {
+	return id.Account == ""
+}
+
+func (id Identity) String() string {
+	s := id.Account + "/" + id.Region
+	if id.Endpoint != "" {
+		s += " (" + id.Endpoint + ")"
+	}
+	return s
+}
+
+// fileKey namespaces key by the account, region and endpoint and the kind
+// of value
+func (id Identity) fileKey(kind string, key string) string {
+	return id.Account + "/" + id.Region + "/" + id.Endpoint + "/" + kind + "/" + key
+}
+
+// file is the format of the cache file, files without a version are a bare
+// map of entries
+type file struct {
+	Version int              `json:"version"`
+	Entries map[string]cache `json:"entries,omitempty"`
+	// the JSON of the entries encrypted instead, see crypt
+	Sealed []byte `json:"sealed,omitempty"`
+	Salt   []byte `json:"salt,omitempty"`
 }
 
 type Cache struct {
-	// cache key will be appId:configId
+	// entries are read and added for this identity, a cache without one
+	// neither serves nor stores values
+	identity Identity
+	now      func() time.Time
+
+	// shared by copies of the Cache, they are used from tea.Cmd goroutines
+	s *store
+}
+
+// store holds the entries of the cache file. Other processes may write the
+// file too, it is locked while it's read or written and read again once it
+// changed.
+type store struct {
+	path string
+
+	mu sync.Mutex
+	// keyed by identity, kind and the key entries were added with
 	entries map[string]cache
+	// size and modification time of the file the entries were read from
+	size    int64
+	modTime time.Time
+	// the file has a newer format and must not be overwritten
+	newer bool
+	// the file can't be decrypted and must not be overwritten
+	sealed bool
+	// encrypts the file, nil writes it in plaintext
+	crypt *crypt
 }
 
-func New() (*Cache, error) {
+// Entry is a cached value as returned by Lookup, Entries holds the value
+// as JSON
+type Entry[T any] struct {
+	Kind     string
+	Key      string
+	Identity Identity
+	Value    T
+	// when the value was added, zero in cache files of older versions
+	Cached  time.Time
+	Expires time.Time
+	// the entry expired, callers may still show it while they refetch it
+	Stale bool
+}
+
+type Option func(*Cache)
+
+// WithIdentity serves and stores the entries of identity
+func WithIdentity(identity Identity) Option {
+	return func(fc *Cache) {
+		fc.identity = identity
+	}
+}
+
+// WithKey encrypts the cache file with a 32 byte key. Files encrypted with
+// another key can't be opened, see ErrSealed, plaintext ones are read and
+// encrypted by the next write.
+func WithKey(key []byte) Option {
+	return func(fc *Cache) {
+		fc.s.crypt = &crypt{key: key}
+	}
+}
+
+// WithPassphrase encrypts the cache file like WithKey, with a key derived
+// from passphrase
+func WithPassphrase(passphrase string) Option {
+	return func(fc *Cache) {
+		fc.s.crypt = &crypt{passphrase: passphrase}
+	}
+}
+
+// WithClock replaces time.Now for expiring entries and stamping new ones
+func WithClock(now func() time.Time) Option {
+	return func(fc *Cache) {
+		fc.now = now
+	}
+}
+
+func New(opts ...Option) (*Cache, error) {
 	cacheDir, err := os.UserCacheDir()
 	if err != nil {
 		return nil, err
@@ -33,70 +164,375 @@ func New() (*Cache, error) {
 
 	appCacheDir := filepath.Join(cacheDir, dir)
 
-	if err := os.MkdirAll(appCacheDir, 0755); err != nil {
+	if err := os.MkdirAll(appCacheDir, 0700); err != nil {
 		return nil, err
 	}
 
 	fc := &Cache{
-		entries: make(map[string]cache),
+		now: time.Now,
+		s: &store{
+			path:    filepath.Join(appCacheDir, filename),
+			entries: make(map[string]cache),
+		},
+	}
+	for _, opt := range opts {
+		opt(fc)
+	}
+	if c := fc.s.crypt; c != nil && c.passphrase == "" && len(c.key) != keySize {
+		return nil, fmt.Errorf("cache key must be %d bytes, got %d", keySize, len(c.key))
+	}
+
+	// a cache that can't be read starts empty and the next write replaces
+	// it, unless it's encrypted: that would lose it to a mistyped key
+	fc.s.mu.Lock()
+	defer fc.s.mu.Unlock()
+	if err := fc.s.reload(); errors.Is(err, ErrSealed) {
+		return nil, fmt.Errorf("%s: %w", fc.s.path, err)
+	}
+
+	return fc, nil
+}
+
+// Clear removes the cache file whatever it holds, including a file that
+// can't be decrypted any more
+func Clear() error {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return err
+	}
+	s := &store{path: filepath.Join(cacheDir, dir, filename)}
+	if _, err := os.Stat(filepath.Dir(s.path)); errors.Is(err, os.ErrNotExist) {
+		return nil
 	}
 
-	// Try to load existing cache file
-	cachePath := filepath.Join(appCacheDir, filename)
-	data, err := os.ReadFile(cachePath)
+	unlock, err := s.lock(true)
 	if err != nil {
-		// File doesn't exist or can't be read - start with empty cache
-		if !os.IsNotExist(err) {
-			// Log non-existence errors but continue with empty cache
-			return fc, nil
+		return err
+	}
+	defer unlock()
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// lookup returns the entry of kind and key of the cache's identity
+func (fc *Cache) lookup(kind string, key string) (Entry[json.RawMessage], bool) {
+	if fc.identity.IsZero() {
+		return Entry[json.RawMessage]{}, false
+	}
+
+	fc.s.mu.Lock()
+	defer fc.s.mu.Unlock()
+	fc.s.reload()
+
+	entry, exists := fc.s.entries[fc.identity.fileKey(kind, key)]
+	if !exists {
+		return Entry[json.RawMessage]{}, false
+	}
+	return entry.entry(fc.now()), true
+}
+
+func (fc *Cache) add(kind string, key string, value json.RawMessage, ttl time.Duration) error {
+	if fc.identity.IsZero() {
+		return nil
+	}
+
+	now := fc.now()
+	return fc.s.update(func(entries map[string]cache) bool {
+		entries[fc.identity.fileKey(kind, key)] = cache{
+			Kind:     kind,
+			Key:      key,
+			Identity: fc.identity,
+			Value:    value,
+			Expires:  now.Add(ttl),
+			Cached:   now,
 		}
-		return fc, nil
+		return true
+	})
+}
+
+func (fc *Cache) delete(kind string, key string) error {
+	if fc.identity.IsZero() {
+		return nil
 	}
 
-	// Deserialize cache data
-	if err := json.Unmarshal(data, &fc.entries); err != nil {
-		// Corrupted cache - start fresh
-		return fc, nil
+	return fc.s.update(func(entries map[string]cache) bool {
+		fileKey := fc.identity.fileKey(kind, key)
+		if _, exists := entries[fileKey]; !exists {
+			return false
+		}
+		delete(entries, fileKey)
+		return true
+	})
+}
+
+// Entries returns the entries of every identity and kind, expired ones
+// included, ordered by identity, kind and key
+func (fc *Cache) Entries() []Entry[json.RawMessage] {
+	fc.s.mu.Lock()
+	defer fc.s.mu.Unlock()
+	fc.s.reload()
+
+	now := fc.now()
+	entries := make([]Entry[json.RawMessage], 0, len(fc.s.entries))
+	for _, entry := range fc.s.entries {
+		entries = append(entries, entry.entry(now))
 	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Identity.String() != b.Identity.String() {
+			return a.Identity.String() < b.Identity.String()
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Key < b.Key
+	})
+	return entries
+}
 
-	return fc, nil
+// Purge removes the entries of any identity that match and returns how
+// many were removed
+func (fc *Cache) Purge(match func(Entry[json.RawMessage]) bool) (int, error) {
+	now := fc.now()
+	purged := 0
+	err := fc.s.update(func(entries map[string]cache) bool {
+		for fileKey, entry := range entries {
+			if match(entry.entry(now)) {
+				delete(entries, fileKey)
+				purged++
+			}
+		}
+		return purged > 0
+	})
+	return purged, err
 }
 
-func (fc *Cache) Get(key string) ([]appconfig.Result, bool) {
-	entry, exists := fc.entries[key]
-	if !exists {
-		return nil, false
+func (c cache) entry(now time.Time) Entry[json.RawMessage] {
+	return Entry[json.RawMessage]{
+		Kind:     c.Kind,
+		Key:      c.Key,
+		Identity: c.Identity,
+		Value:    c.Value,
+		Cached:   c.Cached,
+		Expires:  c.Expires,
+		Stale:    now.After(c.Expires),
 	}
+}
+
+// reload reads the file again if another process changed it, s.mu must be
+// held
+func (s *store) reload() error {
+	unlock, err := s.lock(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.load()
+}
+
+// update applies change to the latest entries and writes them back if
+// change reports a change. Changes of other processes in the meantime are
+// kept.
+func (s *store) update(change func(entries map[string]cache) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	// Check if cache entry has expired
-	if time.Now().After(entry.Expires) {
-		delete(fc.entries, key)
-		return nil, false
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	return entry.Value, true
+	err = s.load()
+	if errors.Is(err, errCorrupt) {
+		// keep the broken file around to look at, it isn't read again
+		if err := os.Rename(s.path, s.path+".corrupt"); err != nil {
+			return err
+		}
+	} else if err != nil && !errors.Is(err, ErrNewerVersion) {
+		return err
+	}
+	if s.sealed {
+		return ErrSealed
+	}
+
+	if !change(s.entries) {
+		return nil
+	}
+	if s.newer {
+		return ErrNewerVersion
+	}
+	return s.write()
 }
 
-func (fc *Cache) Add(key string, value []appconfig.Result) error {
-	fc.entries[key] = cache{
-		Value:   value,
-		Expires: time.Now().Add(ttl),
+// load reads the file unless the entries are already up to date with it,
+// the lock file must be held
+func (s *store) load() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		if s.size != 0 || !s.modTime.IsZero() {
+			// another process removed the file
+			s.entries = make(map[string]cache)
+			s.size, s.modTime = 0, time.Time{}
+		}
+		s.sealed = false
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() == s.size && info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	entries, err := decode(data, s.crypt)
+	if errors.Is(err, ErrNewerVersion) {
+		s.newer = true
+		return err
+	}
+	if errors.Is(err, ErrSealed) {
+		// can't be read with this key, nothing is served or written
+		s.entries = make(map[string]cache)
+		s.size, s.modTime = info.Size(), info.ModTime()
+		s.sealed = true
+		return err
+	}
+	if err != nil {
+		s.entries = make(map[string]cache)
+		s.size, s.modTime = 0, time.Time{}
+		return fmt.Errorf("%w: %v", errCorrupt, err)
 	}
 
-	return fc.persist()
+	s.entries = entries
+	s.size, s.modTime = info.Size(), info.ModTime()
+	s.sealed = false
+	return nil
 }
 
-func (fc *Cache) persist() error {
-	cacheDir, err := os.UserCacheDir()
+func decode(data []byte, c *crypt) (map[string]cache, error) {
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	if f.Version <= version && f.Sealed != nil {
+		if c == nil {
+			return nil, ErrSealed
+		}
+		plain, err := c.open(f.Sealed, f.Salt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(plain, &f.Entries); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case f.Version > version:
+		return nil, fmt.Errorf("%w (version %d)", ErrNewerVersion, f.Version)
+	case f.Version == 0:
+		// written before the file had a version
+		if err := json.Unmarshal(data, &f.Entries); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Entries == nil {
+		f.Entries = make(map[string]cache)
+	}
+	if f.Version >= version {
+		return f.Entries, nil
+	}
+
+	entries := make(map[string]cache, len(f.Entries))
+	for key, entry := range f.Entries {
+		// versions before 3 only cached flags
+		if f.Version < 3 {
+			entry.Kind = Flags.name
+		}
+		if f.Version < 2 {
+			// kept so they can be listed and purged, without an identity
+			// they never match a lookup
+			entry.Key = key
+			entries[key] = entry
+			continue
+		}
+		entries[entry.Identity.fileKey(entry.Kind, entry.Key)] = entry
+	}
+	return entries, nil
+}
+
+// write replaces the file in one rename, readers never see half of it
+func (s *store) write() error {
+	f := file{Version: version, Entries: s.entries}
+	if s.crypt != nil {
+		plain, err := json.Marshal(s.entries)
+		if err != nil {
+			return err
+		}
+		if f.Sealed, f.Salt, err = s.crypt.seal(plain); err != nil {
+			return err
+		}
+		f.Entries = nil
+	}
+	data, err := json.Marshal(f)
 	if err != nil {
 		return err
 	}
 
-	cachePath := filepath.Join(cacheDir, dir, filename)
-	data, err := json.Marshal(fc.entries)
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filename+".*.tmp")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	return os.WriteFile(cachePath, data, 0644)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// flags may hold internal endpoints and customer ids, other users
+	// can't read them
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.size, s.modTime = info.Size(), info.ModTime()
+	return nil
+}
+
+// lock takes the lock file shared by every process using the cache, it is
+// released by the returned func
+func (s *store) lock(exclusive bool) (func(), error) {
+	f, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f, exclusive); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
 }
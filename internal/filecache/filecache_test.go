@@ -0,0 +1,444 @@
+package filecache_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/filecache"
+)
+
+// cacheFile points the user cache dir at a temp dir and returns the path of
+// the cache file in it
+func cacheFile(t *testing.T) string {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(cacheDir, "LazyFlags", ".cache")
+}
+
+var testIdentity = filecache.Identity{Account: "123456789012", Region: "us-east-1"}
+
+func newCache(t *testing.T, opts ...filecache.Option) *filecache.Cache {
+	t.Helper()
+	fc, err := filecache.New(append([]filecache.Option{filecache.WithIdentity(testIdentity)}, opts...)...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return fc
+}
+
+func results(envName string) []appconfig.Result {
+	return []appconfig.Result{{EnvId: envName + "1", EnvName: envName, Flags: appconfig.Flags{}}}
+}
+
+func TestCacheSharedBetweenProcesses(t *testing.T) {
+	cacheFile(t)
+	// each Cache reads and writes the file like a separate process would
+	first, second := newCache(t), newCache(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fc := first
+			if i%2 == 1 {
+				fc = second
+			}
+			if err := filecache.Add(fc, filecache.Flags, fmt.Sprintf("app:config%d", i), results("development"), time.Minute); err != nil {
+				t.Errorf("Add: %v", err)
+			}
+			filecache.Get(fc, filecache.Flags, "app:config0")
+		}(i)
+	}
+	wg.Wait()
+
+	for _, fc := range []*filecache.Cache{first, second, newCache(t)} {
+		for i := 0; i < 20; i++ {
+			if _, ok := filecache.Get(fc, filecache.Flags, fmt.Sprintf("app:config%d", i)); !ok {
+				t.Errorf("expected every cache to see config%d", i)
+			}
+		}
+	}
+
+	if err := filecache.Delete(first, filecache.Flags, "app:config1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok := filecache.Get(second, filecache.Flags, "app:config1"); ok {
+		t.Errorf("expected the delete of another process to be seen")
+	}
+}
+
+func TestCacheFileVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		// the entry of the file is served
+		found bool
+		// the entry of the file is kept and listed
+		kept bool
+		// Add returns this error and leaves the file alone
+		addErr error
+		// the file was moved aside
+		corrupt bool
+	}{
+		{
+			name:    "should keep but not serve files without a version",
+			content: `{"app:config":{"value":[{"EnvId":"dev1","EnvName":"development"}],"expires":"2999-01-01T00:00:00Z"}}`,
+			kept:    true,
+		},
+		{
+			name:    "should keep but not serve entries without an identity",
+			content: `{"version":1,"entries":{"app:config":{"value":[],"expires":"2999-01-01T00:00:00Z"}}}`,
+			kept:    true,
+		},
+		{
+			name:    "should read flags of version 2",
+			content: `{"version":2,"entries":{"123456789012/us-east-1//app:config":{"key":"app:config","identity":{"account":"123456789012","region":"us-east-1"},"value":[],"expires":"2999-01-01T00:00:00Z"}}}`,
+			found:   true,
+			kept:    true,
+		},
+		{
+			name:    "should read version 3",
+			content: `{"version":3,"entries":{"123456789012/us-east-1//flags/app:config":{"kind":"flags","key":"app:config","identity":{"account":"123456789012","region":"us-east-1"},"value":[],"expires":"2999-01-01T00:00:00Z"}}}`,
+			found:   true,
+			kept:    true,
+		},
+		{
+			name:    "should not overwrite a newer version",
+			content: `{"version":99,"entries":{"app:config":{"value":[],"expires":"2999-01-01T00:00:00Z"}}}`,
+			addErr:  filecache.ErrNewerVersion,
+		},
+		{
+			name:    "should keep a corrupt file aside",
+			content: `{"app:config":{"value":[{"EnvId`,
+			corrupt: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := cacheFile(t)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			fc := newCache(t)
+			if _, ok := filecache.Get(fc, filecache.Flags, "app:config"); ok != tt.found {
+				t.Errorf("Get() found = %v, expected %v", ok, tt.found)
+			}
+
+			err := filecache.Add(fc, filecache.Flags, "app:other", results("staging"), time.Minute)
+			if !errors.Is(err, tt.addErr) {
+				t.Fatalf("Add() error = %v, expected %v", err, tt.addErr)
+			}
+			if tt.addErr != nil {
+				if data, _ := os.ReadFile(path); string(data) != tt.content {
+					t.Errorf("expected the file to be left alone, got %s", data)
+				}
+				return
+			}
+
+			if _, err := os.Stat(path + ".corrupt"); (err == nil) != tt.corrupt {
+				t.Errorf("corrupt copy exists = %v, expected %v", err == nil, tt.corrupt)
+			}
+			reopened := newCache(t)
+			if _, ok := filecache.Get(reopened, filecache.Flags, "app:other"); !ok {
+				t.Errorf("expected the new entry to be written")
+			}
+			kept := false
+			for _, entry := range reopened.Entries() {
+				kept = kept || entry.Key == "app:config"
+			}
+			if kept != tt.kept {
+				t.Errorf("existing entry kept = %v, expected %v", kept, tt.kept)
+			}
+		})
+	}
+}
+
+func TestCacheFileVersion3Kinds(t *testing.T) {
+	path := cacheFile(t)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := `{"version":3,"entries":{` +
+		`"123456789012/us-east-1//flags/app:config":{"kind":"flags","key":"app:config","identity":{"account":"123456789012","region":"us-east-1"},"value":[],"expires":"2999-01-01T00:00:00Z"},` +
+		`"123456789012/us-east-1//apps/all":{"kind":"apps","key":"all","identity":{"account":"123456789012","region":"us-east-1"},"value":[{"Id":"app1","Name":"wordle"}],"expires":"2999-01-01T00:00:00Z"},` +
+		`"123456789012/us-east-1//environments/app1":{"kind":"environments","key":"app1","identity":{"account":"123456789012","region":"us-east-1"},"value":[],"expires":"2999-01-01T00:00:00Z"}` +
+		`}}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fc := newCache(t)
+	if _, ok := filecache.Get(fc, filecache.Flags, "app:config"); !ok {
+		t.Errorf("expected the flags entry to be served")
+	}
+	apps, ok := filecache.Get(fc, filecache.Apps, "all")
+	if !ok || len(apps) != 1 || *apps[0].Name != "wordle" {
+		t.Errorf("expected the apps entry to be served, got %+v", apps)
+	}
+	if _, ok := filecache.Get(fc, filecache.Environments, "app1"); !ok {
+		t.Errorf("expected the environments entry to be served")
+	}
+	for _, key := range []string{"all", "app1"} {
+		if _, ok := filecache.Get(fc, filecache.Flags, key); ok {
+			t.Errorf("expected %s not to be relabelled as flags", key)
+		}
+	}
+}
+
+func TestCacheIdentities(t *testing.T) {
+	cacheFile(t)
+	prod := newCache(t)
+	other := newCache(t, filecache.WithIdentity(filecache.Identity{Account: "210987654321", Region: "us-east-1"}))
+	otherRegion := newCache(t, filecache.WithIdentity(filecache.Identity{Account: "123456789012", Region: "eu-west-1"}))
+	unknown, err := filecache.New()
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if err := filecache.Add(prod, filecache.Flags, "app:config", results("production"), time.Minute); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := filecache.Add(unknown, filecache.Flags, "app:config", results("development"), time.Minute); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	if value, ok := filecache.Get(prod, filecache.Flags, "app:config"); !ok || value[0].EnvName != "production" {
+		t.Errorf("expected the flags of the identity that added them, got %v", value)
+	}
+	for name, fc := range map[string]*filecache.Cache{"other account": other, "other region": otherRegion, "no identity": unknown} {
+		if _, ok := filecache.Get(fc, filecache.Flags, "app:config"); ok {
+			t.Errorf("%s: expected the flags of another identity not to be served", name)
+		}
+	}
+
+	entries := other.Entries()
+	if len(entries) != 1 || entries[0].Identity != testIdentity || entries[0].Key != "app:config" {
+		t.Errorf("expected one entry of the identity that added it, got %+v", entries)
+	}
+
+	filecache.Add(other, filecache.Flags, "app:config", results("staging"), time.Minute)
+	purged, err := unknown.Purge(func(e filecache.Entry[json.RawMessage]) bool { return e.Identity.Account == "123456789012" })
+	if err != nil || purged != 1 {
+		t.Fatalf("Purge() = %d, %v, expected 1 entry purged", purged, err)
+	}
+	if _, ok := filecache.Get(prod, filecache.Flags, "app:config"); ok {
+		t.Errorf("expected the purged entry to be gone")
+	}
+	if _, ok := filecache.Get(other, filecache.Flags, "app:config"); !ok {
+		t.Errorf("expected the entry of the other account to be kept")
+	}
+}
+
+func TestCacheKinds(t *testing.T) {
+	cacheFile(t)
+	fc := newCache(t)
+
+	apps := []appconfig.App{{Id: aws.String("wordle1"), Name: aws.String("Wordle")}}
+	if err := filecache.Add(fc, filecache.Apps, "", apps, time.Hour); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := filecache.Add(fc, filecache.Flags, "", results("development"), time.Minute); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	cached, ok := filecache.Get(newCache(t), filecache.Apps, "")
+	if !ok || !reflect.DeepEqual(cached, apps) {
+		t.Errorf("expected the apps back, got %+v", cached)
+	}
+	if flags, ok := filecache.Get(fc, filecache.Flags, ""); !ok || flags[0].EnvName != "development" {
+		t.Errorf("expected kinds not to share keys, got %+v", flags)
+	}
+
+	if err := filecache.Delete(fc, filecache.Apps, ""); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok := filecache.Get(fc, filecache.Apps, ""); ok {
+		t.Errorf("expected the apps to be deleted")
+	}
+	if _, ok := filecache.Get(fc, filecache.Flags, ""); !ok {
+		t.Errorf("expected the flags to be kept")
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	cacheFile(t)
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	fc := newCache(t, filecache.WithClock(func() time.Time { return now }))
+
+	filecache.Add(fc, filecache.Flags, "app:config", results("development"), time.Minute)
+	now = now.Add(2 * time.Minute)
+
+	if _, ok := filecache.Get(fc, filecache.Flags, "app:config"); ok {
+		t.Errorf("expected Get to skip an expired entry")
+	}
+	entry, ok := filecache.Lookup(fc, filecache.Flags, "app:config")
+	if !ok || !entry.Stale || entry.Value[0].EnvName != "development" {
+		t.Errorf("expected Lookup to return the expired entry marked stale, got %+v", entry)
+	}
+}
+
+func TestCacheEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	otherKey := bytes.Repeat([]byte{2}, 32)
+
+	tests := []struct {
+		name string
+		// the cache that writes the file, nil options write plaintext
+		writer      []filecache.Option
+		reader      []filecache.Option
+		expectedErr error
+	}{
+		{
+			name:   "should read a file encrypted with its key",
+			writer: []filecache.Option{filecache.WithKey(key)},
+			reader: []filecache.Option{filecache.WithKey(key)},
+		},
+		{
+			name:   "should read a file encrypted with its passphrase",
+			writer: []filecache.Option{filecache.WithPassphrase("correct horse")},
+			reader: []filecache.Option{filecache.WithPassphrase("correct horse")},
+		},
+		{
+			name:        "should refuse a file encrypted with another key",
+			writer:      []filecache.Option{filecache.WithKey(key)},
+			reader:      []filecache.Option{filecache.WithKey(otherKey)},
+			expectedErr: filecache.ErrSealed,
+		},
+		{
+			name:        "should refuse a file encrypted with another passphrase",
+			writer:      []filecache.Option{filecache.WithPassphrase("correct horse")},
+			reader:      []filecache.Option{filecache.WithPassphrase("battery staple")},
+			expectedErr: filecache.ErrSealed,
+		},
+		{
+			name:        "should refuse an encrypted file without a key",
+			writer:      []filecache.Option{filecache.WithKey(key)},
+			expectedErr: filecache.ErrSealed,
+		},
+		{
+			name:   "should read a plaintext file with a key",
+			reader: []filecache.Option{filecache.WithKey(key)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := cacheFile(t)
+			writer := newCache(t, tt.writer...)
+			if err := filecache.Add(writer, filecache.Flags, "app:config", results("development"), time.Minute); err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+			written, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encrypted := !bytes.Contains(written, []byte("development")); encrypted != (tt.writer != nil) {
+				t.Errorf("file encrypted = %v, expected %v", encrypted, tt.writer != nil)
+			}
+
+			reader, err := filecache.New(append([]filecache.Option{filecache.WithIdentity(testIdentity)}, tt.reader...)...)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("New: %v, expected %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr != nil {
+				// the file is kept for the right key
+				if data, _ := os.ReadFile(path); !bytes.Equal(data, written) {
+					t.Errorf("expected the file to be left alone")
+				}
+				return
+			}
+			if _, ok := filecache.Get(reader, filecache.Flags, "app:config"); !ok {
+				t.Fatalf("expected Get to find the entry written")
+			}
+			if err := filecache.Add(reader, filecache.Flags, "app:other", results("staging"), time.Minute); err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+			if _, ok := filecache.Get(newCache(t, tt.reader...), filecache.Flags, "app:other"); !ok {
+				t.Errorf("expected the file to be readable with the reader's key")
+			}
+		})
+	}
+}
+
+func TestCacheSealedByAnotherProcess(t *testing.T) {
+	path := cacheFile(t)
+	plain := newCache(t)
+	if err := filecache.Add(plain, filecache.Flags, "app:config", results("development"), time.Minute); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	// another process encrypts the file
+	encrypted := newCache(t, filecache.WithKey(bytes.Repeat([]byte{1}, 32)))
+	if err := filecache.Add(encrypted, filecache.Flags, "app:config", results("staging"), time.Minute); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := filecache.Get(plain, filecache.Flags, "app:config"); ok {
+		t.Errorf("expected nothing to be served from a file that can't be decrypted")
+	}
+	if err := filecache.Add(plain, filecache.Flags, "app:other", results("production"), time.Minute); !errors.Is(err, filecache.ErrSealed) {
+		t.Errorf("Add: %v, expected %v", err, filecache.ErrSealed)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, written) {
+		t.Errorf("expected the file to be left alone")
+	}
+
+	if err := filecache.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if err := filecache.Add(plain, filecache.Flags, "app:other", results("production"), time.Minute); err != nil {
+		t.Errorf("Add after Clear: %v", err)
+	}
+	if _, ok := filecache.Get(newCache(t), filecache.Flags, "app:other"); !ok {
+		t.Errorf("expected a new plaintext file after Clear")
+	}
+}
+
+func TestCacheFilePermissions(t *testing.T) {
+	path := cacheFile(t)
+	if err := filecache.Add(newCache(t), filecache.Flags, "app:config", results("development"), time.Minute); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	for _, name := range []string{path, path + ".lock"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("%s: permissions = %o, expected 600", filepath.Base(name), perm)
+		}
+	}
+}
+
+func TestCacheRejectsShortKey(t *testing.T) {
+	cacheFile(t)
+	if _, err := filecache.New(filecache.WithKey([]byte("short"))); err == nil {
+		t.Errorf("expected a key that isn't 32 bytes to be rejected")
+	}
+}
@@ -0,0 +1,74 @@
+package filecache
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+)
+
+// Kind is a type of cached value, keys of different kinds don't clash
+type Kind[T any] struct {
+	name string
+}
+
+var (
+	// flags of every environment, keyed by appId:configId
+	Flags = Kind[[]appconfig.Result]{name: "flags"}
+	// applications, under an empty key
+	Apps = Kind[[]appconfig.App]{name: "apps"}
+	// feature flag configuration profiles, keyed by appId
+	Profiles = Kind[[]appconfig.AppFlagConfig]{name: "profiles"}
+	// environments, keyed by appId
+	Environments = Kind[[]appconfig.AppEnvironments]{name: "environments"}
+)
+
+func (k Kind[T]) String() string {
+	return k.name
+}
+
+// Lookup returns the entry of key, also once it expired
+func Lookup[T any](fc *Cache, kind Kind[T], key string) (Entry[T], bool) {
+	raw, ok := fc.lookup(kind.name, key)
+	if !ok {
+		return Entry[T]{}, false
+	}
+
+	var value T
+	if err := json.Unmarshal(raw.Value, &value); err != nil {
+		// written by another version with a different shape
+		return Entry[T]{}, false
+	}
+	return Entry[T]{
+		Kind:     raw.Kind,
+		Key:      raw.Key,
+		Identity: raw.Identity,
+		Value:    value,
+		Cached:   raw.Cached,
+		Expires:  raw.Expires,
+		Stale:    raw.Stale,
+	}, true
+}
+
+// Get returns the value of key if it hasn't expired
+func Get[T any](fc *Cache, kind Kind[T], key string) (T, bool) {
+	entry, ok := Lookup(fc, kind, key)
+	if !ok || entry.Stale {
+		var zero T
+		return zero, false
+	}
+	return entry.Value, true
+}
+
+// Add caches value under key for ttl
+func Add[T any](fc *Cache, kind Kind[T], key string, value T, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return fc.add(kind.name, key, data, ttl)
+}
+
+func Delete[T any](fc *Cache, kind Kind[T], key string) error {
+	return fc.delete(kind.name, key)
+}
@@ -0,0 +1,337 @@
+// Package localserver serves the AppConfig and AppConfigData HTTP APIs from
+// a fake backend, so the tool can be demoed, developed and tested without an
+// AWS account.
+package localserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/appconfig"
+	"github.com/aws/aws-sdk-go-v2/service/appconfigdata"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig/fake"
+)
+
+// NewHandler serves the AppConfig and AppConfigData REST APIs from store.
+// Both APIs share one endpoint, their paths don't overlap. Requests are not
+// authenticated, any credentials are accepted.
+func NewHandler(store *fake.Backend) http.Handler {
+	h := &handler{store: store}
+	mux := http.NewServeMux()
+
+	const (
+		app      = "/applications/{ApplicationId}"
+		profile  = app + "/configurationprofiles/{ConfigurationProfileId}"
+		versions = profile + "/hostedconfigurationversions"
+		env      = app + "/environments/{EnvironmentId}"
+	)
+
+	mux.HandleFunc("GET /applications", h.listApplications)
+	mux.HandleFunc("GET "+app+"/configurationprofiles", h.listConfigurationProfiles)
+	mux.HandleFunc("GET "+profile, h.getConfigurationProfile)
+	mux.HandleFunc("GET "+app+"/environments", h.listEnvironments)
+	mux.HandleFunc("GET "+versions, h.listHostedConfigurationVersions)
+	mux.HandleFunc("POST "+versions, h.createHostedConfigurationVersion)
+	mux.HandleFunc("GET "+versions+"/{VersionNumber}", h.getHostedConfigurationVersion)
+	mux.HandleFunc("GET "+env+"/deployments", h.listDeployments)
+	mux.HandleFunc("POST "+env+"/deployments", h.startDeployment)
+	mux.HandleFunc("GET "+env+"/deployments/{DeploymentNumber}", h.getDeployment)
+	mux.HandleFunc("DELETE "+env+"/deployments/{DeploymentNumber}", h.stopDeployment)
+	mux.HandleFunc("GET /tags/{ResourceArn...}", h.listTagsForResource)
+
+	mux.HandleFunc("POST /configurationsessions", h.startConfigurationSession)
+	mux.HandleFunc("GET /configuration", h.getLatestConfiguration)
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, &fake.APIError{Code: "UnknownOperationException", Message: r.Method + " " + r.URL.Path + " is not supported by the local server", Status: http.StatusNotFound})
+	})
+
+	return logRequests(mux)
+}
+
+type handler struct {
+	store *fake.Backend
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s", r.Method, r.URL.RequestURI())
+		next.ServeHTTP(w, r)
+	})
+}
+
+func badRequest(format string, args ...any) error {
+	return &fake.APIError{Code: "BadRequestException", Message: fmt.Sprintf(format, args...), Status: http.StatusBadRequest}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *fake.APIError
+	if !errors.As(err, &apiErr) {
+		apiErr = &fake.APIError{Code: "InternalServerException", Message: err.Error(), Status: http.StatusInternalServerError}
+	}
+	w.Header().Set("X-Amzn-Errortype", apiErr.Code)
+	writeJSON(w, apiErr.Status, map[string]string{"__type": apiErr.Code, "message": apiErr.Message})
+}
+
+func optionalInt32(r *http.Request, name string) *int32 {
+	v, err := strconv.ParseInt(r.URL.Query().Get(name), 10, 32)
+	if err != nil {
+		return nil
+	}
+	return aws.Int32(int32(v))
+}
+
+func optionalString(r *http.Request, name string) *string {
+	if !r.URL.Query().Has(name) {
+		return nil
+	}
+	return aws.String(r.URL.Query().Get(name))
+}
+
+func pathInt32(r *http.Request, name string) (*int32, error) {
+	v, err := strconv.ParseInt(r.PathValue(name), 10, 32)
+	if err != nil {
+		return nil, badRequest("%s must be a number", name)
+	}
+	return aws.Int32(int32(v)), nil
+}
+
+func (h *handler) listApplications(w http.ResponseWriter, r *http.Request) {
+	out, err := h.store.ListApplications(r.Context(), &appconfig.ListApplicationsInput{})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"Items": out.Items})
+}
+
+func (h *handler) listConfigurationProfiles(w http.ResponseWriter, r *http.Request) {
+	out, err := h.store.ListConfigurationProfiles(r.Context(), &appconfig.ListConfigurationProfilesInput{
+		ApplicationId: aws.String(r.PathValue("ApplicationId")),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"Items": out.Items})
+}
+
+func (h *handler) getConfigurationProfile(w http.ResponseWriter, r *http.Request) {
+	out, err := h.store.GetConfigurationProfile(r.Context(), &appconfig.GetConfigurationProfileInput{
+		ApplicationId:          aws.String(r.PathValue("ApplicationId")),
+		ConfigurationProfileId: aws.String(r.PathValue("ConfigurationProfileId")),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ApplicationId": out.ApplicationId,
+		"Id":            out.Id,
+		"Name":          out.Name,
+		"LocationUri":   out.LocationUri,
+		"Type":          out.Type,
+	})
+}
+
+func (h *handler) listEnvironments(w http.ResponseWriter, r *http.Request) {
+	out, err := h.store.ListEnvironments(r.Context(), &appconfig.ListEnvironmentsInput{
+		ApplicationId: aws.String(r.PathValue("ApplicationId")),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"Items": out.Items})
+}
+
+func (h *handler) listHostedConfigurationVersions(w http.ResponseWriter, r *http.Request) {
+	out, err := h.store.ListHostedConfigurationVersions(r.Context(), &appconfig.ListHostedConfigurationVersionsInput{
+		ApplicationId:          aws.String(r.PathValue("ApplicationId")),
+		ConfigurationProfileId: aws.String(r.PathValue("ConfigurationProfileId")),
+		MaxResults:             optionalInt32(r, "max_results"),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"Items": out.Items})
+}
+
+// hosted versions are returned as the raw content with metadata in headers
+func writeHostedVersion(w http.ResponseWriter, status int, appId, profileId, contentType, description *string, number int32, content []byte) {
+	w.Header().Set("Application-Id", aws.ToString(appId))
+	w.Header().Set("Configuration-Profile-Id", aws.ToString(profileId))
+	w.Header().Set("Content-Type", aws.ToString(contentType))
+	w.Header().Set("Description", aws.ToString(description))
+	w.Header().Set("Version-Number", strconv.Itoa(int(number)))
+	w.WriteHeader(status)
+	w.Write(content)
+}
+
+func (h *handler) getHostedConfigurationVersion(w http.ResponseWriter, r *http.Request) {
+	number, err := pathInt32(r, "VersionNumber")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	out, err := h.store.GetHostedConfigurationVersion(r.Context(), &appconfig.GetHostedConfigurationVersionInput{
+		ApplicationId:          aws.String(r.PathValue("ApplicationId")),
+		ConfigurationProfileId: aws.String(r.PathValue("ConfigurationProfileId")),
+		VersionNumber:          number,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeHostedVersion(w, http.StatusOK, out.ApplicationId, out.ConfigurationProfileId, out.ContentType, out.Description, out.VersionNumber, out.Content)
+}
+
+func (h *handler) createHostedConfigurationVersion(w http.ResponseWriter, r *http.Request) {
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, badRequest("failed to read body: %v", err))
+		return
+	}
+
+	in := &appconfig.CreateHostedConfigurationVersionInput{
+		ApplicationId:          aws.String(r.PathValue("ApplicationId")),
+		ConfigurationProfileId: aws.String(r.PathValue("ConfigurationProfileId")),
+		Content:                content,
+		ContentType:            aws.String(r.Header.Get("Content-Type")),
+		Description:            aws.String(r.Header.Get("Description")),
+	}
+	if v, err := strconv.ParseInt(r.Header.Get("Latest-Version-Number"), 10, 32); err == nil {
+		in.LatestVersionNumber = aws.Int32(int32(v))
+	}
+
+	out, err := h.store.CreateHostedConfigurationVersion(r.Context(), in)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeHostedVersion(w, http.StatusCreated, out.ApplicationId, out.ConfigurationProfileId, out.ContentType, out.Description, out.VersionNumber, out.Content)
+}
+
+func (h *handler) listDeployments(w http.ResponseWriter, r *http.Request) {
+	out, err := h.store.ListDeployments(r.Context(), &appconfig.ListDeploymentsInput{
+		ApplicationId: aws.String(r.PathValue("ApplicationId")),
+		EnvironmentId: aws.String(r.PathValue("EnvironmentId")),
+		MaxResults:    optionalInt32(r, "max_results"),
+		NextToken:     optionalString(r, "next_token"),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"Items": out.Items, "NextToken": out.NextToken})
+}
+
+func (h *handler) startDeployment(w http.ResponseWriter, r *http.Request) {
+	in := &appconfig.StartDeploymentInput{}
+	if err := json.NewDecoder(r.Body).Decode(in); err != nil {
+		writeError(w, badRequest("invalid request body: %v", err))
+		return
+	}
+	in.ApplicationId = aws.String(r.PathValue("ApplicationId"))
+	in.EnvironmentId = aws.String(r.PathValue("EnvironmentId"))
+
+	out, err := h.store.StartDeployment(r.Context(), in)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, out)
+}
+
+func (h *handler) getDeployment(w http.ResponseWriter, r *http.Request) {
+	number, err := pathInt32(r, "DeploymentNumber")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	out, err := h.store.GetDeployment(r.Context(), &appconfig.GetDeploymentInput{
+		ApplicationId:    aws.String(r.PathValue("ApplicationId")),
+		EnvironmentId:    aws.String(r.PathValue("EnvironmentId")),
+		DeploymentNumber: number,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *handler) stopDeployment(w http.ResponseWriter, r *http.Request) {
+	number, err := pathInt32(r, "DeploymentNumber")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	out, err := h.store.StopDeployment(r.Context(), &appconfig.StopDeploymentInput{
+		ApplicationId:    aws.String(r.PathValue("ApplicationId")),
+		EnvironmentId:    aws.String(r.PathValue("EnvironmentId")),
+		DeploymentNumber: number,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, out)
+}
+
+func (h *handler) listTagsForResource(w http.ResponseWriter, r *http.Request) {
+	out, err := h.store.ListTagsForResource(r.Context(), &appconfig.ListTagsForResourceInput{
+		ResourceArn: aws.String(r.PathValue("ResourceArn")),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"Tags": out.Tags})
+}
+
+func (h *handler) startConfigurationSession(w http.ResponseWriter, r *http.Request) {
+	in := &appconfigdata.StartConfigurationSessionInput{}
+	if err := json.NewDecoder(r.Body).Decode(in); err != nil {
+		writeError(w, badRequest("invalid request body: %v", err))
+		return
+	}
+
+	out, err := h.store.StartConfigurationSession(r.Context(), in)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"InitialConfigurationToken": out.InitialConfigurationToken})
+}
+
+func (h *handler) getLatestConfiguration(w http.ResponseWriter, r *http.Request) {
+	out, err := h.store.GetLatestConfiguration(r.Context(), &appconfigdata.GetLatestConfigurationInput{
+		ConfigurationToken: aws.String(r.URL.Query().Get("configuration_token")),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if out.ContentType != nil {
+		w.Header().Set("Content-Type", *out.ContentType)
+	}
+	w.Header().Set("Next-Poll-Configuration-Token", aws.ToString(out.NextPollConfigurationToken))
+	w.Header().Set("Next-Poll-Interval-In-Seconds", strconv.Itoa(int(out.NextPollIntervalInSeconds)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(out.Configuration)
+}
@@ -0,0 +1,189 @@
+package localserver_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig/fake"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/localserver"
+)
+
+// exercises the SDK's wire format against the handler
+func newTestClient(t *testing.T) *appconfig.Client {
+	t.Helper()
+	client, _ := newPolicyTestClient(t, appconfig.DefaultCallPolicy)
+	return client
+}
+
+func newPolicyTestClient(t *testing.T, policy appconfig.CallPolicy) (*appconfig.Client, *fake.Backend) {
+	t.Helper()
+
+	backend := fake.NewBackend(fake.DemoData())
+	server := httptest.NewServer(localserver.NewHandler(backend))
+	t.Cleanup(server.Close)
+
+	cfg := aws.Config{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("local", "local", ""),
+		BaseEndpoint: aws.String(server.URL),
+	}
+	policy.Apply(&cfg)
+	return appconfig.New(cfg), backend
+}
+
+func TestLocalServerReadFlags(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	apps, err := client.ListApps(ctx)
+	if err != nil {
+		t.Fatalf("ListApps: %v", err)
+	}
+	if len(apps) != 1 || *apps[0].Name != "Wordle" {
+		t.Fatalf("apps: %+v", apps)
+	}
+
+	configs, err := client.ListAppFlagConfigs(ctx, *apps[0].Id)
+	if err != nil {
+		t.Fatalf("ListAppFlagConfigs: %v", err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("configs: %+v", configs)
+	}
+
+	results, err := client.GetFlags(ctx, *apps[0].Id, *configs[0].Id)
+	if err != nil {
+		t.Fatalf("GetFlags: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 environments, got %d", len(results))
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Errorf("%s: %v", result.EnvName, result.Err)
+		}
+	}
+	if !results[0].Flags["dark_mode"].Enabled || results[2].Flags["dark_mode"].Enabled {
+		t.Errorf("unexpected dark_mode states: %+v", results)
+	}
+}
+
+func TestLocalServerSetFlag(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	deployment, err := client.SetFlag(ctx, "wordle1", "webflg1", "pro0001", "dark_mode", true, "")
+	if err != nil {
+		t.Fatalf("SetFlag: %v", err)
+	}
+	if deployment.Version != 4 {
+		t.Errorf("expected version 4, got %d", deployment.Version)
+	}
+
+	deployed, err := client.DeployedVersion(ctx, "wordle1", "webflg1", "pro0001")
+	if err != nil {
+		t.Fatalf("DeployedVersion: %v", err)
+	}
+	if deployed.Version != 4 {
+		t.Errorf("expected version 4 to be deployed, got %d", deployed.Version)
+	}
+
+	flags, err := client.GetLatestFlagConfig(ctx, "wordle1", "webflg1", "pro0001", 60)
+	if err != nil {
+		t.Fatalf("GetLatestFlagConfig: %v", err)
+	}
+	if !flags["dark_mode"].Enabled {
+		t.Errorf("expected dark_mode to be on in production")
+	}
+}
+
+func TestLocalServerConflict(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	doc, err := client.GetFlagDocument(ctx, "wordle1", "webflg1", 1)
+	if err != nil {
+		t.Fatalf("GetFlagDocument: %v", err)
+	}
+
+	// version 3 is the latest, creating on top of 2 must fail
+	if _, err := client.CreateFlagVersion(ctx, "wordle1", "webflg1", doc, "stale", 2); err == nil {
+		t.Errorf("expected a conflict creating a version on top of a stale one")
+	}
+	if _, err := client.CreateFlagVersion(ctx, "wordle1", "webflg1", doc, "fresh", 3); err != nil {
+		t.Errorf("CreateFlagVersion: %v", err)
+	}
+}
+
+func TestLocalServerPagesDeployments(t *testing.T) {
+	client, backend := newPolicyTestClient(t, appconfig.DefaultCallPolicy)
+	ctx := context.Background()
+
+	// push the deployment of webflg1 past the first page of 50
+	for i := range 50 {
+		if _, err := client.SetFlag(ctx, "wordle1", "apiflg1", "dev0001", "graphql_api", i%2 == 0, ""); err != nil {
+			t.Fatalf("SetFlag: %v", err)
+		}
+	}
+	before := backend.Calls("ListDeployments")
+
+	deployed, err := client.DeployedVersion(ctx, "wordle1", "webflg1", "dev0001")
+	if err != nil {
+		t.Fatalf("DeployedVersion: %v", err)
+	}
+	if deployed.Version != 1 {
+		t.Errorf("expected version 1 to be deployed, got %d", deployed.Version)
+	}
+	if pages := backend.Calls("ListDeployments") - before; pages != 2 {
+		t.Errorf("expected 2 pages of deployments, got %d", pages)
+	}
+}
+
+func TestCallPolicyRetriesThrottling(t *testing.T) {
+	// adaptive mode rate limits the client for a few seconds after a throttle
+	t.Parallel()
+
+	client, backend := newPolicyTestClient(t, appconfig.CallPolicy{MaxAttempts: 3, MaxBackoff: 10 * time.Millisecond})
+	backend.AddFault(fake.Fault{Operation: "ListApplications", Err: fake.ErrThrottling, Times: 2})
+
+	if _, err := client.ListApps(context.Background()); err != nil {
+		t.Fatalf("expected the third attempt to succeed: %v", err)
+	}
+	if calls := backend.Calls("ListApplications"); calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestCallPolicyGivesUp(t *testing.T) {
+	t.Parallel()
+
+	client, backend := newPolicyTestClient(t, appconfig.CallPolicy{MaxAttempts: 2, MaxBackoff: 10 * time.Millisecond})
+	backend.AddFault(fake.Fault{Operation: "ListApplications", Err: fake.ErrThrottling})
+
+	if _, err := client.ListApps(context.Background()); err == nil {
+		t.Fatalf("expected throttling to fail the call")
+	}
+	if calls := backend.Calls("ListApplications"); calls != 2 {
+		t.Errorf("expected 2 attempts, got %d", calls)
+	}
+}
+
+func TestCallPolicyTimeout(t *testing.T) {
+	client, backend := newPolicyTestClient(t, appconfig.CallPolicy{Timeout: 100 * time.Millisecond, MaxAttempts: 1})
+	backend.AddFault(fake.Fault{Operation: "ListApplications", Delay: time.Hour})
+
+	start := time.Now()
+	_, err := client.ListApps(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the call to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("call took %s, expected it to give up after the timeout", elapsed)
+	}
+}
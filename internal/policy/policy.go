@@ -0,0 +1,125 @@
+// Package policy checks a proposed change set against the rules of the
+// settings file before a new version is created.
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+)
+
+// Proposal is a change set about to be deployed to one environment
+type Proposal struct {
+	// empty for a version created for no environment in particular, only
+	// rules about the changes themselves apply to it
+	Env     string
+	Changes appconfig.ChangeSet
+	// flags deployed on, keyed by environment then flag
+	Enabled map[string]map[string]bool
+	// when the change is made, rules about the time of day read it in
+	// its location
+	Time time.Time
+}
+
+// Rule is one guardrail. Check returns why the proposal breaks it, nothing
+// if it doesn't.
+type Rule interface {
+	Name() string
+	Check(p Proposal) []string
+}
+
+// Builder makes a rule of its type from the settings file
+type Builder func(config settings.PolicyRule) (Rule, error)
+
+var builders = map[string]Builder{
+	"promotion":   newPromotion,
+	"max_changes": newMaxChanges,
+	"freeze":      newFreeze,
+	"key_pattern": newKeyPattern,
+}
+
+// Register adds a rule type, rules of the settings file with it are built
+// by build. It's meant to be called from init.
+func Register(ruleType string, build Builder) {
+	builders[ruleType] = build
+}
+
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.Rule, v.Message)
+}
+
+// Engine checks proposals against every rule
+type Engine struct {
+	rules []Rule
+}
+
+func NewEngine(rules ...Rule) *Engine {
+	return &Engine{rules: rules}
+}
+
+// New builds the rules of the settings file
+func New(config settings.Policy) (*Engine, error) {
+	engine := NewEngine()
+	for i, ruleConfig := range config.Rules {
+		build, ok := builders[ruleConfig.Type]
+		if !ok {
+			return nil, fmt.Errorf("policy.rules[%d]: unknown type %q, expected one of %s", i, ruleConfig.Type, strings.Join(ruleTypes(), ", "))
+		}
+		rule, err := build(ruleConfig)
+		if err != nil {
+			return nil, fmt.Errorf("policy.rules[%d]: %s: %w", i, ruleConfig.Type, err)
+		}
+		engine.rules = append(engine.rules, rule)
+	}
+	return engine, nil
+}
+
+// Empty reports whether there are no rules, proposals then don't need to
+// be built
+func (e *Engine) Empty() bool {
+	return e == nil || len(e.rules) == 0
+}
+
+// Check returns the violations of every rule, in the order of the rules
+func (e *Engine) Check(p Proposal) []Violation {
+	if e == nil {
+		return nil
+	}
+	var violations []Violation
+	for _, rule := range e.rules {
+		for _, message := range rule.Check(p) {
+			violations = append(violations, Violation{Rule: rule.Name(), Message: message})
+		}
+	}
+	return violations
+}
+
+// Error joins violations into one error, nil without any
+func Error(env string, violations []Violation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	lines := make([]string, len(violations))
+	for i, v := range violations {
+		lines[i] = "  " + v.String()
+	}
+	return fmt.Errorf("%s: refused by policy:\n%s", env, strings.Join(lines, "\n"))
+}
+
+func ruleTypes() []string {
+	types := make([]string, 0, len(builders))
+	for ruleType := range builders {
+		types = append(types, ruleType)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,147 @@
+package policy_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/policy"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+)
+
+func toggle(flag string, enabled bool) appconfig.FlagChange {
+	return appconfig.FlagChange{
+		Flag:   flag,
+		Kind:   appconfig.ChangeUpdate,
+		Fields: []appconfig.FieldChange{{Field: "enabled", Before: !enabled, After: enabled}},
+	}
+}
+
+func TestEngine(t *testing.T) {
+	engine, err := policy.New(settings.Policy{Rules: []settings.PolicyRule{
+		{Type: "promotion", From: "staging", To: "production"},
+		{Type: "max_changes", Max: 2},
+		{Type: "freeze", Environments: []string{"prod*"}, Days: []string{"friday"}, After: "15:00"},
+		{Type: "key_pattern", Pattern: "^[a-z_]+$"},
+	}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	enabled := map[string]map[string]bool{
+		"staging":    {"dark_mode": true},
+		"production": {},
+	}
+	// a Thursday and a Friday afternoon
+	thursday := time.Date(2025, 6, 5, 16, 0, 0, 0, time.UTC)
+	friday := time.Date(2025, 6, 6, 16, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		proposal policy.Proposal
+		expected []string
+	}{
+		{
+			name:     "should allow a flag on in staging",
+			proposal: policy.Proposal{Env: "production", Changes: appconfig.ChangeSet{toggle("dark_mode", true)}, Time: thursday},
+		},
+		{
+			name:     "should refuse a flag off in staging",
+			proposal: policy.Proposal{Env: "production", Changes: appconfig.ChangeSet{toggle("beta_feature", true)}, Time: thursday},
+			expected: []string{"promotion: beta_feature must be on in staging first"},
+		},
+		{
+			name:     "should allow turning a flag off",
+			proposal: policy.Proposal{Env: "production", Changes: appconfig.ChangeSet{toggle("beta_feature", false)}, Time: thursday},
+		},
+		{
+			name: "should refuse too many changes",
+			proposal: policy.Proposal{Env: "development", Time: thursday, Changes: appconfig.ChangeSet{
+				toggle("a", true), toggle("b", true), toggle("c", true),
+			}},
+			expected: []string{"max_changes: 3 flags changed, at most 2 per deployment"},
+		},
+		{
+			name:     "should refuse production on Friday afternoon",
+			proposal: policy.Proposal{Env: "production", Changes: appconfig.ChangeSet{toggle("dark_mode", false)}, Time: friday},
+			expected: []string{"freeze: production is frozen on Friday after 15:00"},
+		},
+		{
+			name:     "should allow staging on Friday afternoon",
+			proposal: policy.Proposal{Env: "staging", Changes: appconfig.ChangeSet{toggle("dark_mode", false)}, Time: friday},
+		},
+		{
+			name:     "should allow production on Friday morning",
+			proposal: policy.Proposal{Env: "production", Changes: appconfig.ChangeSet{toggle("dark_mode", false)}, Time: friday.Add(-6 * time.Hour)},
+		},
+		{
+			name:     "should not freeze a version created for no environment",
+			proposal: policy.Proposal{Changes: appconfig.ChangeSet{toggle("dark_mode", true)}, Time: friday},
+		},
+		{
+			name:     "should refuse keys that don't match",
+			proposal: policy.Proposal{Env: "development", Changes: appconfig.ChangeSet{toggle("DarkMode", true)}, Time: thursday},
+			expected: []string{"key_pattern: DarkMode doesn't match ^[a-z_]+$"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.proposal.Enabled = enabled
+			var got []string
+			for _, v := range engine.Check(tt.proposal) {
+				got = append(got, v.String())
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("violations = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule settings.PolicyRule
+	}{
+		{"should reject an unknown type", settings.PolicyRule{Type: "vibes"}},
+		{"should reject a promotion without environments", settings.PolicyRule{Type: "promotion", From: "staging"}},
+		{"should reject a max below 1", settings.PolicyRule{Type: "max_changes"}},
+		{"should reject an unknown day", settings.PolicyRule{Type: "freeze", Environments: []string{"production"}, Days: []string{"caturday"}}},
+		{"should reject an invalid time", settings.PolicyRule{Type: "freeze", Environments: []string{"production"}, After: "3pm"}},
+		{"should reject an invalid pattern", settings.PolicyRule{Type: "key_pattern", Pattern: "("}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := policy.New(settings.Policy{Rules: []settings.PolicyRule{tt.rule}}); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+type noFridays struct{}
+
+func (noFridays) Name() string { return "no_fridays" }
+
+func (noFridays) Check(p policy.Proposal) []string {
+	if p.Time.Weekday() == time.Friday {
+		return []string{"not on a Friday"}
+	}
+	return nil
+}
+
+func TestRegister(t *testing.T) {
+	policy.Register("no_fridays", func(settings.PolicyRule) (policy.Rule, error) { return noFridays{}, nil })
+
+	engine, err := policy.New(settings.Policy{Rules: []settings.PolicyRule{{Type: "no_fridays"}}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	violations := engine.Check(policy.Proposal{Env: "development", Time: time.Date(2025, 6, 6, 9, 0, 0, 0, time.UTC)})
+	if len(violations) != 1 || violations[0].Rule != "no_fridays" {
+		t.Errorf("violations = %v, expected one from no_fridays", violations)
+	}
+}
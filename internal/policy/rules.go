@@ -0,0 +1,218 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/appconfig"
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+)
+
+// enables reports whether a change leaves the flag on when it wasn't
+func enables(change appconfig.FlagChange) bool {
+	if change.Kind == appconfig.ChangeDelete {
+		return false
+	}
+	for _, field := range change.Fields {
+		if field.Field == "enabled" {
+			return field.After == true
+		}
+	}
+	return false
+}
+
+// promotion only turns a flag on in one environment once it is on in
+// another, e.g. staging before production
+type promotion struct {
+	from string
+	to   string
+}
+
+func newPromotion(config settings.PolicyRule) (Rule, error) {
+	if config.From == "" || config.To == "" {
+		return nil, errors.New("from and to are required")
+	}
+	return promotion{from: config.From, to: config.To}, nil
+}
+
+func (r promotion) Name() string {
+	return "promotion"
+}
+
+func (r promotion) Check(p Proposal) []string {
+	if p.Env != r.to {
+		return nil
+	}
+	var messages []string
+	for _, change := range p.Changes {
+		if enables(change) && !p.Enabled[r.from][change.Flag] {
+			messages = append(messages, fmt.Sprintf("%s must be on in %s first", change.Flag, r.from))
+		}
+	}
+	return messages
+}
+
+// maxChanges caps how many flags one deployment changes
+type maxChanges struct {
+	max int
+}
+
+func newMaxChanges(config settings.PolicyRule) (Rule, error) {
+	if config.Max < 1 {
+		return nil, errors.New("max must be at least 1")
+	}
+	return maxChanges{max: config.Max}, nil
+}
+
+func (r maxChanges) Name() string {
+	return "max_changes"
+}
+
+func (r maxChanges) Check(p Proposal) []string {
+	if len(p.Changes) <= r.max {
+		return nil
+	}
+	return []string{fmt.Sprintf("%d flags changed, at most %d per deployment", len(p.Changes), r.max)}
+}
+
+// freeze refuses changes to environments on some days between two times
+// of day, e.g. production on Fridays after 15:00
+type freeze struct {
+	environments []string
+	days         map[time.Weekday]bool
+	// minutes since midnight, before is exclusive
+	after  int
+	before int
+}
+
+func newFreeze(config settings.PolicyRule) (Rule, error) {
+	if len(config.Environments) == 0 {
+		return nil, errors.New("environments are required")
+	}
+	for _, pattern := range config.Environments {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("environments: %q: %w", pattern, err)
+		}
+	}
+
+	r := freeze{environments: config.Environments, days: map[time.Weekday]bool{}, before: 24 * 60}
+	for _, day := range config.Days {
+		weekday, ok := parseWeekday(day)
+		if !ok {
+			return nil, fmt.Errorf("days: unknown day %q", day)
+		}
+		r.days[weekday] = true
+	}
+	// every day unless some are listed
+	if len(r.days) == 0 {
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			r.days[day] = true
+		}
+	}
+
+	var err error
+	if config.After != "" {
+		if r.after, err = parseTimeOfDay(config.After); err != nil {
+			return nil, fmt.Errorf("after: %w", err)
+		}
+	}
+	if config.Before != "" {
+		if r.before, err = parseTimeOfDay(config.Before); err != nil {
+			return nil, fmt.Errorf("before: %w", err)
+		}
+	}
+	return r, nil
+}
+
+func (r freeze) Name() string {
+	return "freeze"
+}
+
+func (r freeze) Check(p Proposal) []string {
+	if p.Env == "" || !r.matchEnv(p.Env) || !r.days[p.Time.Weekday()] {
+		return nil
+	}
+	minute := p.Time.Hour()*60 + p.Time.Minute()
+	if minute < r.after || minute >= r.before {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s is frozen on %s %s", p.Env, p.Time.Weekday(), r.window())}
+}
+
+func (r freeze) matchEnv(env string) bool {
+	for _, pattern := range r.environments {
+		if ok, _ := path.Match(pattern, env); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r freeze) window() string {
+	switch {
+	case r.after > 0 && r.before < 24*60:
+		return fmt.Sprintf("from %s until %s", formatTimeOfDay(r.after), formatTimeOfDay(r.before))
+	case r.after > 0:
+		return "after " + formatTimeOfDay(r.after)
+	case r.before < 24*60:
+		return "before " + formatTimeOfDay(r.before)
+	}
+	return "all day"
+}
+
+func parseWeekday(day string) (time.Weekday, bool) {
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		name := weekday.String()
+		if strings.EqualFold(day, name) || strings.EqualFold(day, name[:3]) {
+			return weekday, true
+		}
+	}
+	return 0, false
+}
+
+// parseTimeOfDay returns the minutes since midnight of "15:04"
+func parseTimeOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day like 15:00", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func formatTimeOfDay(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// keyPattern refuses changes to flags whose key doesn't match
+type keyPattern struct {
+	pattern *regexp.Regexp
+}
+
+func newKeyPattern(config settings.PolicyRule) (Rule, error) {
+	if config.Pattern == "" {
+		return nil, errors.New("pattern is required")
+	}
+	pattern, err := regexp.Compile(config.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("pattern: %w", err)
+	}
+	return keyPattern{pattern: pattern}, nil
+}
+
+func (r keyPattern) Name() string {
+	return "key_pattern"
+}
+
+func (r keyPattern) Check(p Proposal) []string {
+	var messages []string
+	for _, change := range p.Changes {
+		if change.Kind != appconfig.ChangeDelete && !r.pattern.MatchString(change.Flag) {
+			messages = append(messages, fmt.Sprintf("%s doesn't match %s", change.Flag, r.pattern))
+		}
+	}
+	return messages
+}
@@ -0,0 +1,282 @@
+// Package settings reads the user's settings file, config.yaml in the
+// LazyFlags directory of os.UserConfigDir. Command line flags override it.
+package settings
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	filename = "config.yaml"
+	dir      = "LazyFlags"
+)
+
+// File is the content of the settings file
+//
+//	cache:
+//	  ttl: 5m
+//	  apps_ttl: 24h
+//	  stale_while_revalidate: true
+//	  encryption: keyring
+//	  profiles:
+//	    Wordle/WebFeatureFlags: 30s
+//	protected:
+//	  environments: ["prod*"]
+//	  tags:
+//	    Protected: "true"
+//	  block: true
+//	approval:
+//	  environments: [production]
+//	deploy:
+//	  strategy: AppConfig.Linear50PercentEvery30Seconds
+//	policy:
+//	  rules:
+//	    - type: promotion
+//	      from: staging
+//	      to: production
+//	    - type: max_changes
+//	      max: 3
+//	    - type: freeze
+//	      environments: ["prod*"]
+//	      days: [friday]
+//	      after: "15:00"
+//	    - type: key_pattern
+//	      pattern: ^[a-z_]+$
+type File struct {
+	Cache     Cache     `yaml:"cache"`
+	Protected Protected `yaml:"protected"`
+	Approval  Approval  `yaml:"approval"`
+	Deploy    Deploy    `yaml:"deploy"`
+	Policy    Policy    `yaml:"policy"`
+}
+
+// Cache decides how long fetched flags and lists are served from the file
+// cache
+type Cache struct {
+	// how long flags are fresh after they were fetched
+	TTL time.Duration `yaml:"ttl"`
+	// how long the lists of apps, profiles and environments are fresh. The
+	// TUI shows expired lists at once and refetches them in the background.
+	AppsTTL         time.Duration `yaml:"apps_ttl"`
+	ProfilesTTL     time.Duration `yaml:"profiles_ttl"`
+	EnvironmentsTTL time.Duration `yaml:"environments_ttl"`
+	// serve expired flags at once and refetch them in the background
+	StaleWhileRevalidate bool `yaml:"stale_while_revalidate"`
+	// TTL per configuration profile keyed by "app/profile", both may be a
+	// name or an id
+	Profiles map[string]time.Duration `yaml:"profiles"`
+	// encrypts the cache file, one of the Encryption constants
+	Encryption string `yaml:"encryption"`
+	// serve everything from the cache whatever its age and never call AWS,
+	// only set by --offline
+	Offline bool `yaml:"-"`
+}
+
+// Protected marks environments whose changes must be confirmed by typing
+// the environment or flag name
+type Protected struct {
+	// path.Match patterns of environment names, e.g. "prod*"
+	Environments []string `yaml:"environments"`
+	// environments tagged with any of these, an empty value matches every
+	// value of the tag
+	Tags map[string]string `yaml:"tags"`
+	// changes to protected environments are refused without --elevated
+	Block bool `yaml:"block"`
+	// only set by --elevated
+	Elevated bool `yaml:"-"`
+}
+
+// MatchName reports whether an environment is protected by its name
+func (p Protected) MatchName(name string) bool {
+	return matchAny(p.Environments, name)
+}
+
+// MatchTags reports whether an environment is protected by its tags
+func (p Protected) MatchTags(tags map[string]string) bool {
+	for key, value := range p.Tags {
+		if tag, ok := tags[key]; ok && (value == "" || value == tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocked reports whether changes to protected environments are refused
+func (p Protected) Blocked() bool {
+	return p.Block && !p.Elevated
+}
+
+// Approval lists the environments whose changes are proposed instead of
+// deployed, another user approves and deploys them
+type Approval struct {
+	// path.Match patterns of environment names
+	Environments []string `yaml:"environments"`
+}
+
+// Required reports whether changes to an environment need an approval
+func (a Approval) Required(name string) bool {
+	return matchAny(a.Environments, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Deploy configures the deployments lazyflags starts
+type Deploy struct {
+	// deployment strategy id of the TUI's deployments and the default of
+	// --strategy, empty for AppConfig.AllAtOnce
+	Strategy string `yaml:"strategy"`
+}
+
+// Policy lists the rules a change must pass before a version is created,
+// the policy package builds them
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule configures one rule, Type picks which and the fields it uses
+type PolicyRule struct {
+	Type string `yaml:"type"`
+	// promotion: a flag is only turned on in To once it is on in From
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	// max_changes: flags changed per deployment
+	Max int `yaml:"max"`
+	// freeze: path.Match patterns of the environments, the days and the
+	// time of day ("15:00") from and until which they're frozen
+	Environments []string `yaml:"environments"`
+	Days         []string `yaml:"days"`
+	After        string   `yaml:"after"`
+	Before       string   `yaml:"before"`
+	// key_pattern: regular expression changed flag keys must match
+	Pattern string `yaml:"pattern"`
+}
+
+// How the cache file is encrypted
+const (
+	// the file is plaintext JSON
+	EncryptionNone = ""
+	// with a random key kept in the OS keyring
+	EncryptionKeyring = "keyring"
+	// with a key derived from the LAZYFLAGS_CACHE_PASSPHRASE env var
+	EncryptionPassphrase = "passphrase"
+)
+
+// Default is used for settings the file leaves out
+func Default() File {
+	return File{
+		Cache: Cache{
+			TTL:             time.Minute,
+			AppsTTL:         time.Hour,
+			ProfilesTTL:     time.Hour,
+			EnvironmentsTTL: time.Hour,
+		},
+	}
+}
+
+// Path returns where the settings file is read from
+func Path() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, dir, filename), nil
+}
+
+// Load reads the settings file, a missing file means the defaults
+func Load() (File, error) {
+	path, err := Path()
+	if err != nil {
+		return File{}, err
+	}
+	return LoadFile(path)
+}
+
+// LoadFile reads the settings file at path
+func LoadFile(path string) (File, error) {
+	f := Default()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return File{}, err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	// a misspelt key would otherwise silently leave its default in place,
+	// e.g. an unprotected environment
+	dec.KnownFields(true)
+	// an empty file decodes to io.EOF and keeps every default
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return File{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := f.validate(); err != nil {
+		return File{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+func (f File) validate() error {
+	ttls := map[string]time.Duration{
+		"ttl":              f.Cache.TTL,
+		"apps_ttl":         f.Cache.AppsTTL,
+		"profiles_ttl":     f.Cache.ProfilesTTL,
+		"environments_ttl": f.Cache.EnvironmentsTTL,
+	}
+	for name, ttl := range ttls {
+		if ttl < 0 {
+			return fmt.Errorf("cache.%s must not be negative", name)
+		}
+	}
+	for profile, ttl := range f.Cache.Profiles {
+		if ttl < 0 {
+			return fmt.Errorf("cache.profiles.%s must not be negative", profile)
+		}
+	}
+	patterns := map[string][]string{
+		"protected.environments": f.Protected.Environments,
+		"approval.environments":  f.Approval.Environments,
+	}
+	for name, list := range patterns {
+		for _, pattern := range list {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("%s: %q: %w", name, pattern, err)
+			}
+		}
+	}
+	switch f.Cache.Encryption {
+	case EncryptionNone, EncryptionKeyring, EncryptionPassphrase:
+	default:
+		return fmt.Errorf("cache.encryption must be %s or %s, got %q", EncryptionKeyring, EncryptionPassphrase, f.Cache.Encryption)
+	}
+	return nil
+}
+
+// ProfileTTL returns the TTL of a configuration profile, its override if
+// there is one
+func (c Cache) ProfileTTL(appId, appName, profileId, profileName string) time.Duration {
+	for _, app := range []string{appId, appName} {
+		for _, profile := range []string{profileId, profileName} {
+			if ttl, ok := c.Profiles[app+"/"+profile]; ok {
+				return ttl
+			}
+		}
+	}
+	return c.TTL
+}
@@ -0,0 +1,207 @@
+package settings_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/simonschwartz/app-config-lazy-flags/internal/settings"
+)
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected settings.File
+		wantErr  bool
+	}{
+		{
+			name:     "should use the defaults without a file",
+			expected: settings.Default(),
+		},
+		{
+			name: "should read cache settings",
+			content: `cache:
+  ttl: 5m
+  apps_ttl: 24h
+  profiles_ttl: 2h
+  environments_ttl: 30m
+  stale_while_revalidate: true
+  encryption: keyring
+  profiles:
+    Wordle/WebFeatureFlags: 30s
+`,
+			expected: settings.File{Cache: settings.Cache{
+				TTL:                  5 * time.Minute,
+				AppsTTL:              24 * time.Hour,
+				ProfilesTTL:          2 * time.Hour,
+				EnvironmentsTTL:      30 * time.Minute,
+				StaleWhileRevalidate: true,
+				Encryption:           settings.EncryptionKeyring,
+				Profiles:             map[string]time.Duration{"Wordle/WebFeatureFlags": 30 * time.Second},
+			}},
+		},
+		{
+			name:    "should keep defaults the file leaves out",
+			content: "cache:\n  stale_while_revalidate: true\n",
+			expected: settings.File{Cache: settings.Cache{
+				TTL:                  time.Minute,
+				AppsTTL:              time.Hour,
+				ProfilesTTL:          time.Hour,
+				EnvironmentsTTL:      time.Hour,
+				StaleWhileRevalidate: true,
+			}},
+		},
+		{
+			name:    "should reject a negative ttl",
+			content: "cache:\n  ttl: -1m\n",
+			wantErr: true,
+		},
+		{
+			name:    "should reject a negative list ttl",
+			content: "cache:\n  apps_ttl: -1h\n",
+			wantErr: true,
+		},
+		{
+			name: "should read protected environments",
+			content: `protected:
+  environments: ["prod*", live]
+  tags:
+    Protected: "true"
+  block: true
+`,
+			expected: settings.File{
+				Cache: settings.Default().Cache,
+				Protected: settings.Protected{
+					Environments: []string{"prod*", "live"},
+					Tags:         map[string]string{"Protected": "true"},
+					Block:        true,
+				},
+			},
+		},
+		{
+			name:    "should reject an invalid environment pattern",
+			content: "protected:\n  environments: [\"prod[\"]\n",
+			wantErr: true,
+		},
+		{
+			name:    "should read approval environments",
+			content: "approval:\n  environments: [\"prod*\"]\n",
+			expected: settings.File{
+				Cache:    settings.Default().Cache,
+				Approval: settings.Approval{Environments: []string{"prod*"}},
+			},
+		},
+		{
+			name:    "should reject an invalid approval pattern",
+			content: "approval:\n  environments: [\"[\"]\n",
+			wantErr: true,
+		},
+		{
+			name:    "should read the deployment strategy",
+			content: "deploy:\n  strategy: AppConfig.Linear50PercentEvery30Seconds\n",
+			expected: settings.File{
+				Cache:  settings.Default().Cache,
+				Deploy: settings.Deploy{Strategy: "AppConfig.Linear50PercentEvery30Seconds"},
+			},
+		},
+		{
+			name:    "should reject an unknown encryption",
+			content: "cache:\n  encryption: rot13\n",
+			wantErr: true,
+		},
+		{
+			name:    "should reject an unknown key",
+			content: "protected:\n  enviroments: [\"prod*\"]\n",
+			wantErr: true,
+		},
+		{
+			name:     "should use the defaults with an empty file",
+			content:  "# nothing set yet\n",
+			expected: settings.Default(),
+		},
+		{
+			name:    "should reject an invalid duration",
+			content: "cache:\n  ttl: soon\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			f, err := settings.LoadFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(f, tt.expected) {
+				t.Errorf("LoadFile() = %+v, expected %+v", f, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProfileTTL(t *testing.T) {
+	cache := settings.Cache{
+		TTL: time.Minute,
+		Profiles: map[string]time.Duration{
+			"Wordle/WebFeatureFlags": 30 * time.Second,
+			"wordle1/apiflg1":        10 * time.Minute,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		profile  [4]string
+		expected time.Duration
+	}{
+		{"should match names", [4]string{"wordle1", "Wordle", "webflg1", "WebFeatureFlags"}, 30 * time.Second},
+		{"should match ids", [4]string{"wordle1", "Wordle", "apiflg1", "APIFeatureFlags"}, 10 * time.Minute},
+		{"should fall back to the ttl", [4]string{"wordle1", "Wordle", "iosflg1", "iOSAppFeatureFlags"}, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.profile
+			if ttl := cache.ProfileTTL(p[0], p[1], p[2], p[3]); ttl != tt.expected {
+				t.Errorf("ProfileTTL() = %s, expected %s", ttl, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProtected(t *testing.T) {
+	protected := settings.Protected{
+		Environments: []string{"prod*"},
+		Tags:         map[string]string{"Protected": "true", "Tier": ""},
+	}
+
+	tests := []struct {
+		name     string
+		env      string
+		tags     map[string]string
+		expected bool
+	}{
+		{"should match a name pattern", "production", nil, true},
+		{"should match a tag value", "live", map[string]string{"Protected": "true"}, true},
+		{"should match any value of a tag without one", "live", map[string]string{"Tier": "1"}, true},
+		{"should not match another tag value", "live", map[string]string{"Protected": "false"}, false},
+		{"should not match other environments", "staging", map[string]string{"Team": "web"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := protected.MatchName(tt.env) || protected.MatchTags(tt.tags); got != tt.expected {
+				t.Errorf("protected = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}